@@ -0,0 +1,110 @@
+//coverage:ignore file
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// annotationTemplates are the built-in annotation formats. They render the
+// coverage report into the payloads expected by the code hosting platforms,
+// posting them is left to the CI pipeline.
+var annotationTemplates = map[string]string{
+	// GitHub Actions workflow commands, printed in the job log
+	"github": `{{range annotations .}}::notice file={{.Path}},line={{.StartLine}},endLine={{.EndLine}},title=Excluded from coverage::{{.Statements}} statement(s) excluded from coverage{{if .Source}} by {{.Source}}{{end}}{{if .Reason}}: {{.Reason}}{{end}}
+{{end}}::notice title=Coverage::{{percent .Total.Coverage}}% of statements covered, {{.Total.Excluded}} statement(s) excluded
+`,
+	// Bitbucket Code Insights, the report and the annotations are sent with
+	// two different requests
+	"bitbucket": `{
+  "report": {
+    "title": "Coverage",
+    "report_type": "COVERAGE",
+    "result": "PASSED",
+    "details": {{json (printf "%s%% of statements covered, %d statement(s) excluded" (percent .Total.Coverage) .Total.Excluded)}},
+    "data": [
+      {"title": "Coverage", "type": "PERCENTAGE", "value": {{percent .Total.Coverage}}},
+      {"title": "Excluded statements", "type": "NUMBER", "value": {{.Total.Excluded}}}
+    ]
+  },
+  "annotations": [{{range $i, $a := annotations .}}{{if $i}},{{end}}
+    {
+      "external_id": {{json (printf "exclusion-%s-%d-%d" $a.Path $a.StartLine $a.StartCol)}},
+      "annotation_type": "CODE_SMELL",
+      "severity": "LOW",
+      "path": {{json $a.Path}},
+      "line": {{$a.StartLine}},
+      "summary": {{json (printf "%d statement(s) excluded from coverage" $a.Statements)}}{{if $a.Source}},
+      "details": {{json (printf "Excluded by %s" $a.Source)}}{{end}}
+    }{{end}}
+  ]
+}
+`,
+	// Gitea commit status
+	"gitea": `{
+  "context": "coverage",
+  "state": "success",
+  "description": {{json (printf "%s%% of statements covered, %d statement(s) excluded" (percent .Total.Coverage) .Total.Excluded)}}
+}
+`,
+}
+
+// Annotation is an exclusion along with the path of its file
+type Annotation struct {
+	Path string
+	Exclusion
+}
+
+var annotationFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"percent": func(v float64) string {
+		return fmt.Sprintf("%.1f", v)
+	},
+	"annotations": func(report *Report) []Annotation {
+		annotations := []Annotation{}
+		for _, file := range report.Files {
+			for _, exclusion := range file.Exclusions {
+				annotations = append(annotations, Annotation{Path: file.Path, Exclusion: exclusion})
+			}
+		}
+		return annotations
+	},
+}
+
+func annotationFormats() []string {
+	formats := []string{}
+	for format := range annotationTemplates {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// writeAnnotations renders the report with the built-in template of the
+// format, or with the template file when format is empty
+func writeAnnotations(report *Report, format string, templateFile string, w io.Writer) error {
+	text, ok := annotationTemplates[format]
+	if templateFile != "" {
+		data, err := os.ReadFile(templateFile)
+		if err != nil {
+			return err
+		}
+		text, ok = string(data), true
+	}
+	if !ok {
+		return fmt.Errorf("unknown annotations format [%s], expected one of %s", format, strings.Join(annotationFormats(), ", "))
+	}
+	tmpl, err := template.New("annotations").Funcs(annotationFuncs).Parse(text)
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(w, report)
+}
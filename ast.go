@@ -0,0 +1,647 @@
+//coverage:ignore file
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/importer"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// declDirective is a directive targeting the declaration starting at Line
+type declDirective struct {
+	Line      int
+	Directive Directive
+}
+
+// sourceFile is a parsed go source file, used by the instructions that need
+// to know about declarations rather than lines
+type sourceFile struct {
+	Path string
+	Fset *token.FileSet
+	File *ast.File
+}
+
+func parseSource(path string, content []byte) (*sourceFile, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, path, content, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	return &sourceFile{Path: path, Fset: fset, File: file}, nil
+}
+
+func (s *sourceFile) line(pos token.Pos) int {
+	return s.Fset.Position(pos).Line
+}
+
+// bodyRange returns an instruction ignoring all the blocks of a function body
+func (s *sourceFile) bodyRange(body *ast.BlockStmt) IgnoreRange {
+	start := s.Fset.Position(body.Lbrace)
+	end := s.Fset.Position(body.Rbrace)
+	return IgnoreRange{
+		StartLine: start.Line,
+		StartCol:  start.Column,
+		EndLine:   end.Line,
+		EndCol:    end.Column,
+	}
+}
+
+// nodeRange returns an instruction ignoring all the blocks starting in the
+// extent of a node
+func (s *sourceFile) nodeRange(node ast.Node) IgnoreRange {
+	start := s.Fset.Position(node.Pos())
+	end := s.Fset.Position(node.End())
+	return IgnoreRange{
+		StartLine: start.Line,
+		StartCol:  start.Column,
+		EndLine:   end.Line,
+		EndCol:    end.Column,
+	}
+}
+
+// blockCommentLines returns the lines of the block comments written as line
+// comments, so the directives of the /* */ comments are recognized like the
+// others. The leading * of the lines of multi-line comments is removed. The
+// content is tokenized, a /* in a string does not start a comment.
+func blockCommentLines(content []byte) map[int]string {
+	lines := map[int]string{}
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(content))
+	var s scanner.Scanner
+	s.Init(file, content, nil, scanner.ScanComments)
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		if tok != token.COMMENT || !strings.HasPrefix(lit, "/*") {
+			continue
+		}
+		line := fset.Position(pos).Line
+		//the code before the comment makes it an inline directive
+		before := string(content[file.Offset(file.LineStart(line)):file.Offset(pos)])
+		text := strings.TrimSuffix(strings.TrimPrefix(lit, "/*"), "*/")
+		for i, commentLine := range strings.Split(text, "\n") {
+			commentLine = strings.TrimPrefix(strings.TrimSpace(commentLine), "*")
+			lines[line+i] = "//" + strings.TrimSpace(commentLine)
+		}
+		lines[line] = before + lines[line]
+	}
+	return lines
+}
+
+// docCommentLines maps the lines of the comments documenting a function or
+// a type declaration to the line where the declaration starts. The comments
+// are associated to the declarations with an ast.CommentMap, so they do not
+// have to be right above the declaration.
+func (s *sourceFile) docCommentLines() map[int]int {
+	lines := map[int]int{}
+	cmap := ast.NewCommentMap(s.Fset, s.File, s.File.Comments)
+	for _, decl := range s.File.Decls {
+		if genDecl, ok := decl.(*ast.GenDecl); ok && genDecl.Tok != token.TYPE {
+			continue
+		}
+		for _, group := range cmap[decl] {
+			if group.End() > decl.Pos() {
+				//trailing comment, not documenting the declaration
+				continue
+			}
+			for _, comment := range group.List {
+				for line := s.line(comment.Pos()); line <= s.line(comment.End()); line++ {
+					lines[line] = s.line(decl.Pos())
+				}
+			}
+		}
+	}
+	return lines
+}
+
+// stmtAnchor returns the range where the block of the statement starting at
+// line and col can start, for the statements whose block does not contain
+// their first line in every Go version. The body of a case clause starts a
+// block at the colon or at its first statement, and a labeled statement, like
+// the target of a goto statement, at the label or at the statement. Other
+// statements return nil, the block containing their first line is the one
+// ignored.
+func (s *sourceFile) stmtAnchor(line int, col int) *IgnoreRange {
+	var anchor *IgnoreRange
+	ast.Inspect(s.File, func(node ast.Node) bool {
+		if anchor != nil || node == nil {
+			return false
+		}
+		start := s.Fset.Position(node.Pos())
+		if start.Line > line {
+			return false
+		}
+		if start.Line != line || start.Column != col {
+			return true
+		}
+		var end token.Pos
+		switch n := node.(type) {
+		case *ast.CaseClause:
+			if len(n.Body) > 0 {
+				end = n.Body[0].Pos()
+			}
+		case *ast.CommClause:
+			if len(n.Body) > 0 {
+				end = n.Body[0].Pos()
+			}
+		case *ast.LabeledStmt:
+			end = n.Stmt.Pos()
+		}
+		if end.IsValid() {
+			endPosition := s.Fset.Position(end)
+			anchor = &IgnoreRange{
+				StartLine: start.Line,
+				StartCol:  start.Column,
+				EndLine:   endPosition.Line,
+				EndCol:    endPosition.Column,
+			}
+		}
+		return true
+	})
+	return anchor
+}
+
+// stmtAt returns the outermost statement starting on the given line
+func (s *sourceFile) stmtAt(line int) (ast.Stmt, bool) {
+	var found ast.Stmt
+	ast.Inspect(s.File, func(node ast.Node) bool {
+		if found != nil || node == nil || s.line(node.Pos()) > line || s.line(node.End()) < line {
+			return false
+		}
+		if stmt, ok := node.(ast.Stmt); ok && s.line(stmt.Pos()) == line {
+			found = stmt
+			return false
+		}
+		return true
+	})
+	return found, found != nil
+}
+
+// closuresAt returns the bodies of the function literals of the statement
+// starting on the given line. The literals of its nested blocks are left
+// out, like the blocks themselves, they are not ignored by a block directive.
+func (s *sourceFile) closuresAt(line int) []IgnoreRange {
+	closures := []IgnoreRange{}
+	stmt, ok := s.stmtAt(line)
+	if !ok {
+		return closures
+	}
+	ast.Inspect(stmt, func(node ast.Node) bool {
+		switch n := node.(type) {
+		case *ast.FuncLit:
+			closures = append(closures, s.bodyRange(n.Body))
+			return false
+		case *ast.BlockStmt, *ast.CaseClause, *ast.CommClause:
+			return false
+		}
+		return true
+	})
+	return closures
+}
+
+// branchAt returns the range of the branch starting on the given line: the
+// body of an if or else if statement, the body of an else, or the body of a
+// case clause
+func (s *sourceFile) branchAt(line int) (IgnoreRange, bool) {
+	var branch *IgnoreRange
+	ast.Inspect(s.File, func(node ast.Node) bool {
+		if branch != nil || node == nil || s.line(node.Pos()) > line || s.line(node.End()) < line {
+			return false
+		}
+		switch n := node.(type) {
+		case *ast.IfStmt:
+			if s.line(n.If) == line {
+				body := s.bodyRange(n.Body)
+				branch = &body
+			} else if els, ok := n.Else.(*ast.BlockStmt); ok && s.line(els.Lbrace) == line {
+				body := s.bodyRange(els)
+				branch = &body
+			}
+		case *ast.CaseClause:
+			if s.line(n.Case) == line {
+				clause := s.clauseRange(n.Colon, n.End())
+				branch = &clause
+			}
+		case *ast.CommClause:
+			if s.line(n.Case) == line {
+				clause := s.clauseRange(n.Colon, n.End())
+				branch = &clause
+			}
+		}
+		return branch == nil
+	})
+	if branch == nil {
+		return IgnoreRange{}, false
+	}
+	return *branch, true
+}
+
+// clauseRange returns the range of the body of a case clause, from its colon
+func (s *sourceFile) clauseRange(colon token.Pos, end token.Pos) IgnoreRange {
+	start := s.Fset.Position(colon)
+	endPosition := s.Fset.Position(end)
+	return IgnoreRange{
+		StartLine: start.Line,
+		StartCol:  start.Column,
+		EndLine:   endPosition.Line,
+		EndCol:    endPosition.Column,
+	}
+}
+
+// funcDeclAt returns the function declared on the given line
+func (s *sourceFile) funcDeclAt(line int) (*ast.FuncDecl, bool) {
+	for _, decl := range s.File.Decls {
+		if funcDecl, ok := decl.(*ast.FuncDecl); ok && s.line(funcDecl.Pos()) == line {
+			return funcDecl, true
+		}
+	}
+	return nil, false
+}
+
+// declInstructions returns the instructions of a directive targeting a
+// declaration
+func declInstructions(src *sourceFile, directive declDirective, checked *packageTypes) ([]Instruction, error) {
+	if _, ok := directive.Directive.Options[OptionImpl]; ok {
+		return implInstructions(src, directive, checked)
+	}
+	if names, ok := directive.Directive.Options[OptionFuncs]; ok {
+		return funcsInstructions(src, directive, names)
+	}
+	if names, ok := directive.Directive.Options[OptionExcept]; ok {
+		return exceptInstructions(src, directive, names)
+	}
+	if scope, ok := directive.Directive.Options[OptionScope]; ok {
+		return scopeInstructions(src, directive, scope)
+	}
+	if directive.Directive.Instruction == InstructionType {
+		return typeInstructions(src, directive)
+	}
+	if directive.Directive.Instruction == InstructionBranch {
+		branch, ok := src.branchAt(directive.Line)
+		if !ok {
+			return nil, fmt.Errorf("the %s instruction must be placed before or on an if, an else or a case, line %d in file [%s]", InstructionBranch, directive.Line, src.Path)
+		}
+		return []Instruction{branch}, nil
+	}
+	if directive.Directive.Instruction == InstructionStmt {
+		stmt, ok := src.stmtAt(directive.Line)
+		if !ok {
+			return nil, fmt.Errorf("the %s instruction must be placed before a statement, line %d in file [%s]", InstructionStmt, directive.Line, src.Path)
+		}
+		//the whole statement is ignored, like an if statement with its else branches
+		return []Instruction{src.nodeRange(stmt)}, nil
+	}
+	funcDecl, ok := src.funcDeclAt(directive.Line)
+	if directive.Directive.Instruction == InstructionOff {
+		if !ok {
+			return nil, fmt.Errorf("the %s instruction must be placed before a function declaration, line %d in file [%s]", InstructionOff, directive.Line, src.Path)
+		}
+		if funcDecl.Body == nil {
+			return nil, nil
+		}
+		return []Instruction{Unignore{Line: directive.Line, Body: src.bodyRange(funcDecl.Body)}}, nil
+	}
+	if directive.Directive.Instruction == InstructionFunc {
+		if !ok {
+			return nil, fmt.Errorf("the %s instruction must be placed before a function declaration, line %d in file [%s]", InstructionFunc, directive.Line, src.Path)
+		}
+		if funcDecl.Body == nil {
+			return nil, nil
+		}
+		return []Instruction{src.bodyRange(funcDecl.Body)}, nil
+	}
+	if !ok || funcDecl.Body == nil {
+		return nil, nil
+	}
+	//the doc comment documents the whole function, all its blocks are ignored
+	body := src.bodyRange(funcDecl.Body)
+	body.Soft = directive.Directive.Instruction == InstructionSoft
+	return []Instruction{body}, nil
+}
+
+// typeInstructions ignores the bodies of the methods of the type following
+// the directive. The methods declared in the other files of the package are
+// ignored through the IgnoreType instruction.
+func typeInstructions(src *sourceFile, directive declDirective) ([]Instruction, error) {
+	typeSpec, ok := src.typeSpecAt(directive.Line)
+	if !ok {
+		return nil, fmt.Errorf("the %s instruction must be placed before a type declaration, line %d in file [%s]", InstructionType, directive.Line, src.Path)
+	}
+	instructions := []Instruction{IgnoreType{File: src.Path, Line: directive.Directive.Line, Type: typeSpec.Name.Name}}
+	for _, method := range src.methods(typeSpec.Name.Name) {
+		if method.Body != nil {
+			instructions = append(instructions, src.bodyRange(method.Body))
+		}
+	}
+	return instructions, nil
+}
+
+// funcsInstructions ignores the bodies of the functions listed in the funcs
+// option
+func funcsInstructions(src *sourceFile, directive declDirective, names string) ([]Instruction, error) {
+	funcDecls, err := src.namedFuncs(directive, OptionFuncs, names)
+	if err != nil {
+		return nil, err
+	}
+	instructions := []Instruction{}
+	for _, funcDecl := range funcDecls {
+		body := src.bodyRange(funcDecl.Body)
+		body.Soft = directive.Directive.Instruction == InstructionSoft
+		instructions = append(instructions, body)
+	}
+	return instructions, nil
+}
+
+// exceptInstructions keeps the functions listed in the except option of a
+// file or package directive measured, like an off directive on each of them
+func exceptInstructions(src *sourceFile, directive declDirective, names string) ([]Instruction, error) {
+	funcDecls, err := src.namedFuncs(directive, OptionExcept, names)
+	if err != nil {
+		return nil, err
+	}
+	instructions := []Instruction{}
+	for _, funcDecl := range funcDecls {
+		instructions = append(instructions, Unignore{Line: src.line(funcDecl.Pos()), Body: src.bodyRange(funcDecl.Body)})
+	}
+	return instructions, nil
+}
+
+// namedFuncs returns the functions with a body listed in an option. A name
+// matches the functions and the methods with that name, or a single method
+// when qualified with its receiver type, like Conn.Close.
+func (s *sourceFile) namedFuncs(directive declDirective, option string, names string) ([]*ast.FuncDecl, error) {
+	funcDecls := []*ast.FuncDecl{}
+	for _, name := range strings.Split(names, ",") {
+		typeName, funcName, qualified := strings.Cut(name, ".")
+		if !qualified {
+			typeName, funcName = "", name
+		}
+		found := false
+		for _, decl := range s.File.Decls {
+			funcDecl, ok := decl.(*ast.FuncDecl)
+			if !ok || funcDecl.Name.Name != funcName || (qualified && receiverTypeName(funcDecl) != typeName) {
+				continue
+			}
+			found = true
+			if funcDecl.Body != nil {
+				funcDecls = append(funcDecls, funcDecl)
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("function %s of the %s option not found, line %d in file [%s]", name, option, directive.Line, s.Path)
+		}
+	}
+	return funcDecls, nil
+}
+
+// scopeInstructions ignores the blocks of the function following the
+// directive that are part of the scope
+func scopeInstructions(src *sourceFile, directive declDirective, scope string) ([]Instruction, error) {
+	if scope != ScopeErrorReturns {
+		return nil, fmt.Errorf("unknown scope [%s], expected %s, line %d in file [%s]", scope, ScopeErrorReturns, directive.Line, src.Path)
+	}
+	funcDecl, ok := src.funcDeclAt(directive.Line)
+	if !ok || funcDecl.Body == nil {
+		return nil, fmt.Errorf("the %s option must be placed before a function declaration, line %d in file [%s]", OptionScope, directive.Line, src.Path)
+	}
+	instructions := []Instruction{}
+	ast.Inspect(funcDecl.Body, func(node ast.Node) bool {
+		if _, ok := node.(*ast.FuncLit); ok {
+			//the returns of a closure are not the returns of the function
+			return false
+		}
+		ret, ok := node.(*ast.ReturnStmt)
+		if !ok || !returnsError(ret) || isTopLevel(funcDecl.Body, ret) {
+			return true
+		}
+		//the block ending with the return is ignored, along with the
+		//statements preparing the error
+		start := src.Fset.Position(ret.Pos())
+		instructions = append(instructions, IgnoreBlock{
+			Line: start.Line,
+			Col:  start.Column,
+			Soft: directive.Directive.Instruction == InstructionSoft,
+		})
+		return true
+	})
+	return instructions, nil
+}
+
+// returnsError reports whether the return statement propagates an error, its
+// last result being an err variable, or a call wrapping it like
+// fmt.Errorf("...: %w", err)
+func returnsError(ret *ast.ReturnStmt) bool {
+	if len(ret.Results) == 0 {
+		return false
+	}
+	switch last := ret.Results[len(ret.Results)-1].(type) {
+	case *ast.Ident:
+		return last.Name == "err"
+	case *ast.CallExpr:
+		for _, arg := range last.Args {
+			if ident, ok := arg.(*ast.Ident); ok && ident.Name == "err" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isTopLevel reports whether the statement is directly in the function body,
+// its block is then the main logic of the function
+func isTopLevel(body *ast.BlockStmt, stmt ast.Stmt) bool {
+	for _, s := range body.List {
+		if s == stmt {
+			return true
+		}
+	}
+	return false
+}
+
+// typeSpecAt returns the type declared on the given line
+func (s *sourceFile) typeSpecAt(line int) (*ast.TypeSpec, bool) {
+	for _, decl := range s.File.Decls {
+		genDecl, ok := decl.(*ast.GenDecl)
+		if !ok || genDecl.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range genDecl.Specs {
+			typeSpec := spec.(*ast.TypeSpec)
+			if s.line(typeSpec.Pos()) == line || (s.line(genDecl.Pos()) == line && len(genDecl.Specs) == 1) {
+				return typeSpec, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// methods returns the methods declared in the file for the receiver type
+func (s *sourceFile) methods(typeName string) []*ast.FuncDecl {
+	methods := []*ast.FuncDecl{}
+	for _, decl := range s.File.Decls {
+		if funcDecl, ok := decl.(*ast.FuncDecl); ok && receiverTypeName(funcDecl) == typeName {
+			methods = append(methods, funcDecl)
+		}
+	}
+	return methods
+}
+
+// receiverTypeName returns the name of the receiver base type of a method,
+// or an empty string for functions
+func receiverTypeName(funcDecl *ast.FuncDecl) string {
+	if funcDecl.Recv == nil || len(funcDecl.Recv.List) == 0 {
+		return ""
+	}
+	expr := funcDecl.Recv.List[0].Type
+	for {
+		switch e := expr.(type) {
+		case *ast.StarExpr:
+			expr = e.X
+		case *ast.ParenExpr:
+			expr = e.X
+		case *ast.IndexExpr:
+			expr = e.X
+		case *ast.IndexListExpr:
+			expr = e.X
+		case *ast.Ident:
+			return e.Name
+		default:
+			return ""
+		}
+	}
+}
+
+// implInstructions ignores the methods of the type following the directive
+// that implement one of the interfaces listed in the impl option
+func implInstructions(src *sourceFile, directive declDirective, checked *packageTypes) ([]Instruction, error) {
+	typeSpec, ok := src.typeSpecAt(directive.Line)
+	if !ok {
+		return nil, fmt.Errorf("the %s option must be placed before a type declaration, line %d in file [%s]", OptionImpl, directive.Line, src.Path)
+	}
+	pkg, err := checked.load(filepath.Dir(src.Path))
+	if err != nil {
+		return nil, err
+	}
+	typ := pkg.Scope().Lookup(typeSpec.Name.Name)
+	if typ == nil {
+		return nil, fmt.Errorf("type %s not found in package %s", typeSpec.Name.Name, pkg.Path())
+	}
+	methodNames := map[string]bool{}
+	for _, name := range strings.Split(directive.Directive.Options[OptionImpl], ",") {
+		iface, err := lookupInterface(src, pkg, name)
+		if err != nil {
+			return nil, err
+		}
+		if !types.Implements(typ.Type(), iface) && !types.Implements(types.NewPointer(typ.Type()), iface) {
+			return nil, fmt.Errorf("type %s does not implement %s, line %d in file [%s]", typ.Name(), name, directive.Line, src.Path)
+		}
+		for i := 0; i < iface.NumMethods(); i++ {
+			methodNames[iface.Method(i).Name()] = true
+		}
+	}
+	instructions := []Instruction{}
+	for _, method := range src.methods(typeSpec.Name.Name) {
+		if methodNames[method.Name.Name] && method.Body != nil {
+			instructions = append(instructions, src.bodyRange(method.Body))
+		}
+	}
+	return instructions, nil
+}
+
+// lookupInterface finds an interface by the name it has in the source file,
+// either qualified with the package name (io.Closer) or not (error, or an
+// interface declared in the same package)
+func lookupInterface(src *sourceFile, pkg *types.Package, name string) (*types.Interface, error) {
+	var obj types.Object
+	if qualifier, typeName, ok := strings.Cut(name, "."); ok {
+		imported, found := importedPackage(src, pkg, qualifier)
+		if !found {
+			return nil, fmt.Errorf("package %s is not imported in file [%s]", qualifier, src.Path)
+		}
+		obj = imported.Scope().Lookup(typeName)
+	} else {
+		obj = pkg.Scope().Lookup(name)
+		if obj == nil {
+			obj = types.Universe.Lookup(name)
+		}
+	}
+	if obj == nil {
+		return nil, fmt.Errorf("interface %s not found for file [%s]", name, src.Path)
+	}
+	iface, ok := obj.Type().Underlying().(*types.Interface)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an interface", name)
+	}
+	return iface, nil
+}
+
+func importedPackage(src *sourceFile, pkg *types.Package, qualifier string) (*types.Package, bool) {
+	for _, spec := range src.File.Imports {
+		path, _ := strconv.Unquote(spec.Path.Value)
+		for _, imported := range pkg.Imports() {
+			if imported.Path() != path {
+				continue
+			}
+			if (spec.Name != nil && spec.Name.Name == qualifier) || (spec.Name == nil && imported.Name() == qualifier) {
+				return imported, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// packageTypes type checks the packages of the impl option, once per
+// directory. A correction has its own, so the packages updated between two
+// corrections of a server are checked again. It is not safe for concurrent
+// use.
+type packageTypes struct {
+	packages map[string]*types.Package
+	// importer type checks the imported packages from their source, so it
+	// does not depend on the export data format of the installed toolchain
+	importer types.ImporterFrom
+}
+
+func newPackageTypes() *packageTypes {
+	return &packageTypes{
+		packages: map[string]*types.Package{},
+		importer: importer.ForCompiler(token.NewFileSet(), "source", nil).(types.ImporterFrom),
+	}
+}
+
+// load type checks the package in dir. Type errors are tolerated, the types
+// are only used to resolve declarations.
+func (t *packageTypes) load(dir string) (*types.Package, error) {
+	if pkg, ok := t.packages[dir]; ok {
+		return pkg, nil
+	}
+	buildPkg, err := build.ImportDir(dir, 0)
+	if err != nil {
+		return nil, err
+	}
+	fset := token.NewFileSet()
+	files := []*ast.File{}
+	for _, name := range buildPkg.GoFiles {
+		file, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	conf := types.Config{
+		Importer: t.importer,
+		Error:    func(err error) {},
+	}
+	pkg, _ := conf.Check(buildPkg.ImportPath, fset, files, nil)
+	t.packages[dir] = pkg
+	return pkg, nil
+}
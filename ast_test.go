@@ -0,0 +1,138 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/cover"
+)
+
+// writeTestFiles writes files in a temporary directory and returns its path.
+// The directives are written @coverage:ignore in the contents, so they do not
+// apply to the test file.
+func writeTestFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(strings.ReplaceAll(content, "@coverage:ignore", "//coverage:ignore")), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// excludedLines returns the start lines of the blocks matched by the
+// instructions
+func excludedLines(instructions []Instruction, blocks []cover.ProfileBlock) []int {
+	lines := []int{}
+	for _, block := range blocks {
+		if _, ok := matchInstruction(instructions, block); ok {
+			lines = append(lines, block.StartLine)
+		}
+	}
+	return lines
+}
+
+const greeterSource = `package impl
+
+import (
+	"fmt"
+	"io"
+)
+
+@coverage:ignore impl=IMPL
+type Greeter struct {
+	Name string
+}
+
+func (g Greeter) String() string {
+	return fmt.Sprintf("Greeter(%s)", g.Name)
+}
+
+func (g *Greeter) Close() error {
+	return nil
+}
+
+func (g Greeter) Greet() string {
+	return "Hello " + g.Name
+}
+
+var _ io.Closer = &Greeter{}
+`
+
+// greeterBlocks are the blocks of the bodies of String, Close and Greet
+var greeterBlocks = []cover.ProfileBlock{
+	{StartLine: 14, StartCol: 2, EndLine: 15, EndCol: 1, NumStmt: 1},
+	{StartLine: 18, StartCol: 2, EndLine: 19, EndCol: 1, NumStmt: 1},
+	{StartLine: 22, StartCol: 2, EndLine: 23, EndCol: 1, NumStmt: 1},
+}
+
+func TestImplInstructions(t *testing.T) {
+	tests := []struct {
+		impl     string
+		excluded []int
+		err      string
+	}{
+		{impl: "fmt.Stringer", excluded: []int{14}},
+		//the methods of the pointer receiver implement the interface too
+		{impl: "fmt.Stringer,io.Closer", excluded: []int{14, 18}},
+		{impl: "io.Reader", err: "does not implement io.Reader"},
+		{impl: "bufio.Reader", err: "package bufio is not imported"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.impl, func(t *testing.T) {
+			dir := writeTestFiles(t, map[string]string{
+				"go.mod":     "module example.com/impl\n\ngo 1.18\n",
+				"greeter.go": strings.Replace(greeterSource, "IMPL", tt.impl, 1),
+			})
+			instructions, err := readInstructionsFromSourceFile(filepath.Join(dir, "greeter.go"), ScanOptions{})
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := excludedLines(instructions, greeterBlocks); !equalInts(got, tt.excluded) {
+				t.Errorf("expected the blocks of the lines %v to be excluded, got %v", tt.excluded, got)
+			}
+		})
+	}
+}
+
+func TestImplInstructionsWithoutType(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"go.mod": "module example.com/impl\n\ngo 1.18\n",
+		"greet.go": `package impl
+
+@coverage:ignore impl=fmt.Stringer
+func Greet() string {
+	return "Hello"
+}
+`,
+	})
+	_, err := readInstructionsFromSourceFile(filepath.Join(dir, "greet.go"), ScanOptions{})
+	if err == nil || !strings.Contains(err.Error(), "before a type declaration") {
+		t.Fatalf("expected an error about the type declaration, got %v", err)
+	}
+}
+
+func equalInts(a []int, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,764 @@
+//coverage:ignore file
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"os"
+	"path"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/tools/cover"
+)
+
+// ScanOptions enables the instructions detected from the source code itself,
+// without a directive
+type ScanOptions struct {
+	// Syntax recognizes the directives, the default prefix only when nil
+	Syntax *DirectiveSyntax
+	// Groups leaves out the directives of the disabled groups, none when nil
+	Groups *Groups
+	// Types are the packages type checked for the impl option, shared by the
+	// files of a correction, checked again for each file when nil
+	Types *packageTypes
+	// Warnings collects the warnings about the files, printed right away when
+	// nil
+	Warnings *warningSet
+	// Sources records the files scanned, nil when not needed
+	Sources *sourceCache
+	// SkipDirectives reads only the instructions of the options, for the
+	// files of the profile outside of the scanned directories
+	SkipDirectives bool
+	// Resolver names the files for the path patterns of the options, by
+	// their path relative to its root and their import path
+	Resolver *moduleResolver
+	// Conditions are the conditions met, for the directives with an if option
+	Conditions map[string]bool
+	// ActiveFlags are the feature flags enabled, for the directives with a
+	// flag option
+	ActiveFlags map[string]bool
+	// GOOS and GOARCH are the platform of the profile, for the directives
+	// with a goos or goarch option, the running platform when empty
+	GOOS           string
+	GOARCH         string
+	SkipCgoExports bool
+	// KeepExamples keeps the testable examples declared in non-test files,
+	// they are ignored by default as they are documentation
+	KeepExamples bool
+	// IgnorePanicPaths ignores the blocks running straight into a panic,
+	// defensive code not worth a test
+	IgnorePanicPaths bool
+	// IgnoreFatal ignores the blocks running straight into log.Fatal or
+	// os.Exit, they end the test process
+	IgnoreFatal bool
+	// IgnoreErrReturns ignores the if err != nil { return err } branches
+	// propagating an error
+	IgnoreErrReturns bool
+	// IgnoreEntrypoints ignores the bodies of the main function of the main
+	// packages and of the init functions, run before the tests
+	IgnoreEntrypoints bool
+	// IgnoreRecoverHandlers ignores the bodies of the deferred function
+	// literals calling recover
+	IgnoreRecoverHandlers bool
+	// ExcludeFuncs ignores the bodies of the functions and methods whose name
+	// matches, the methods are matched as Type.Method too
+	ExcludeFuncs []*regexp.Regexp
+	// ExcludeReceivers ignores the bodies of the methods of the receiver
+	// types, mapped to the option naming them
+	ExcludeReceivers map[string]string
+	// ExcludeStdMethods ignores the methods implementing the interfaces of
+	// the standard library, like fmt.Stringer or json.Marshaler
+	ExcludeStdMethods bool
+	// ExcludeGenerated ignores the files with the header of the generated
+	// files, and Presets the ones recognized by one of the presets
+	ExcludeGenerated bool
+	Presets          []Preset
+	// ExcludeDataFiles ignores the files of the patterns only declaring data
+	ExcludeDataFiles PathPatterns
+	// IgnoreCmdPackages ignores the files of the main packages under a cmd
+	// directory
+	IgnoreCmdPackages bool
+	// IgnoreGoroutineBodies ignores the bodies of the function literals
+	// launched with go, in the files of GoroutinePaths, all when empty
+	IgnoreGoroutineBodies bool
+	GoroutinePaths        PathPatterns
+}
+
+// filePath returns the path of a file matched by the path patterns
+func (opts ScanOptions) filePath(path string) FilePath {
+	if opts.Resolver == nil {
+		return newFilePath(path, path)
+	}
+	file := newFilePath(relativePath(opts.Resolver.root, path), path)
+	file.Import = opts.Resolver.fileName(path)
+	return file
+}
+
+// sourceCache records the files scanned by a correction, so the files of the
+// profile the scans missed are found. With keep, it keeps their content too,
+// for the options applied to the blocks of the profile, which then see the
+// files as scanned, at the revision of --source-ref for example.
+type sourceCache struct {
+	keep  bool
+	files map[string]*cachedSource
+}
+
+// cachedSource is a file scanned, src is nil until it is parsed
+type cachedSource struct {
+	content []byte
+	src     *sourceFile
+}
+
+func newSourceCache(keep bool) *sourceCache {
+	return &sourceCache{keep: keep, files: map[string]*cachedSource{}}
+}
+
+func (c *sourceCache) add(path string, content []byte, src *sourceFile) {
+	if c == nil {
+		return
+	}
+	if !c.keep {
+		content, src = nil, nil
+	}
+	c.files[path] = &cachedSource{content: content, src: src}
+}
+
+// lookup returns a file scanned by its path or by its canonical path, nil
+// when it was not scanned
+func (c *sourceCache) lookup(path string) *cachedSource {
+	if cached, ok := c.files[path]; ok {
+		return cached
+	}
+	return c.files[canonicalPath(path)]
+}
+
+func (c *sourceCache) scanned(path string) bool {
+	return c.lookup(path) != nil
+}
+
+// load returns a file as scanned, read from the disk when the scans did not
+// keep it
+func (c *sourceCache) load(path string) (*cachedSource, error) {
+	cached := c.lookup(path)
+	if cached == nil || cached.content == nil {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		cached = &cachedSource{content: content}
+		c.files[path] = cached
+	}
+	return cached, nil
+}
+
+func (c *sourceCache) content(path string) ([]byte, error) {
+	cached, err := c.load(path)
+	if err != nil {
+		return nil, err
+	}
+	return cached.content, nil
+}
+
+// syntax returns the syntax tree of a file, parsed once
+func (c *sourceCache) syntax(path string) (*sourceFile, error) {
+	cached, err := c.load(path)
+	if err != nil {
+		return nil, err
+	}
+	if cached.src == nil {
+		src, err := parseSource(path, cached.content)
+		if err != nil {
+			return nil, err
+		}
+		cached.src = src
+	}
+	return cached.src, nil
+}
+
+func (opts ScanOptions) syntax() *DirectiveSyntax {
+	if opts.Syntax == nil {
+		return defaultDirectiveSyntax
+	}
+	return opts.Syntax
+}
+
+func (opts ScanOptions) types() *packageTypes {
+	if opts.Types == nil {
+		return newPackageTypes()
+	}
+	return opts.Types
+}
+
+// conditionMet reports whether the condition of the directive, if any, is
+// met by the conditions of the options
+func (opts ScanOptions) conditionMet(directive Directive) bool {
+	condition, ok := directive.Options[OptionIf]
+	if !ok {
+		return true
+	}
+	if negated := strings.TrimPrefix(condition, "!"); negated != condition {
+		return !opts.Conditions[negated]
+	}
+	return opts.Conditions[condition]
+}
+
+// flagsActive reports whether the directive has feature flags and they are
+// all active. The code behind the flags is only reached once they are all
+// active, it is measured from then on.
+func (opts ScanOptions) flagsActive(directive Directive) bool {
+	flags, ok := directive.Options[OptionFlag]
+	if !ok {
+		return false
+	}
+	for _, flag := range strings.Split(flags, ",") {
+		if !opts.ActiveFlags[strings.TrimSpace(flag)] {
+			return false
+		}
+	}
+	return true
+}
+
+// platformMet reports whether the goos and goarch options of the directive,
+// if any, match the platform of the options, the running platform by default
+func (opts ScanOptions) platformMet(directive Directive) bool {
+	goos, goarch := opts.GOOS, opts.GOARCH
+	if goos == "" {
+		goos = runtime.GOOS
+	}
+	if goarch == "" {
+		goarch = runtime.GOARCH
+	}
+	return qualifierMatches(directive.Options, OptionGOOS, goos) && qualifierMatches(directive.Options, OptionGOARCH, goarch)
+}
+
+// qualifierMatches reports whether the value is in the comma separated list
+// of the option, or not in it when the list starts with a !
+func qualifierMatches(options map[string]string, option string, value string) bool {
+	list, ok := options[option]
+	if !ok {
+		return true
+	}
+	if negated := strings.TrimPrefix(list, "!"); negated != list {
+		return find(strings.Split(negated, ","), value) < 0
+	}
+	return find(strings.Split(list, ","), value) >= 0
+}
+
+func (opts ScanOptions) needsSyntax() bool {
+	return opts.SkipCgoExports
+}
+
+// mayNeedSyntax reports whether the content of a file may contain
+// declarations ignored by the options, without parsing it
+func (opts ScanOptions) mayNeedSyntax(path string, content []byte) bool {
+	return opts.needsSyntax() || (len(opts.ExcludeDataFiles) > 0 && opts.ExcludeDataFiles.matches(opts.filePath(path))) || (!opts.KeepExamples && bytes.Contains(content, []byte("func Example"))) ||
+		(opts.IgnorePanicPaths && bytes.Contains(content, []byte("panic("))) ||
+		(opts.IgnoreFatal && (bytes.Contains(content, []byte(".Fatal")) || bytes.Contains(content, []byte(".Exit(")))) ||
+		(opts.IgnoreErrReturns && bytes.Contains(content, []byte("err != nil"))) ||
+		(opts.IgnoreRecoverHandlers && bytes.Contains(content, []byte("recover()"))) ||
+		(opts.IgnoreGoroutineBodies && bytes.Contains(content, []byte("go func"))) ||
+		(opts.IgnoreCmdPackages && bytes.Contains(content, []byte("package main"))) ||
+		(opts.IgnoreEntrypoints && (bytes.Contains(content, []byte("func main(")) || bytes.Contains(content, []byte("func init(")))) ||
+		len(opts.ExcludeFuncs) > 0 || len(opts.ExcludeReceivers) > 0 || opts.ExcludeStdMethods
+}
+
+// autoInstructions returns the instructions enabled by the options for a
+// file, src is nil when the file was not parsed
+func autoInstructions(path string, content []byte, src *sourceFile, opts ScanOptions) []Instruction {
+	instructions := []Instruction{}
+	if (opts.ExcludeGenerated || len(opts.Presets) > 0) && bytes.Contains(content, []byte("// Code generated ")) {
+		if generator, ok := generatedHeader(content); ok {
+			if opts.ExcludeGenerated {
+				instructions = append(instructions, IgnoreFile{Generator: generator, Origin: Origin{Description: "--exclude-generated"}})
+			} else if preset, ok := matchingPreset(opts.Presets, path, generator); ok {
+				instructions = append(instructions, IgnoreFile{Generator: generator, Origin: Origin{Description: "--preset " + preset.Name}})
+			}
+		}
+	}
+	if src == nil {
+		return instructions
+	}
+	if opts.IgnoreCmdPackages && src.File.Name.Name == "main" && isCmdPath(opts.filePath(src.Path).Rel) {
+		instructions = append(instructions, IgnoreFile{Origin: Origin{Description: "--ignore-cmd-packages"}})
+	}
+	if len(opts.ExcludeDataFiles) > 0 {
+		file := opts.filePath(src.Path)
+		if pattern, ok := opts.ExcludeDataFiles.matching(file); ok && src.declaresDataOnly() {
+			instructions = append(instructions, IgnoreFile{Origin: Origin{Description: "exclude_data_files " + pattern.Source}})
+		} else if ok && !strings.HasSuffix(file.Rel, "_test.go") {
+			//the test files are never measured, their functions do not matter
+			opts.Warnings.add(file.Rel, "exclude_data_files: %s matches files declaring functions, they are measured", pattern.Source)
+		}
+	}
+	for _, decl := range src.File.Decls {
+		funcDecl, ok := decl.(*ast.FuncDecl)
+		if !ok {
+			continue
+		}
+		if funcDecl.Body == nil {
+			continue
+		}
+		if opts.SkipCgoExports && isCgoExport(funcDecl) {
+			instructions = append(instructions, withOrigin(src.bodyRange(funcDecl.Body), Origin{Description: "--skip-cgo-exports"}))
+		}
+		if !opts.KeepExamples && isExample(funcDecl) {
+			instructions = append(instructions, withOrigin(src.bodyRange(funcDecl.Body), Origin{Description: "testable example"}))
+		}
+		if pattern, ok := matchingFuncPattern(opts.ExcludeFuncs, funcDecl); ok {
+			instructions = append(instructions, withOrigin(src.bodyRange(funcDecl.Body), Origin{Description: "--exclude-funcs " + pattern.String()}))
+		}
+		if opts.IgnoreEntrypoints && src.isEntrypoint(funcDecl) {
+			instructions = append(instructions, withOrigin(src.bodyRange(funcDecl.Body), Origin{Description: "--ignore-entrypoints"}))
+		}
+		if opts.ExcludeStdMethods && isStdMethod(funcDecl) {
+			instructions = append(instructions, withOrigin(src.bodyRange(funcDecl.Body), Origin{Description: "--exclude-stdmethods"}))
+		}
+		if receiver := receiverTypeName(funcDecl); receiver != "" {
+			if option, ok := opts.ExcludeReceivers[receiver]; ok {
+				instructions = append(instructions, withOrigin(src.bodyRange(funcDecl.Body), Origin{Description: option + " " + receiver}))
+			}
+		}
+	}
+	if opts.IgnorePanicPaths {
+		for _, block := range src.terminalPaths(isPanic) {
+			instructions = append(instructions, withOrigin(block, Origin{Description: "--ignore-panic-paths"}))
+		}
+	}
+	if opts.IgnoreFatal {
+		for _, block := range src.terminalPaths(src.isFatal) {
+			instructions = append(instructions, withOrigin(block, Origin{Description: "--ignore-fatal"}))
+		}
+	}
+	if opts.IgnoreErrReturns {
+		for _, block := range src.errReturns() {
+			instructions = append(instructions, withOrigin(block, Origin{Description: "--ignore-err-returns"}))
+		}
+	}
+	if opts.IgnoreRecoverHandlers {
+		for _, body := range src.recoverHandlers() {
+			instructions = append(instructions, withOrigin(body, Origin{Description: "--ignore-recover-handlers"}))
+		}
+	}
+	if opts.IgnoreGoroutineBodies && (len(opts.GoroutinePaths) == 0 || opts.GoroutinePaths.matches(opts.filePath(src.Path))) {
+		for _, body := range src.goroutineBodies() {
+			instructions = append(instructions, withOrigin(body, Origin{Description: "--ignore-goroutine-bodies"}))
+		}
+	}
+	return instructions
+}
+
+// isEntrypoint reports whether the function is an init function, or the
+// main function of a main package
+func (s *sourceFile) isEntrypoint(funcDecl *ast.FuncDecl) bool {
+	if funcDecl.Recv != nil {
+		return false
+	}
+	return funcDecl.Name.Name == "init" || (funcDecl.Name.Name == "main" && s.File.Name.Name == "main")
+}
+
+// recoverHandlers returns the bodies of the function literals deferred to
+// recover from a panic, like defer func() { if r := recover(); r != nil {
+// ... } }(). The recover must be called by the literal itself, not by a
+// literal nested in it, to stop the panic.
+func (s *sourceFile) recoverHandlers() []IgnoreRange {
+	handlers := []IgnoreRange{}
+	ast.Inspect(s.File, func(node ast.Node) bool {
+		deferStmt, ok := node.(*ast.DeferStmt)
+		if !ok {
+			return true
+		}
+		if lit, ok := deferStmt.Call.Fun.(*ast.FuncLit); ok && callsRecover(lit.Body) {
+			handlers = append(handlers, s.bodyRange(lit.Body))
+		}
+		return true
+	})
+	return handlers
+}
+
+func callsRecover(body *ast.BlockStmt) bool {
+	found := false
+	ast.Inspect(body, func(node ast.Node) bool {
+		switch n := node.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.CallExpr:
+			if ident, ok := n.Fun.(*ast.Ident); ok && ident.Name == "recover" && ident.Obj == nil {
+				found = true
+			}
+		}
+		return !found
+	})
+	return found
+}
+
+// terminalPaths returns the ranges of the blocks, case clauses and function
+// bodies whose statements run straight into a terminal statement, like a
+// panic: the last statement is terminal and none of the others branches, so
+// all of them only run on the way to it
+func (s *sourceFile) terminalPaths(terminal func(ast.Stmt) bool) []IgnoreRange {
+	paths := []IgnoreRange{}
+	ast.Inspect(s.File, func(node ast.Node) bool {
+		switch n := node.(type) {
+		case *ast.BlockStmt:
+			if runsInto(n.List, terminal) {
+				paths = append(paths, s.bodyRange(n))
+			}
+		case *ast.CaseClause:
+			if runsInto(n.Body, terminal) {
+				paths = append(paths, s.clauseRange(n.Colon, n.End()))
+			}
+		case *ast.CommClause:
+			if runsInto(n.Body, terminal) {
+				paths = append(paths, s.clauseRange(n.Colon, n.End()))
+			}
+		}
+		return true
+	})
+	return paths
+}
+
+func runsInto(stmts []ast.Stmt, terminal func(ast.Stmt) bool) bool {
+	if len(stmts) == 0 || !terminal(stmts[len(stmts)-1]) {
+		return false
+	}
+	for _, stmt := range stmts[:len(stmts)-1] {
+		switch stmt.(type) {
+		case *ast.IfStmt, *ast.ForStmt, *ast.RangeStmt, *ast.SwitchStmt, *ast.TypeSwitchStmt, *ast.SelectStmt,
+			*ast.BlockStmt, *ast.LabeledStmt, *ast.BranchStmt, *ast.ReturnStmt, *ast.GoStmt, *ast.DeferStmt:
+			return false
+		}
+	}
+	return true
+}
+
+// isPanic reports whether the statement calls the panic builtin, not a
+// function of the package shadowing it
+func isPanic(stmt ast.Stmt) bool {
+	expr, ok := stmt.(*ast.ExprStmt)
+	if !ok {
+		return false
+	}
+	call, ok := expr.X.(*ast.CallExpr)
+	if !ok {
+		return false
+	}
+	ident, ok := call.Fun.(*ast.Ident)
+	return ok && ident.Name == "panic" && ident.Obj == nil
+}
+
+// stdMethods are the signatures of the methods of the interfaces of the
+// standard library, implemented for the interface compliance only
+var stdMethods = map[string]string{
+	"String":        "func() string",
+	"GoString":      "func() string",
+	"Error":         "func() string",
+	"MarshalJSON":   "func() ([]byte, error)",
+	"UnmarshalJSON": "func([]byte) error",
+	"MarshalText":   "func() ([]byte, error)",
+	"UnmarshalText": "func([]byte) error",
+}
+
+// isStdMethod reports whether the function is a method with the name and the
+// signature of a method of stdMethods, the names of the parameters aside. A
+// function or a method with another signature is not matched.
+func isStdMethod(funcDecl *ast.FuncDecl) bool {
+	signature, ok := stdMethods[funcDecl.Name.Name]
+	if !ok || funcDecl.Recv == nil {
+		return false
+	}
+	funcType := &ast.FuncType{Params: withoutNames(funcDecl.Type.Params), Results: withoutNames(funcDecl.Type.Results)}
+	return types.ExprString(funcType) == signature
+}
+
+// withoutNames returns the fields without their names, a field per name
+func withoutNames(fields *ast.FieldList) *ast.FieldList {
+	if fields == nil {
+		return nil
+	}
+	unnamed := &ast.FieldList{}
+	for _, field := range fields.List {
+		for i := 0; i < len(field.Names) || (i == 0 && len(field.Names) == 0); i++ {
+			unnamed.List = append(unnamed.List, &ast.Field{Type: field.Type})
+		}
+	}
+	return unnamed
+}
+
+// matchingFuncPattern returns the first pattern matching the name of the
+// function, or the name of the method qualified with its receiver type, like
+// Conn.Close
+func matchingFuncPattern(patterns []*regexp.Regexp, funcDecl *ast.FuncDecl) (*regexp.Regexp, bool) {
+	name := funcDecl.Name.Name
+	qualified := name
+	if receiver := receiverTypeName(funcDecl); receiver != "" {
+		qualified = receiver + "." + name
+	}
+	for _, pattern := range patterns {
+		if pattern.MatchString(name) || pattern.MatchString(qualified) {
+			return pattern, true
+		}
+	}
+	return nil, false
+}
+
+// isCgoExport reports whether the function is exported to C, these functions
+// are only called from C code
+func isCgoExport(funcDecl *ast.FuncDecl) bool {
+	if funcDecl.Doc == nil {
+		return false
+	}
+	for _, comment := range funcDecl.Doc.List {
+		if strings.HasPrefix(comment.Text, "//export ") {
+			return true
+		}
+	}
+	return false
+}
+
+// isExample reports whether the function is a testable example, named like
+// the go test command expects: Example, ExampleF, ExampleT_M or Example_suffix,
+// without parameters nor results
+func isExample(funcDecl *ast.FuncDecl) bool {
+	if funcDecl.Recv != nil || len(funcDecl.Type.Params.List) > 0 || funcDecl.Type.Results != nil {
+		return false
+	}
+	suffix := strings.TrimPrefix(funcDecl.Name.Name, "Example")
+	if suffix == funcDecl.Name.Name {
+		return false
+	}
+	if suffix == "" {
+		return true
+	}
+	r, _ := utf8.DecodeRuneInString(suffix)
+	return r == '_' || !unicode.IsLower(r)
+}
+
+// generatedHeader finds the "// Code generated ... DO NOT EDIT." comment
+// marking the generated files, see go help generate. It must be a line
+// comment before the package clause. The generator is the first word after
+// "by", empty when the comment does not name it.
+func generatedHeader(content []byte) (string, bool) {
+	inBlockComment := false
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if inBlockComment {
+			_, after, closed := strings.Cut(line, "*/")
+			inBlockComment = !closed
+			if !closed || strings.TrimSpace(after) == "" {
+				continue
+			}
+			line = strings.TrimSpace(after)
+		}
+		switch {
+		case strings.TrimSpace(line) == "":
+			continue
+		case strings.HasPrefix(line, "// Code generated ") && strings.HasSuffix(line, " DO NOT EDIT."):
+			return generatorName(strings.TrimPrefix(line, "// Code generated ")), true
+		case strings.HasPrefix(line, "//"):
+			continue
+		case strings.HasPrefix(line, "/*"):
+			_, after, closed := strings.Cut(line[2:], "*/")
+			inBlockComment = !closed
+			if !closed || strings.TrimSpace(after) == "" {
+				continue
+			}
+			return "", false
+		default:
+			//the package clause, or anything else, ends the header
+			return "", false
+		}
+	}
+	return "", false
+}
+
+// generatorName returns the command following "by" in the text of a
+// generated code comment, like stringer for by "stringer -type=Color";
+func generatorName(text string) string {
+	by := strings.TrimPrefix(text, "by ")
+	if by == text {
+		return ""
+	}
+	fields := strings.Fields(strings.TrimLeft(by, "\"`"))
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.TrimRight(fields[0], "\"`.;,")
+}
+
+// errReturns returns the bodies of the if statements only propagating an
+// error, if err != nil { return err }, with an optional init statement and
+// the error possibly wrapped, like return nil, fmt.Errorf("...: %w", err)
+func (s *sourceFile) errReturns() []IgnoreRange {
+	bodies := []IgnoreRange{}
+	ast.Inspect(s.File, func(node ast.Node) bool {
+		ifStmt, ok := node.(*ast.IfStmt)
+		if !ok || ifStmt.Else != nil || len(ifStmt.Body.List) != 1 || !isErrNotNil(ifStmt.Cond) {
+			return true
+		}
+		if ret, ok := ifStmt.Body.List[0].(*ast.ReturnStmt); ok && returnsError(ret) {
+			bodies = append(bodies, s.bodyRange(ifStmt.Body))
+		}
+		return true
+	})
+	return bodies
+}
+
+func isErrNotNil(cond ast.Expr) bool {
+	binary, ok := cond.(*ast.BinaryExpr)
+	if !ok || binary.Op != token.NEQ {
+		return false
+	}
+	x, ok := binary.X.(*ast.Ident)
+	if !ok || x.Name != "err" {
+		return false
+	}
+	y, ok := binary.Y.(*ast.Ident)
+	return ok && y.Name == "nil"
+}
+
+// isFatal reports whether the statement calls log.Fatal, log.Fatalf,
+// log.Fatalln or os.Exit, through the name the file imports the package with
+func (s *sourceFile) isFatal(stmt ast.Stmt) bool {
+	expr, ok := stmt.(*ast.ExprStmt)
+	if !ok {
+		return false
+	}
+	call, ok := expr.X.(*ast.CallExpr)
+	if !ok {
+		return false
+	}
+	selector, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return false
+	}
+	pkg, ok := selector.X.(*ast.Ident)
+	if !ok || pkg.Obj != nil {
+		return false
+	}
+	switch s.importPath(pkg.Name) {
+	case "log":
+		return selector.Sel.Name == "Fatal" || selector.Sel.Name == "Fatalf" || selector.Sel.Name == "Fatalln"
+	case "os":
+		return selector.Sel.Name == "Exit"
+	}
+	return false
+}
+
+// importPath returns the path of the package imported with the name, empty
+// when there is none. An import without a name is named after the last
+// element of its path.
+func (s *sourceFile) importPath(name string) string {
+	for _, spec := range s.File.Imports {
+		imported, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+		if (spec.Name != nil && spec.Name.Name == name) || (spec.Name == nil && path.Base(imported) == name) {
+			return imported
+		}
+	}
+	return ""
+}
+
+// declaresDataOnly reports whether the file only declares data, without any
+// function declaration with a body. The function literals initializing the
+// variables, like the ones of a table of test cases, are part of the data.
+func (s *sourceFile) declaresDataOnly() bool {
+	for _, decl := range s.File.Decls {
+		if funcDecl, ok := decl.(*ast.FuncDecl); ok && funcDecl.Body != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// smallFuncs returns the instructions ignoring the bodies of the functions of
+// the file counting at most max statements in the blocks of the profile, like
+// the getters and the setters
+func smallFuncs(src *sourceFile, blocks []cover.ProfileBlock, max int) []Instruction {
+	instructions := []Instruction{}
+	for _, decl := range src.File.Decls {
+		funcDecl, ok := decl.(*ast.FuncDecl)
+		if !ok || funcDecl.Body == nil {
+			continue
+		}
+		body := src.bodyRange(funcDecl.Body)
+		statements, measured := 0, false
+		for _, block := range blocks {
+			if body.Matches(block) {
+				statements += block.NumStmt
+				measured = true
+			}
+		}
+		if measured && statements <= max {
+			instructions = append(instructions, withOrigin(body, Origin{Description: fmt.Sprintf("--ignore-small-funcs %d", max)}))
+		}
+	}
+	return instructions
+}
+
+// goroutineBodies returns the bodies of the function literals launched with
+// go, like the fire-and-forget goroutines of the initialization code. The
+// goroutines of named functions are measured.
+func (s *sourceFile) goroutineBodies() []IgnoreRange {
+	bodies := []IgnoreRange{}
+	ast.Inspect(s.File, func(node ast.Node) bool {
+		if goStmt, ok := node.(*ast.GoStmt); ok {
+			if lit, ok := goStmt.Call.Fun.(*ast.FuncLit); ok {
+				bodies = append(bodies, s.bodyRange(lit.Body))
+			}
+		}
+		return true
+	})
+	return bodies
+}
+
+// stmtRegexBlocks returns the instructions ignoring the blocks of the profile
+// whose source text matches one of the patterns, comments included
+func stmtRegexBlocks(content []byte, blocks []cover.ProfileBlock, patterns []*regexp.Regexp) []Instruction {
+	lines := strings.Split(string(content), "\n")
+	instructions := []Instruction{}
+	for _, block := range blocks {
+		text := blockSource(lines, block)
+		for _, pattern := range patterns {
+			if pattern.MatchString(text) {
+				start := IgnoreRange{StartLine: block.StartLine, StartCol: block.StartCol, EndLine: block.StartLine, EndCol: block.StartCol}
+				instructions = append(instructions, withOrigin(start, Origin{Description: "--ignore-stmt-regex " + pattern.String()}))
+				break
+			}
+		}
+	}
+	return instructions
+}
+
+// blockSource returns the source text of a block from the lines of its file,
+// the columns being byte offsets starting at 1. A block past the end of the
+// file, when the profile does not match the source, has no text.
+func blockSource(lines []string, block cover.ProfileBlock) string {
+	if block.StartLine < 1 || block.EndLine > len(lines) || block.StartLine > block.EndLine {
+		return ""
+	}
+	text := []string{}
+	for line := block.StartLine; line <= block.EndLine; line++ {
+		from, to := 0, len(lines[line-1])
+		if line == block.StartLine {
+			from = block.StartCol - 1
+		}
+		if line == block.EndLine && block.EndCol-1 < to {
+			to = block.EndCol - 1
+		}
+		if from < 0 || from > to {
+			return ""
+		}
+		text = append(text, strings.TrimRight(lines[line-1][from:to], "\r"))
+	}
+	return strings.Join(text, "\n")
+}
@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestGeneratedHeader(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		generator string
+		generated bool
+	}{
+		{name: "header", content: "// Code generated by protoc-gen-go. DO NOT EDIT.\n\npackage pb\n", generator: "protoc-gen-go", generated: true},
+		{name: "quoted command", content: "// Code generated by \"stringer -type=Color\"; DO NOT EDIT.\n\npackage color\n", generator: "stringer", generated: true},
+		{name: "no generator", content: "// Code generated DO NOT EDIT.\npackage gen\n", generated: true},
+		{name: "crlf", content: "// Code generated by mockgen. DO NOT EDIT.\r\n\r\npackage mocks\r\n", generator: "mockgen", generated: true},
+		{name: "after the license", content: "/*\nCopyright\n*/\n\n// Package gen is generated.\n// Code generated by gen. DO NOT EDIT.\npackage gen\n", generator: "gen", generated: true},
+		{name: "after the package clause", content: "package gen\n\n// Code generated by gen. DO NOT EDIT.\n"},
+		{name: "without the suffix", content: "// Code generated by gen, edit away.\npackage gen\n"},
+		{name: "block comment", content: "/* Code generated by gen. DO NOT EDIT. */\npackage gen\n"},
+		{name: "code after a block comment", content: "/* license */ package gen\n// Code generated by gen. DO NOT EDIT.\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			generator, generated := generatedHeader([]byte(tt.content))
+			if generated != tt.generated || generator != tt.generator {
+				t.Errorf("expected %v %q, got %v %q", tt.generated, tt.generator, generated, generator)
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+//coverage:ignore file
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const DefaultConfigFile = ".go-ignore-cov.yml"
+
+// Config is the optional configuration file, committed in the module root
+type Config struct {
+	Thresholds Thresholds  `yaml:"thresholds"`
+	Exempt     []Exemption `yaml:"exempt"`
+	// Groups are named lists of patterns, referenced as group:<name> in the
+	// other pattern lists and in the group thresholds
+	Groups map[string][]string `yaml:"groups"`
+	// ForbidFileIgnores are the patterns of the files where only blocks can
+	// be ignored, the file directive is rejected
+	ForbidFileIgnores []string `yaml:"forbid_file_ignores"`
+	// Exclude are the patterns of the files ignored as a whole, without a
+	// directive
+	Exclude []string `yaml:"exclude"`
+	// ExcludeDataFiles are the patterns of the files ignored as a whole when
+	// they only declare data, without any function body
+	ExcludeDataFiles []string `yaml:"exclude_data_files"`
+	// ExcludeReceivers are the names of the types whose methods are ignored,
+	// like the mocks and the fakes
+	ExcludeReceivers []string `yaml:"exclude_receivers"`
+	// Expect are the files expected to be excluded as a whole or not,
+	// checked on every run
+	Expect []Expectation `yaml:"expect"`
+	// SearchRoots are the directories outside of the root scanned for
+	// directives, where generators write the files of the profiles
+	SearchRoots []string `yaml:"search_roots"`
+	// MaxFunctionIgnore is the maximum percentage of the statements of a
+	// function excluded by directives, checked by lint. 0 disables the check.
+	MaxFunctionIgnore float64 `yaml:"max_function_ignore"`
+	// MeasureVendored are the patterns of the vendored files kept measured
+	// with --exclude-vendor
+	MeasureVendored []string `yaml:"measure_vendored"`
+	// DirectivePrefixes are recognized as directives like coverage:ignore
+	DirectivePrefixes []string `yaml:"directive_prefixes"`
+	// RequireReason rejects the directives without a reason, and
+	// ReasonPattern the directives whose reason does not match it, like an
+	// issue reference
+	RequireReason bool   `yaml:"require_reason"`
+	ReasonPattern string `yaml:"reason_pattern"`
+	// RequiredOptions are the options every directive must have, like a
+	// ticket, checked by lint. reason is met by the text after a dash too.
+	RequiredOptions []string `yaml:"required_options"`
+
+	forbidFileIgnores PathPatterns
+	exclude           PathPatterns
+	excludeDataFiles  PathPatterns
+	measureVendored   PathPatterns
+	reasonPattern     *regexp.Regexp
+	groups            map[string]PathPatterns
+}
+
+// Thresholds are the minimum coverage percentages, once corrected
+type Thresholds struct {
+	Total float64 `yaml:"total"`
+	// Package applies to every package without a specific threshold
+	Package float64 `yaml:"package"`
+	// Packages are the thresholds of specific packages, by import path
+	Packages map[string]float64 `yaml:"packages"`
+	// Groups are the thresholds of the files of the pattern groups
+	Groups map[string]float64 `yaml:"groups"`
+}
+
+// Exemption suppresses the threshold failures of a package until a date
+type Exemption struct {
+	Package string `yaml:"package"`
+	Until   string `yaml:"until"`
+	Reason  string `yaml:"reason"`
+}
+
+// loadConfig loads the configuration file. Without an explicit file, the
+// default file of the root is used when it exists.
+func loadConfig(file string, root string) (*Config, error) {
+	explicit := file != ""
+	if !explicit {
+		file = filepath.Join(root, DefaultConfigFile)
+	}
+	config := &Config{}
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		return config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("invalid configuration file %s: %w", file, err)
+	}
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration file %s: %w", file, err)
+	}
+	return config, nil
+}
+
+// checkPatterns warns about the patterns matching none of the files of the
+// coverage file, they are most likely wrong
+func (c *Config) checkPatterns(files []FilePath) {
+	for _, problem := range c.forbidFileIgnores.unmatched(files) {
+		warn("forbid_file_ignores: %s", problem)
+	}
+	for _, problem := range c.exclude.unmatched(files) {
+		warn("exclude: %s", problem)
+	}
+	for _, problem := range c.excludeDataFiles.unmatched(files) {
+		warn("exclude_data_files: %s", problem)
+	}
+	for _, problem := range c.measureVendored.unmatched(files) {
+		warn("measure_vendored: %s", problem)
+	}
+}
+
+// GroupReference is the prefix of a reference to a pattern group, like
+// group:generated
+const GroupReference = "group:"
+
+// expandGroups replaces the group references of a pattern list with the
+// patterns of the groups
+func (c *Config) expandGroups(patterns []string) ([]string, error) {
+	expanded := []string{}
+	for _, pattern := range patterns {
+		name := strings.TrimPrefix(pattern, GroupReference)
+		if name == pattern {
+			expanded = append(expanded, pattern)
+			continue
+		}
+		group, ok := c.Groups[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown group [%s]", name)
+		}
+		expanded = append(expanded, group...)
+	}
+	return expanded, nil
+}
+
+// compileGroupPatterns compiles a pattern list, expanding its group
+// references first
+func (c *Config) compileGroupPatterns(patterns []string) (PathPatterns, error) {
+	expanded, err := c.expandGroups(patterns)
+	if err != nil {
+		return nil, err
+	}
+	return compilePathPatterns(expanded)
+}
+
+func (c *Config) validate() error {
+	var err error
+	c.groups = map[string]PathPatterns{}
+	for name, patterns := range c.Groups {
+		for _, pattern := range patterns {
+			if strings.HasPrefix(pattern, GroupReference) {
+				return fmt.Errorf("groups: %s: groups cannot reference other groups", name)
+			}
+		}
+		if c.groups[name], err = compilePathPatterns(patterns); err != nil {
+			return fmt.Errorf("groups: %s: %w", name, err)
+		}
+	}
+	for name := range c.Thresholds.Groups {
+		if _, ok := c.Groups[name]; !ok {
+			return fmt.Errorf("thresholds: unknown group [%s]", name)
+		}
+	}
+	if c.forbidFileIgnores, err = c.compileGroupPatterns(c.ForbidFileIgnores); err != nil {
+		return fmt.Errorf("forbid_file_ignores: %w", err)
+	}
+	if c.exclude, err = c.compileGroupPatterns(c.Exclude); err != nil {
+		return fmt.Errorf("exclude: %w", err)
+	}
+	if c.excludeDataFiles, err = c.compileGroupPatterns(c.ExcludeDataFiles); err != nil {
+		return fmt.Errorf("exclude_data_files: %w", err)
+	}
+	if c.measureVendored, err = c.compileGroupPatterns(c.MeasureVendored); err != nil {
+		return fmt.Errorf("measure_vendored: %w", err)
+	}
+	for i := range c.Expect {
+		if c.Expect[i].File == "" {
+			return fmt.Errorf("expect: expectation without a file")
+		}
+		if c.Expect[i].pattern, err = compilePathPattern(c.Expect[i].File); err != nil {
+			return fmt.Errorf("expect: %w", err)
+		}
+	}
+	if c.ReasonPattern != "" {
+		if c.reasonPattern, err = regexp.Compile(c.ReasonPattern); err != nil {
+			return fmt.Errorf("reason_pattern: %w", err)
+		}
+	}
+	if _, err := newDirectiveSyntax(c.DirectivePrefixes); err != nil {
+		return fmt.Errorf("directive_prefixes: %w", err)
+	}
+	for _, exemption := range c.Exempt {
+		if exemption.Package == "" {
+			return fmt.Errorf("exemption without a package")
+		}
+		if _, err := exemption.expiry(); err != nil {
+			return fmt.Errorf("exemption of package %s: %w", exemption.Package, err)
+		}
+	}
+	return nil
+}
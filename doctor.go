@@ -0,0 +1,219 @@
+//coverage:ignore file
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"golang.org/x/tools/cover"
+)
+
+func doctorCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "doctor",
+		Usage: "check the environment and the setup, and print how to fix the problems found",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "file",
+				Aliases: []string{"f"},
+				Usage:   "coverage file to check, optional",
+			},
+			&cli.StringFlag{
+				Name:    "root",
+				Aliases: []string{"r"},
+				Usage:   "module root",
+			},
+			&cli.StringFlag{
+				Name:  "config",
+				Usage: "configuration file, " + DefaultConfigFile + " in the root by default",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			d := &doctor{w: os.Stdout}
+			root := c.String("root")
+			if root == "" {
+				root, _ = os.Getwd()
+			}
+			root, err := filepath.Abs(root)
+			if err != nil {
+				return err
+			}
+			root = canonicalPath(root)
+			d.checkToolchain()
+			d.checkModuleRoot(root)
+			syntax := defaultDirectiveSyntax
+			if config := d.checkConfig(c.String("config"), root); config != nil {
+				syntax, _ = newDirectiveSyntax(config.DirectivePrefixes)
+			}
+			d.checkDirectives(root, syntax)
+			if file := c.String("file"); file != "" {
+				d.checkProfile(file, root)
+			} else {
+				d.skip("coverage file", "no coverage file given, use --file to check that its paths resolve")
+			}
+			if d.failures > 0 {
+				return fmt.Errorf("%d problem(s) found", d.failures)
+			}
+			fmt.Fprintln(d.w, "No problem found")
+			return nil
+		},
+	}
+}
+
+// doctor prints the result of each check, with a fix for the failed ones
+type doctor struct {
+	w        io.Writer
+	failures int
+}
+
+func (d *doctor) ok(check string, format string, a ...interface{}) {
+	fmt.Fprintf(d.w, "[ok]   %s: %s\n", check, fmt.Sprintf(format, a...))
+}
+
+func (d *doctor) skip(check string, format string, a ...interface{}) {
+	fmt.Fprintf(d.w, "[skip] %s: %s\n", check, fmt.Sprintf(format, a...))
+}
+
+func (d *doctor) fail(check string, problem string, fix string) {
+	d.failures++
+	fmt.Fprintf(d.w, "[fail] %s: %s\n       fix: %s\n", check, problem, fix)
+}
+
+func (d *doctor) checkToolchain() {
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		d.fail("go toolchain", "the go command is not in the PATH",
+			"install Go from https://go.dev/dl/ and add its bin directory to the PATH, profile paths are resolved with it")
+		return
+	}
+	out, err := exec.Command(goBin, "version").Output()
+	if err != nil {
+		d.fail("go toolchain", fmt.Sprintf("%s version failed: %v", goBin, err), "check the Go installation, GOROOT may point to a removed version")
+		return
+	}
+	d.ok("go toolchain", "%s", strings.TrimSpace(string(out)))
+}
+
+func (d *doctor) checkModuleRoot(root string) {
+	if _, err := os.Stat(filepath.Join(root, "go.mod")); err == nil {
+		d.ok("module root", "%s", root)
+		return
+	}
+	for dir := filepath.Dir(root); dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			d.fail("module root", fmt.Sprintf("%s is not a module root, the module is in %s", root, dir),
+				fmt.Sprintf("run from %s or use --root %s, the files outside of the root are not scanned", dir, dir))
+			return
+		}
+	}
+	d.fail("module root", fmt.Sprintf("no go.mod found in %s or its parents", root),
+		"run from the directory containing go.mod, or use --root to point to it")
+}
+
+// checkConfig returns the configuration, nil when it is not valid
+func (d *doctor) checkConfig(file string, root string) *Config {
+	config, err := loadConfig(file, root)
+	if err != nil {
+		d.fail("configuration", err.Error(), "fix the file, see the Configuration section of the README")
+		return nil
+	}
+	if file == "" {
+		file = filepath.Join(root, DefaultConfigFile)
+		if _, err := os.Stat(file); err != nil {
+			d.skip("configuration", "no %s in the root", DefaultConfigFile)
+			return config
+		}
+	}
+	d.ok("configuration", "%s is valid", file)
+	return config
+}
+
+// checkDirectives looks for the comments that look like directives but are
+// not recognized, and for the directives that cannot be applied
+func (d *doctor) checkDirectives(root string, syntax *DirectiveSyntax) {
+	directives, problems := 0, 0
+	checked := newPackageTypes()
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasSuffix(info.Name(), ".go") {
+			return err
+		}
+		content, err := os.ReadFile(path)
+		if err != nil || !syntax.mayContain(content) {
+			return err
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(content))
+		for lineNumber := 1; scanner.Scan(); lineNumber++ {
+			comment := strings.TrimSpace(scanner.Text())
+			if !strings.HasPrefix(comment, "//") {
+				continue
+			}
+			text := strings.TrimSpace(strings.TrimPrefix(comment, "//"))
+			_, valid := syntax.parse(comment)
+			if !valid && !strings.HasPrefix(text, "coverage:") && !strings.HasPrefix(text, "coverage :") {
+				continue
+			}
+			directives++
+			if !valid {
+				problems++
+				d.fail("directives", fmt.Sprintf("%s:%d: [%s] is not a valid directive", relativePath(root, path), lineNumber, comment),
+					"write it //coverage:ignore, followed by an optional instruction and options separated by single spaces, and an optional reason after a dash")
+			}
+		}
+		if _, err := readInstructionsFromSource(path, content, ScanOptions{Syntax: syntax, Types: checked, KeepExamples: true}); err != nil {
+			problems++
+			d.fail("directives", err.Error(), "fix the directive, see the README for the instructions and options")
+		}
+		return nil
+	})
+	if err != nil {
+		d.fail("directives", err.Error(), "check the permissions of the files under the root")
+		return
+	}
+	if problems == 0 {
+		d.ok("directives", "%d directive(s) found, all valid", directives)
+	}
+}
+
+func (d *doctor) checkProfile(file string, root string) {
+	profiles, err := cover.ParseProfiles(file)
+	if err != nil {
+		d.fail("coverage file", err.Error(), "generate it with go test -coverprofile, and make sure it is not truncated")
+		return
+	}
+	files := []string{}
+	unresolved := []string{}
+	resolver := newModuleResolver(root)
+	for _, profile := range profiles {
+		path, err := resolver.resolve(profile.FileName)
+		if err != nil {
+			unresolved = append(unresolved, profile.FileName)
+			continue
+		}
+		files = append(files, path)
+	}
+	if len(unresolved) > 0 {
+		d.fail("coverage file", fmt.Sprintf("%d of the %d files cannot be found, like %s", len(unresolved), len(profiles), unresolved[0]),
+			"run from the module that produced the coverage file, after go mod download, with the same GOPATH and GOFLAGS")
+	}
+	underRoot := 0
+	for _, path := range files {
+		if rel := relativePath(root, path); !filepath.IsAbs(filepath.FromSlash(rel)) {
+			underRoot++
+		}
+	}
+	if len(files) > 0 && float64(underRoot) < MinFilesUnderRoot*float64(len(files)) {
+		d.fail("coverage file", fmt.Sprintf("only %d of the %d files are under the root %s", underRoot, len(files), root),
+			"use --root to point to the module that produced the coverage file")
+		return
+	}
+	if len(unresolved) == 0 {
+		d.ok("coverage file", "the %d files resolve, %d under the root", len(files), underRoot)
+	}
+}
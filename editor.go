@@ -0,0 +1,164 @@
+//coverage:ignore file
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"golang.org/x/tools/cover"
+)
+
+const (
+	// EditorFormatLcov is the lcov tracefile read by the coverage extensions
+	// of the editors, like Coverage Gutters
+	EditorFormatLcov = "lcov"
+	// EditorFormatVSCode follows the shapes of the VS Code test coverage API
+	EditorFormatVSCode = "vscode"
+)
+
+func editorFormats() []string {
+	return []string{EditorFormatLcov, EditorFormatVSCode}
+}
+
+// measuredBlocks returns the blocks of a file still measured once the
+// exclusions are applied, soft exclusions included, so the editors do not
+// show the excluded code as uncovered
+func measuredBlocks(blocks []cover.ProfileBlock, exclusions []Exclusion) []cover.ProfileBlock {
+	excluded := map[[4]int]bool{}
+	for _, e := range exclusions {
+		excluded[[4]int{e.StartLine, e.StartCol, e.EndLine, e.EndCol}] = true
+	}
+	measured := []cover.ProfileBlock{}
+	for _, block := range blocks {
+		if !excluded[[4]int{block.StartLine, block.StartCol, block.EndLine, block.EndCol}] {
+			measured = append(measured, block)
+		}
+	}
+	return measured
+}
+
+// writeEditorCoverage writes the corrected coverage in a format of the
+// editors. The excluded blocks are left out, the editors show them as not
+// counted rather than uncovered.
+func writeEditorCoverage(w io.Writer, format string, correction *Correction) error {
+	switch format {
+	case EditorFormatLcov:
+		return writeLcov(w, correction)
+	case EditorFormatVSCode:
+		return writeVSCodeCoverage(w, correction)
+	}
+	return fmt.Errorf("unknown editor format [%s], expected one of %v", format, editorFormats())
+}
+
+func writeLcov(w io.Writer, correction *Correction) error {
+	for i, file := range correction.Report.Files {
+		counts := map[int]int{}
+		for _, block := range measuredBlocks(correction.Blocks[i], file.Exclusions) {
+			for line := block.StartLine; line <= block.EndLine; line++ {
+				if count, ok := counts[line]; !ok || block.Count > count {
+					counts[line] = block.Count
+				}
+			}
+		}
+		lines := make([]int, 0, len(counts))
+		for line := range counts {
+			lines = append(lines, line)
+		}
+		sort.Ints(lines)
+		hit := 0
+		if _, err := fmt.Fprintf(w, "TN:\nSF:%s\n", correction.Files[i]); err != nil {
+			return err
+		}
+		for _, line := range lines {
+			if counts[line] > 0 {
+				hit++
+			}
+			fmt.Fprintf(w, "DA:%d,%d\n", line, counts[line])
+		}
+		if _, err := fmt.Fprintf(w, "LF:%d\nLH:%d\nend_of_record\n", len(lines), hit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// VSCodeCoverage mirrors the FileCoverage and StatementCoverage of the VS
+// Code test coverage API, with zero based positions, so an extension maps it
+// directly
+type VSCodeCoverage struct {
+	Files []VSCodeFileCoverage `json:"files"`
+}
+
+type VSCodeFileCoverage struct {
+	Path              string             `json:"path"`
+	StatementCoverage VSCodeCoveredCount `json:"statementCoverage"`
+	Details           []VSCodeStatement  `json:"details"`
+}
+
+type VSCodeCoveredCount struct {
+	Covered int `json:"covered"`
+	Total   int `json:"total"`
+}
+
+type VSCodeStatement struct {
+	Type     string      `json:"type"`
+	Executed int         `json:"executed"`
+	Location VSCodeRange `json:"location"`
+}
+
+type VSCodeRange struct {
+	Start VSCodePosition `json:"start"`
+	End   VSCodePosition `json:"end"`
+}
+
+type VSCodePosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+func writeVSCodeCoverage(w io.Writer, correction *Correction) error {
+	coverage := VSCodeCoverage{Files: []VSCodeFileCoverage{}}
+	for i, file := range correction.Report.Files {
+		fileCoverage := VSCodeFileCoverage{
+			Path: correction.Files[i],
+			StatementCoverage: VSCodeCoveredCount{
+				Covered: file.Covered,
+				Total:   file.Statements - file.Excluded,
+			},
+			Details: []VSCodeStatement{},
+		}
+		for _, block := range measuredBlocks(correction.Blocks[i], file.Exclusions) {
+			fileCoverage.Details = append(fileCoverage.Details, VSCodeStatement{
+				Type:     "statement",
+				Executed: block.Count,
+				Location: VSCodeRange{
+					Start: VSCodePosition{Line: block.StartLine - 1, Character: block.StartCol - 1},
+					End:   VSCodePosition{Line: block.EndLine - 1, Character: block.EndCol - 1},
+				},
+			})
+		}
+		coverage.Files = append(coverage.Files, fileCoverage)
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(coverage)
+}
+
+// writeEditorCoverageFile writes the editor coverage to a file
+func writeEditorCoverageFile(path string, format string, correction *Correction) error {
+	if find(editorFormats(), format) < 0 {
+		return fmt.Errorf("unknown editor format [%s], expected one of %v", format, editorFormats())
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeEditorCoverage(f, format, correction); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,27 @@
+//coverage:ignore file
+package example
+
+import "time"
+
+// Backoff returns the delay before a retry, unlike the rest of the file it
+// is measured.
+//
+//coverage:ignore off
+func Backoff(attempt int) time.Duration {
+	if attempt <= 0 {
+		return 0
+	}
+	if attempt > 7 {
+		return 10 * time.Second
+	}
+	return 100 * time.Millisecond << uint(attempt-1)
+}
+
+func Retry(attempts int, f func() error) error {
+	err := f()
+	for i := 1; i < attempts && err != nil; i++ {
+		time.Sleep(Backoff(i))
+		err = f()
+	}
+	return err
+}
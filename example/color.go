@@ -0,0 +1,9 @@
+package example
+
+//go:generate stringer -type=Color
+type Color int
+
+const (
+	Red Color = iota
+	Green
+)
@@ -0,0 +1,24 @@
+//coverage:ignore funcs=Close,Conn.String
+package example
+
+import "fmt"
+
+// Conn is a fake connection, its boilerplate methods are listed once at the
+// top of the file rather than annotated one by one
+type Conn struct {
+	Addr   string
+	closed bool
+}
+
+func (c *Conn) Send(message string) string {
+	return c.Addr + " <- " + message
+}
+
+func (c *Conn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func (c *Conn) String() string {
+	return fmt.Sprintf("Conn(%s)", c.Addr)
+}
@@ -0,0 +1,14 @@
+package example
+
+import "fmt"
+
+//coverage:ignore func group=debug — only called while debugging
+func DumpGreeters(greeters []Greeter) {
+	if len(greeters) == 0 {
+		fmt.Println("no greeter")
+		return
+	}
+	for i, greeter := range greeters {
+		fmt.Println(i, greeter.Greet())
+	}
+}
@@ -0,0 +1,15 @@
+package example
+
+import (
+	"net"
+	"time"
+)
+
+//coverage:ignore func if=!integration — only reached by the integration tests
+func Dial(address string) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", address, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
@@ -0,0 +1,9 @@
+package example
+
+import "fmt"
+
+// the examples of non-test files are documentation, they are ignored without
+// a directive
+func ExampleGreeter() {
+	fmt.Println(Greeter{Name: "World"}.Greet())
+}
@@ -0,0 +1,15 @@
+package example
+
+import "fmt"
+
+// PrintBanner prints the banner of the example.
+//
+// It is only used for manual testing.
+//
+//coverage:ignore
+func PrintBanner(verbose bool) {
+	fmt.Println("=== example ===")
+	if verbose {
+		fmt.Println("manual testing only")
+	}
+}
@@ -1,6 +1,7 @@
 package example_test
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/quantumcycle/go-ignore-cov/example"
@@ -9,3 +10,62 @@ import (
 func TestSayHello(t *testing.T) {
 	example.MaybeSayHello()
 }
+
+func TestGreet(t *testing.T) {
+	example.Greeter{Name: "World"}.Greet()
+}
+
+func TestSign(t *testing.T) {
+	example.Sign(0)
+	example.Sign(1)
+}
+
+func TestLoadGreeting(t *testing.T) {
+	example.LoadGreeting(strings.NewReader(""))
+}
+
+func TestCountdown(t *testing.T) {
+	example.Countdown(0)
+}
+
+func TestSend(t *testing.T) {
+	(&example.Conn{Addr: "localhost"}).Send("hello")
+}
+
+func TestRegisterFlags(t *testing.T) {
+	example.RegisterFlags(nil)
+}
+
+func TestAnnounce(t *testing.T) {
+	example.Announce("hello")
+}
+
+func TestBackoff(t *testing.T) {
+	example.Backoff(0)
+	example.Backoff(1)
+	example.Backoff(8)
+}
+
+func TestRetryPolicy(t *testing.T) {
+	example.RetryPolicy(1, true)
+}
+
+func TestHomeDir(t *testing.T) {
+	example.HomeDir()
+}
+
+func TestNormalize(t *testing.T) {
+	example.Normalize("Done")
+}
+
+func TestParsePort(t *testing.T) {
+	example.ParsePort("80")
+}
+
+func TestCelsius(t *testing.T) {
+	example.Celsius(212)
+}
+
+func TestCollect(t *testing.T) {
+	example.Collect([]string{"a"})
+}
@@ -0,0 +1,15 @@
+package example
+
+import "flag"
+
+// RegisterFlags defines the flags of a command, a table rather than logic
+func RegisterFlags(fs *flag.FlagSet) {
+	if fs == nil {
+		return
+	}
+	//coverage:ignore next 4
+	fs.String("name", "World", "name to greet")
+	fs.Int("count", 1, "number of greetings")
+	fs.Bool("loud", false, "greet loudly")
+	fs.Duration("delay", 0, "delay between greetings")
+}
@@ -0,0 +1,16 @@
+package example
+
+import "fmt"
+
+//coverage:ignore impl=fmt.Stringer
+type Greeter struct {
+	Name string
+}
+
+func (g Greeter) String() string {
+	return fmt.Sprintf("Greeter(%s)", g.Name)
+}
+
+func (g Greeter) Greet() string {
+	return "Hello " + g.Name
+}
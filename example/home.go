@@ -0,0 +1,15 @@
+package example
+
+import (
+	"os"
+	"runtime"
+)
+
+// HomeDir returns the home directory of the user
+func HomeDir() string {
+	if runtime.GOOS == "windows" {
+		//coverage:ignore goos=!windows — only reachable on windows
+		return os.Getenv("USERPROFILE")
+	}
+	return os.Getenv("HOME")
+}
@@ -0,0 +1,12 @@
+package example
+
+import "fmt"
+
+// Announce prints a message, unless it is empty
+func Announce(message string) bool {
+	if message == "" {
+		return false //coverage:ignore
+	}
+	fmt.Println(message)
+	return true
+}
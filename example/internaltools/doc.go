@@ -0,0 +1,5 @@
+// Package internaltools holds the tools used while developing the example,
+// they are not shipped.
+//
+//coverage:ignore package
+package internaltools
@@ -0,0 +1,9 @@
+package internaltools
+
+import "fmt"
+
+func Dump(values ...interface{}) {
+	for i, value := range values {
+		fmt.Printf("%d: %#v\n", i, value)
+	}
+}
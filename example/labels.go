@@ -0,0 +1,19 @@
+package example
+
+// Countdown counts the steps to bring n down to zero. The directive above
+// the label ignores the block of the labeled statements, wherever the Go
+// version starts it.
+func Countdown(n int) int {
+	steps := 0
+	goto check
+	// coverage:ignore
+decrement:
+	n--
+	steps++
+check:
+	if n > 0 {
+		// coverage:ignore
+		goto decrement
+	}
+	return steps
+}
@@ -0,0 +1,17 @@
+package example
+
+import "strings"
+
+// Normalize lower cases a label, and maps the legacy labels to their new name
+func Normalize(label string) string {
+	label = strings.ToLower(label)
+	//coverage:ignore stmt — legacy labels, removed with the v1 API
+	if label == "todo" {
+		return "open"
+	} else if label == "wip" {
+		return "in-progress"
+	} else if strings.HasPrefix(label, "old-") {
+		return strings.TrimPrefix(label, "old-")
+	}
+	return label
+}
@@ -0,0 +1,14 @@
+package example
+
+// the block of a case body does not start at the same place in every Go
+// version, the directive is matched by the position of the case clause
+func Sign(n int) int {
+	switch {
+	// coverage:ignore
+	case n < 0:
+		return -1
+	case n == 0:
+		return 0
+	}
+	return 1
+}
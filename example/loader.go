@@ -0,0 +1,23 @@
+package example
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// LoadGreeting reads a greeting. Only its main logic is measured, the error
+// propagation is ignored.
+//
+//coverage:ignore scope=error-returns
+func LoadGreeting(r io.Reader) (string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", fmt.Errorf("reading the greeting: %w", err)
+	}
+	greeting := strings.TrimSpace(string(data))
+	if greeting == "" {
+		greeting = "Hello"
+	}
+	return greeting, nil
+}
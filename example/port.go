@@ -0,0 +1,26 @@
+package example
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// ParsePort parses a port, the privileged ports are moved above 8000
+func ParsePort(s string) (int, error) {
+	port, err := strconv.Atoi(s)
+	//coverage:ignore branch — the value is validated by the flag parser
+	if err != nil {
+		if s == "" {
+			return 0, fmt.Errorf("empty port")
+		}
+		return 0, fmt.Errorf("invalid port %q: %w", s, err)
+	}
+	if port < 1024 {
+		return port + 8000, nil
+	} else { //coverage:ignore branch — kept for the old configurations
+		if port > 65535 {
+			return 0, fmt.Errorf("port %d out of range", port)
+		}
+		return port, nil
+	}
+}
@@ -0,0 +1,10 @@
+package example
+
+// RetryPolicy tells whether a failed call is retried
+func RetryPolicy(attempt int, temporary bool) bool {
+	if !temporary {
+		/* coverage:ignore */
+		return false
+	}
+	return attempt < 3
+}
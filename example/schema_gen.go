@@ -0,0 +1,16 @@
+/*
+ * Code generated by schemagen from schema.json, edit the schema instead.
+ *
+ * coverage:ignore file generated-by=schemagen
+ */
+
+package example
+
+type Schema struct {
+	Name   string
+	Fields []string
+}
+
+func (s Schema) Validate() bool {
+	return s.Name != "" && len(s.Fields) > 0
+}
@@ -0,0 +1,16 @@
+package example
+
+// MemStore is an in-memory store for the demos, it is not worth testing
+//
+//coverage:ignore type — in-memory stub for the demos
+type MemStore struct {
+	items map[string]string
+}
+
+func (s *MemStore) Get(key string) (string, bool) {
+	if s.items == nil {
+		return "", false
+	}
+	value, ok := s.items[key]
+	return value, ok
+}
@@ -0,0 +1,8 @@
+package example
+
+func (s *MemStore) Put(key string, value string) {
+	if s.items == nil {
+		s.items = map[string]string{}
+	}
+	s.items[key] = value
+}
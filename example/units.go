@@ -0,0 +1,14 @@
+//coverage:ignore file except=Celsius — only the conversion used by the probes is tested
+package example
+
+// Celsius converts a temperature in Fahrenheit, it stays measured.
+func Celsius(fahrenheit float64) float64 {
+	return (fahrenheit - 32) * 5 / 9
+}
+
+func Kelvin(celsius float64) float64 {
+	if celsius < -273.15 {
+		return 0
+	}
+	return celsius + 273.15
+}
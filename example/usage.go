@@ -0,0 +1,17 @@
+package example
+
+import "fmt"
+
+// Usage prints the help of a command, only read by humans
+func Usage(command string) {
+	//coverage:ignore begin
+	switch command {
+	case "greet":
+		fmt.Println("greet NAME: greets NAME")
+	case "count":
+		fmt.Println("count N: counts down from N")
+	default:
+		fmt.Println("commands: greet, count")
+	}
+	//coverage:ignore end
+}
@@ -0,0 +1,92 @@
+//coverage:ignore file
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// parseExcludeLines parses the path:start-end line ranges of --exclude-lines
+// into instructions. Relative paths are relative to the root.
+func parseExcludeLines(root string, specs []string) ([]IgnoreCoverage, error) {
+	ignores := []IgnoreCoverage{}
+	for _, spec := range specs {
+		path, lines, ok := cutLast(spec, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid line range [%s], expected path/to/file.go:start-end", spec)
+		}
+		start, end, err := parseLineRange(lines)
+		if err != nil {
+			return nil, fmt.Errorf("invalid line range [%s]: %w", spec, err)
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(root, path)
+		}
+		if _, err := os.Stat(path); err != nil {
+			return nil, fmt.Errorf("invalid line range [%s]: %w", spec, err)
+		}
+		ignores = append(ignores, IgnoreCoverage{
+			Filepath: path,
+			Instructions: []Instruction{IgnoreRange{
+				StartLine: start,
+				StartCol:  0,
+				EndLine:   end,
+				EndCol:    MaxCol,
+				Origin:    Origin{Description: "--exclude-lines " + spec},
+			}},
+		})
+	}
+	return ignores, nil
+}
+
+// MaxCol is the largest column of a position, used to include whole lines
+const MaxCol = 99999
+
+func cutLast(s string, sep string) (string, string, bool) {
+	i := strings.LastIndex(s, sep)
+	if i < 0 {
+		return s, "", false
+	}
+	return s[:i], s[i+len(sep):], true
+}
+
+func parseLineRange(lines string) (int, int, error) {
+	startText, endText, isRange := strings.Cut(lines, "-")
+	if !isRange {
+		endText = startText
+	}
+	start, err := strconv.Atoi(startText)
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := strconv.Atoi(endText)
+	if err != nil {
+		return 0, 0, err
+	}
+	if start < 1 || end < start {
+		return 0, 0, fmt.Errorf("lines %d to %d is not a valid range", start, end)
+	}
+	return start, end, nil
+}
+
+// mergeIgnoreCoverages adds the instructions of extra to the instructions of
+// the same files in ignores
+func mergeIgnoreCoverages(ignores []IgnoreCoverage, extra []IgnoreCoverage) []IgnoreCoverage {
+	for _, e := range extra {
+		merged := false
+		for i := range ignores {
+			if canonicalPath(ignores[i].Filepath) == canonicalPath(e.Filepath) {
+				ignores[i].Instructions = append(ignores[i].Instructions, e.Instructions...)
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			ignores = append(ignores, e)
+		}
+	}
+	return ignores
+}
@@ -0,0 +1,49 @@
+//coverage:ignore file
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Expectation declares whether the files of a pattern are excluded as a
+// whole, so a change of the patterns or of the directives that starts or
+// stops excluding them fails instead of shifting the percentages
+type Expectation struct {
+	File     string `yaml:"file"`
+	Excluded bool   `yaml:"excluded"`
+
+	pattern PathPattern
+}
+
+// checkExpectations checks the expectations of the configuration against the
+// files of the report. A file is excluded as a whole when all its statements
+// are excluded. An expectation matching no file fails too, unless the report
+// covers a shard.
+func checkExpectations(report *Report, config *Config) error {
+	failures := []string{}
+	for _, expectation := range config.Expect {
+		matched := 0
+		for _, file := range report.Files {
+			if !expectation.pattern.matchesFile(FilePath{Rel: file.Path, Import: file.FileName}) {
+				continue
+			}
+			matched++
+			excluded := file.Statements > 0 && file.Excluded == file.Statements
+			switch {
+			case expectation.Excluded && !excluded:
+				failures = append(failures, fmt.Sprintf("%s is expected to be excluded, %d of its %d statements are measured",
+					file.Path, file.Statements-file.Excluded, file.Statements))
+			case !expectation.Excluded && excluded:
+				failures = append(failures, fmt.Sprintf("%s is expected to be measured, all its statements are excluded", file.Path))
+			}
+		}
+		if matched == 0 && report.Shard == "" {
+			failures = append(failures, fmt.Sprintf("%s matches no file of the coverage file", expectation.File))
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("exclusion expectations not met:\n  %s", strings.Join(failures, "\n  "))
+	}
+	return nil
+}
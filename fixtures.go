@@ -0,0 +1,622 @@
+//coverage:ignore file
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/quantumcycle/go-ignore-cov/profile"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/tools/cover"
+)
+
+// fixtureCase is a file of the fixtures module exercising an edge case of the
+// directives or of an option. Excluded lists the lines where the blocks
+// expected to be excluded start, a line for each layout of the blocks of the
+// supported Go versions. The directives are written @coverage:ignore, like
+// the self test.
+type fixtureCase struct {
+	Name     string
+	Source   string
+	CRLF     bool
+	Excluded []int
+	// Flags are the options of the correction of the case, corrected on its
+	// own when there are some, and Files the other files of the module it
+	// needs, like a configuration file
+	Flags []string
+	Files map[string]string
+}
+
+var fixtureCases = []fixtureCase{
+	{Name: "oneline", Source: `package fixtures
+
+@coverage:ignore func
+func One() int { return 1 }
+
+func Two() int { return 2 }
+`, Excluded: []int{4}},
+	{Name: "closure", Source: `package fixtures
+
+import "sort"
+
+func Sorted(items []string) []string {
+	out := append([]string{}, items...)
+	@coverage:ignore
+	sort.Slice(out, func(i, j int) bool {
+		return out[i] < out[j]
+	})
+	return out
+}
+`, Excluded: []int{8, 9}},
+	{Name: "typeswitch", Source: `package fixtures
+
+func Kind(v interface{}) string {
+	switch v.(type) {
+	@coverage:ignore
+	case error:
+		return "error"
+	case string:
+		return "string"
+	}
+	return "other"
+}
+`, Excluded: []int{6, 7}},
+	{Name: "generics", Source: `package fixtures
+
+func Map[T, U any](items []T, f func(T) U) []U {
+	if items == nil {
+		@coverage:ignore
+		return nil
+	}
+	out := make([]U, 0, len(items))
+	for _, item := range items {
+		out = append(out, f(item))
+	}
+	return out
+}
+
+type Stack[T any] struct {
+	items []T
+}
+
+func (s *Stack[T]) Push(v T) {
+	s.items = append(s.items, v)
+}
+
+@coverage:ignore func
+func (s *Stack[T]) Pop() T {
+	v := s.items[len(s.items)-1]
+	s.items = s.items[:len(s.items)-1]
+	return v
+}
+`, Excluded: []int{6, 25}},
+	{Name: "crlf", Source: `package fixtures
+
+func Sign(n int) int {
+	if n < 0 {
+		@coverage:ignore
+		return -1
+	}
+	return 1
+}
+`, CRLF: true, Excluded: []int{6}},
+	{Name: "spaces", Source: `package fixtures
+
+func Abs(n int) int {
+    if n < 0 {
+        @coverage:ignore
+        return -n
+    }
+    return n
+}
+
+func Clamp(n int) int {
+	if n > 9 {
+	    @coverage:ignore
+		return 9
+	}
+	return n
+}
+`, Excluded: []int{6, 14}},
+	{Name: "blockcomment", Source: `/* @coverage:ignore funcs=Reset */
+package fixtures
+
+func Bound(n int) int {
+	if n < 0 {
+		/* @coverage:ignore */
+		return 0
+	}
+	if n > 100 {
+		return 100 /* @coverage:ignore */
+	}
+	return n
+}
+
+func Reset() int {
+	return 0
+}
+`, Excluded: []int{7, 10, 16}},
+	{Name: "goroutine", Source: `package fixtures
+
+func Start(done chan<- int, watch bool) {
+	if watch {
+		@coverage:ignore
+		go func() {
+			for i := 0; i < 3; i++ {
+				done <- i
+			}
+		}()
+	}
+	close(done)
+}
+`, Excluded: []int{6, 7, 8}},
+	{Name: "panicpaths", Source: `package fixtures
+
+import "fmt"
+
+func MustPositive(n int) int {
+	if n < 0 {
+		msg := fmt.Sprint("negative ", n)
+		panic(msg)
+	}
+	switch n {
+	case 0:
+		panic("zero")
+	case 1:
+		n++
+	}
+	if n > 9 {
+		if n > 99 {
+			n = 99
+		}
+		panic(fmt.Sprint("large ", n))
+	}
+	return n
+}
+`, Excluded: []int{7, 11, 12}, Flags: []string{"--ignore-panic-paths"}},
+	{Name: "fatal", Source: `package fixtures
+
+import (
+	"log"
+	stdos "os"
+)
+
+func Exit(code int) int {
+	if code > 1 {
+		log.Printf("exiting with %d", code)
+		stdos.Exit(code)
+	}
+	if code < 0 {
+		log.Fatalf("negative code %d", code)
+	}
+	if code == 1 {
+		log.Print("one")
+	}
+	return code
+}
+`, Excluded: []int{10, 14}, Flags: []string{"--ignore-fatal"}},
+	{Name: "errreturns", Source: `package fixtures
+
+import (
+	"fmt"
+	"strconv"
+)
+
+func Parse(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, err
+	}
+	if err := check(n); err != nil {
+		return 0, fmt.Errorf("checking %d: %w", n, err)
+	}
+	if err != nil {
+		n = 0
+	}
+	return n, nil
+}
+
+func check(n int) error {
+	if n < 0 {
+		return fmt.Errorf("negative")
+	}
+	return nil
+}
+`, Excluded: []int{11, 14}, Flags: []string{"--ignore-err-returns"}},
+	{Name: "datafiles", Source: `package fixtures
+
+var Cases = []func(int) int{
+	func(n int) int {
+		return n + 1
+	},
+}
+`, Excluded: []int{4, 5}, Flags: []string{"--config", "datafiles.yml"}, Files: map[string]string{"datafiles.yml": "exclude_data_files:\n  - datafile*.go\n"}},
+	{Name: "datafilefuncs", Source: `package fixtures
+
+var Steps = []func(int) int{
+	func(n int) int {
+		return step(n)
+	},
+}
+
+func step(n int) int {
+	return n + 1
+}
+`, Flags: []string{"--config", "datafiles.yml"}, Files: map[string]string{"datafiles.yml": "exclude_data_files:\n  - datafile*.go\n"}},
+	{Name: "excludefuncs", Source: `package fixtures
+
+type Dumper struct{}
+
+func DebugDump(n int) int {
+	return n + 1
+}
+
+func (d Dumper) Dump(n int) int {
+	return n + 2
+}
+
+func Dump(n int) int {
+	return n + 3
+}
+`, Excluded: []int{5, 6, 9, 10}, Flags: []string{"--exclude-funcs", "^Debug", "--exclude-funcs", `^Dumper\.Dump$`}},
+	{Name: "receivers", Source: `package fixtures
+
+type FakeClock struct{ now int }
+
+func (c *FakeClock) Now() int {
+	c.now++
+	return c.now
+}
+
+type Clock struct{ now int }
+
+func (c *Clock) Now() int {
+	return c.now
+}
+`, Excluded: []int{5, 6}, Flags: []string{"--exclude-receivers", "*FakeClock"}},
+	{Name: "stdmethods", Source: `package fixtures
+
+type Color int
+
+func (c Color) String() string {
+	return "color"
+}
+
+func (c Color) MarshalJSON() ([]byte, error) {
+	return []byte("1"), nil
+}
+
+func (c Color) Error(code int) string {
+	return "not an error method"
+}
+
+func String() string {
+	return "not a method"
+}
+`, Excluded: []int{5, 6, 9, 10}, Flags: []string{"--exclude-stdmethods"}},
+	{Name: "smallfuncs", Source: `package fixtures
+
+type Point struct{ x int }
+
+func (p *Point) X() int { return p.x }
+
+func (p *Point) SetX(x int) {
+	p.x = x
+}
+
+func (p *Point) Move(dx int) int {
+	if dx == 0 {
+		return p.x
+	}
+	p.x += dx
+	return p.x
+}
+`, Excluded: []int{5, 7, 8}, Flags: []string{"--ignore-small-funcs", "1"}},
+	{Name: "preset", Source: `// Code generated by "stringer -type=Level"; DO NOT EDIT.
+
+package fixtures
+
+type Level int
+
+func (l Level) String() string {
+	return "level"
+}
+`, Excluded: []int{7, 8}, Flags: []string{"--preset", "stringer"}},
+	{Name: "mode_string", Source: `package fixtures
+
+type Mode int
+
+// String is written by hand, the stringer preset needs the generated header
+func (m Mode) String() string {
+	return "mode"
+}
+`, Flags: []string{"--preset", "stringer"}},
+	{Name: "stmtregex", Source: `package fixtures
+
+import "log"
+
+func Retry(n int) int {
+	if n > 3 {
+		log.Printf("giving up after %d tries", n)
+		return 0
+	}
+	return n + 1
+}
+`, Excluded: []int{7, 8}, Flags: []string{"--ignore-stmt-regex", `log\.Printf`}},
+	{Name: "recovers", Source: `package fixtures
+
+func Safe(f func()) (err error) {
+	err = nil
+	defer func() {
+		if r := recover(); r != nil {
+			err = errFromPanic(r)
+		}
+	}()
+	defer func() {
+		func() {
+			_ = recover()
+		}()
+	}()
+	defer cleanup()
+	f()
+	return err
+}
+
+func errFromPanic(r interface{}) error {
+	return nil
+}
+
+func cleanup() {
+	_ = recover()
+}
+`, Excluded: []int{6, 7, 8}, Flags: []string{"--ignore-recover-handlers"}},
+	{Name: "goroutines", Source: `package fixtures
+
+func Serve(requests <-chan int, done chan<- int) {
+	go func() {
+		for r := range requests {
+			done <- r
+		}
+	}()
+	go drain(requests)
+}
+
+func drain(requests <-chan int) {
+	for range requests {
+	}
+}
+`, Excluded: []int{5, 6, 7}, Flags: []string{"--ignore-goroutine-bodies"}},
+	{Name: "entrypoints", Source: `package fixtures
+
+type registry map[string]int
+
+var names registry
+
+func init() {
+	names = registry{}
+}
+
+func (r registry) init() {
+	r["default"] = 0
+}
+
+func Register(name string) {
+	names[name] = len(names)
+}
+`, Excluded: []int{7, 8}, Flags: []string{"--ignore-entrypoints"}},
+	{Name: "linguist", Source: `package fixtures
+
+func Version() string {
+	return "v1"
+}
+`, Excluded: []int{3, 4}, Flags: []string{"--exclude-linguist-generated"}, Files: map[string]string{".gitattributes": "linguist*.go linguist-generated=true\nlinguistkept.go -linguist-generated\n"}},
+	{Name: "linguistkept", Source: `package fixtures
+
+func Build() string {
+	return "b1"
+}
+`, Flags: []string{"--exclude-linguist-generated"}, Files: map[string]string{".gitattributes": "linguist*.go linguist-generated=true\nlinguistkept.go -linguist-generated\n"}},
+}
+
+const fixturesTest = `package fixtures
+
+import "testing"
+
+func TestFixtures(t *testing.T) {
+	Two()
+	Sorted([]string{"a"})
+	Kind("a")
+	Kind(1)
+	Map([]int{1}, func(n int) int { return n })
+	(&Stack[int]{}).Push(1)
+	Sign(1)
+	Abs(1)
+	Clamp(1)
+	Bound(1)
+	Start(make(chan int), false)
+}
+`
+
+func genFixturesCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "gen-fixtures",
+		Usage: "write a module of directive and option edge cases, with its coverage file and the expected corrected coverage file",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "output",
+				Usage: "directory of the fixtures module, created if needed",
+				Value: "fixtures",
+			},
+			&cli.BoolFlag{
+				Name:  "check",
+				Usage: "correct the coverage of the fixtures with this executable and fail when it differs from the expected one",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			dir, err := filepath.Abs(c.String("output"))
+			if err != nil {
+				return err
+			}
+			if err := genFixtures(os.Stdout, dir); err != nil {
+				return err
+			}
+			if !c.Bool("check") {
+				return nil
+			}
+			failures, err := checkFixtures(os.Stdout, dir)
+			if err != nil {
+				return err
+			}
+			if failures > 0 {
+				return fmt.Errorf("%d of the %d fixtures differ from the expected coverage", failures, len(fixtureCases))
+			}
+			fmt.Printf("All the %d fixtures match the expected coverage\n", len(fixtureCases))
+			return nil
+		},
+	}
+}
+
+// genFixtures writes the fixtures module in dir, measures its coverage with
+// the go command into coverage.out, and writes the coverage expected once
+// corrected into expected.out
+func genFixtures(w io.Writer, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	files := map[string]string{
+		"go.mod":           "module fixtures\n\ngo 1.18\n",
+		"fixtures_test.go": fixturesTest,
+	}
+	for _, tc := range fixtureCases {
+		source := strings.ReplaceAll(tc.Source, "@coverage:ignore", "//coverage:ignore")
+		if tc.CRLF {
+			source = strings.ReplaceAll(source, "\n", "\r\n")
+		}
+		files[tc.Name+".go"] = source
+		for name, content := range tc.Files {
+			files[name] = content
+		}
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+	if _, err := runIn(dir, "go", "test", "-coverprofile", "coverage.out", "-covermode", "count", "./..."); err != nil {
+		return err
+	}
+	profiles, err := cover.ParseProfiles(filepath.Join(dir, "coverage.out"))
+	if err != nil {
+		return err
+	}
+	for _, p := range profiles {
+		tc, ok := fixtureCaseOf(p.FileName)
+		if !ok {
+			continue
+		}
+		kept := []cover.ProfileBlock{}
+		for _, block := range p.Blocks {
+			if !containsInt(tc.Excluded, block.StartLine) {
+				kept = append(kept, block)
+			}
+		}
+		p.Blocks = kept
+	}
+	expected, err := os.Create(filepath.Join(dir, "expected.out"))
+	if err != nil {
+		return err
+	}
+	if err := profile.WriteProfiles(expected, profiles, profile.WriteOptions{}); err != nil {
+		expected.Close()
+		return err
+	}
+	if err := expected.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Wrote %d fixtures to %s, correct coverage.out and compare it with expected.out, with the flags of the cases having some\n", len(fixtureCases), dir)
+	return nil
+}
+
+// checkFixtures corrects the coverage of the fixtures module with this
+// executable, and compares the blocks of every fixture with the expected ones
+func checkFixtures(w io.Writer, dir string) (int, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := runIn(dir, executable, "--file", "coverage.out", "--output", "corrected.out"); err != nil {
+		return 0, err
+	}
+	expected, err := fixtureBlocks(filepath.Join(dir, "expected.out"))
+	if err != nil {
+		return 0, err
+	}
+	corrected, err := fixtureBlocks(filepath.Join(dir, "corrected.out"))
+	if err != nil {
+		return 0, err
+	}
+	failures := 0
+	for _, tc := range fixtureCases {
+		name, got := tc.Name, corrected[tc.Name]
+		if len(tc.Flags) > 0 {
+			//the flags apply to every file, only the blocks of the case are compared
+			output := "corrected-" + tc.Name + ".out"
+			args := append([]string{"--file", "coverage.out", "--output", output}, tc.Flags...)
+			if _, err := runIn(dir, executable, args...); err != nil {
+				return 0, err
+			}
+			flagged, err := fixtureBlocks(filepath.Join(dir, output))
+			if err != nil {
+				return 0, err
+			}
+			name, got = fmt.Sprintf("%s %s", tc.Name, strings.Join(tc.Flags, " ")), flagged[tc.Name]
+		}
+		want := expected[tc.Name]
+		if strings.Join(want, " ") != strings.Join(got, " ") {
+			failures++
+			fmt.Fprintf(w, "[fail] %s: expected the blocks %v, got %v\n", name, want, got)
+			continue
+		}
+		fmt.Fprintf(w, "[ok]   %s\n", name)
+	}
+	return failures, nil
+}
+
+// fixtureBlocks returns the blocks of the fixtures of a coverage file,
+// written start,end by fixture name
+func fixtureBlocks(path string) (map[string][]string, error) {
+	profiles, err := cover.ParseProfiles(path)
+	if err != nil {
+		return nil, err
+	}
+	blocks := map[string][]string{}
+	for _, p := range profiles {
+		if tc, ok := fixtureCaseOf(p.FileName); ok {
+			for _, block := range p.Blocks {
+				blocks[tc.Name] = append(blocks[tc.Name], fmt.Sprintf("%d.%d,%d.%d", block.StartLine, block.StartCol, block.EndLine, block.EndCol))
+			}
+		}
+	}
+	return blocks, nil
+}
+
+func fixtureCaseOf(fileName string) (fixtureCase, bool) {
+	for _, tc := range fixtureCases {
+		if filepath.Base(fileName) == tc.Name+".go" {
+			return tc, true
+		}
+	}
+	return fixtureCase{}, false
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,150 @@
+//coverage:ignore file
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// gitPattern is a pattern of a .gitattributes or a .gitignore file
+type gitPattern struct {
+	// dir is the directory of the file of the pattern, relative to the root
+	dir string
+	// basename is set for the patterns without a slash, matching the name of
+	// the files at any depth below dir
+	basename bool
+	re       *regexp.Regexp
+}
+
+// compileGitPattern compiles a pattern of the git file of a directory
+// relative to the root. A leading slash anchors the pattern to the
+// directory, like a slash in the middle.
+func compileGitPattern(dir string, pattern string) (gitPattern, error) {
+	re, err := regexp.Compile(globToRegexp(strings.TrimPrefix(pattern, "/")))
+	if err != nil {
+		return gitPattern{}, err
+	}
+	return gitPattern{dir: dir, basename: !strings.Contains(pattern, "/"), re: re}, nil
+}
+
+// matches reports whether the pattern matches a path relative to the root
+func (p gitPattern) matches(rel string) bool {
+	if p.dir != "." {
+		if !strings.HasPrefix(rel, p.dir+"/") {
+			return false
+		}
+		rel = strings.TrimPrefix(rel, p.dir+"/")
+	}
+	if p.basename {
+		return p.re.MatchString(path.Base(rel))
+	}
+	return p.re.MatchString(rel)
+}
+
+// gitAttributeRule is a line of a .gitattributes file setting or unsetting
+// linguist-generated
+type gitAttributeRule struct {
+	gitPattern
+	// source is the file and the line of the rule, like docs/.gitattributes:3
+	source    string
+	generated bool
+}
+
+// gitAttributes reads the linguist-generated attributes of the .gitattributes
+// files of the root and of its subdirectories, loaded once per directory
+type gitAttributes struct {
+	root  string
+	rules map[string][]gitAttributeRule
+}
+
+func newGitAttributes(root string) *gitAttributes {
+	return &gitAttributes{root: root, rules: map[string][]gitAttributeRule{}}
+}
+
+// dirRules returns the rules of the .gitattributes file of a directory
+// relative to the root, none when it has no such file
+func (g *gitAttributes) dirRules(dir string) ([]gitAttributeRule, error) {
+	if rules, ok := g.rules[dir]; ok {
+		return rules, nil
+	}
+	rules := []gitAttributeRule{}
+	name := path.Join(dir, ".gitattributes")
+	f, err := os.Open(filepath.Join(g.root, filepath.FromSlash(name)))
+	if os.IsNotExist(err) {
+		g.rules[dir] = rules
+		return rules, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") || strings.HasSuffix(fields[0], "/") {
+			continue
+		}
+		for _, attribute := range fields[1:] {
+			var generated bool
+			switch attribute {
+			case "linguist-generated", "linguist-generated=true":
+				generated = true
+			case "-linguist-generated", "linguist-generated=false":
+				generated = false
+			default:
+				continue
+			}
+			pattern, err := compileGitPattern(dir, fields[0])
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: invalid pattern [%s]: %w", name, line, fields[0], err)
+			}
+			rules = append(rules, gitAttributeRule{
+				gitPattern: pattern,
+				source:     fmt.Sprintf("%s:%d", name, line),
+				generated:  generated,
+			})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	g.rules[dir] = rules
+	return rules, nil
+}
+
+// generated returns the rule marking a file relative to the root as
+// linguist-generated, if any. The rules of the deeper directories, and the
+// later lines of a file, take precedence.
+func (g *gitAttributes) generated(rel string) (gitAttributeRule, bool, error) {
+	var last *gitAttributeRule
+	for _, dir := range parentDirs(rel) {
+		rules, err := g.dirRules(dir)
+		if err != nil {
+			return gitAttributeRule{}, false, err
+		}
+		for j, rule := range rules {
+			if rule.matches(rel) {
+				last = &rules[j]
+			}
+		}
+	}
+	if last == nil || !last.generated {
+		return gitAttributeRule{}, false, nil
+	}
+	return *last, true, nil
+}
+
+// parentDirs returns the directories of a path relative to the root, from
+// the root down to the directory of the path
+func parentDirs(rel string) []string {
+	dirs := []string{}
+	for dir := path.Dir(rel); dir != "."; dir = path.Dir(dir) {
+		dirs = append([]string{dir}, dirs...)
+	}
+	return append([]string{"."}, dirs...)
+}
@@ -0,0 +1,93 @@
+//coverage:ignore file
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// gitIgnoreRule is a line of a .gitignore file
+type gitIgnoreRule struct {
+	gitPattern
+	// negated rules, written with a leading !, include the paths again
+	negated bool
+	// dirOnly rules, written with a trailing /, only match directories
+	dirOnly bool
+}
+
+// gitIgnores reads the .gitignore files of the root and of its
+// subdirectories, loaded once per directory
+type gitIgnores struct {
+	root  string
+	rules map[string][]gitIgnoreRule
+}
+
+func newGitIgnores(root string) *gitIgnores {
+	return &gitIgnores{root: root, rules: map[string][]gitIgnoreRule{}}
+}
+
+// dirRules returns the rules of the .gitignore file of a directory relative
+// to the root, none when it has no such file
+func (g *gitIgnores) dirRules(dir string) ([]gitIgnoreRule, error) {
+	if rules, ok := g.rules[dir]; ok {
+		return rules, nil
+	}
+	rules := []gitIgnoreRule{}
+	name := path.Join(dir, ".gitignore")
+	f, err := os.Open(filepath.Join(g.root, filepath.FromSlash(name)))
+	if os.IsNotExist(err) {
+		g.rules[dir] = rules
+		return rules, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		rule := gitIgnoreRule{}
+		if strings.HasPrefix(text, "!") {
+			rule.negated, text = true, text[1:]
+		}
+		if strings.HasSuffix(text, "/") {
+			rule.dirOnly, text = true, strings.TrimSuffix(text, "/")
+		}
+		if rule.gitPattern, err = compileGitPattern(dir, text); err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid pattern [%s]: %w", name, line, text, err)
+		}
+		rules = append(rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	g.rules[dir] = rules
+	return rules, nil
+}
+
+// ignored reports whether a path relative to the root is ignored by the
+// .gitignore files of its parent directories. The deeper files and the later
+// lines take precedence. The walk skips the ignored directories, so a file
+// of an ignored directory cannot be included again, as with git.
+func (g *gitIgnores) ignored(rel string, isDir bool) (bool, error) {
+	ignored := false
+	for _, dir := range parentDirs(rel) {
+		rules, err := g.dirRules(dir)
+		if err != nil {
+			return false, err
+		}
+		for _, rule := range rules {
+			if (!rule.dirOnly || isDir) && rule.matches(rel) {
+				ignored = !rule.negated
+			}
+		}
+	}
+	return ignored, nil
+}
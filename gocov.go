@@ -0,0 +1,116 @@
+//coverage:ignore file
+package main
+
+import (
+	"encoding/json"
+	"go/ast"
+	"os"
+
+	"golang.org/x/tools/cover"
+)
+
+// GocovResult is the intermediate JSON of gocov, read by gocov report,
+// gocov-html and gocov-xml. The positions are byte offsets in the files.
+type GocovResult struct {
+	Packages []*GocovPackage
+}
+
+type GocovPackage struct {
+	// Name is the import path of the package
+	Name      string
+	Functions []*GocovFunction
+}
+
+type GocovFunction struct {
+	Name       string
+	File       string
+	Start      int
+	End        int
+	Statements []*GocovStatement
+}
+
+// GocovStatement is a block of the profile, like gocov convert does
+type GocovStatement struct {
+	Start   int
+	End     int
+	Reached int64
+}
+
+// gocovResult converts the corrected coverage to the gocov JSON. The excluded
+// blocks are left out of the statements of the functions, so the chains
+// ending with gocov-xml count the corrected coverage.
+func gocovResult(correction *Correction) (*GocovResult, error) {
+	result := &GocovResult{Packages: []*GocovPackage{}}
+	packages := map[string]*GocovPackage{}
+	for i, p := range correction.Profiles {
+		src, err := correction.Sources.syntax(correction.Files[i])
+		if err != nil {
+			return nil, err
+		}
+		name := packageOf(p.FileName)
+		pkg, ok := packages[name]
+		if !ok {
+			pkg = &GocovPackage{Name: name, Functions: []*GocovFunction{}}
+			packages[name] = pkg
+			result.Packages = append(result.Packages, pkg)
+		}
+		pkg.Functions = append(pkg.Functions, gocovFunctions(src, p.Blocks)...)
+	}
+	return result, nil
+}
+
+func gocovFunctions(src *sourceFile, blocks []cover.ProfileBlock) []*GocovFunction {
+	file := src.Fset.File(src.File.Pos())
+	offset := func(line int, col int) int {
+		if line > file.LineCount() {
+			return file.Size()
+		}
+		return file.Offset(file.LineStart(line)) + col - 1
+	}
+	functions := []*GocovFunction{}
+	for _, decl := range src.File.Decls {
+		funcDecl, ok := decl.(*ast.FuncDecl)
+		if !ok || funcDecl.Body == nil {
+			continue
+		}
+		function := &GocovFunction{
+			Name:       funcDecl.Name.Name,
+			File:       src.Path,
+			Start:      file.Offset(funcDecl.Pos()),
+			End:        file.Offset(funcDecl.End()),
+			Statements: []*GocovStatement{},
+		}
+		if recv := receiverTypeName(funcDecl); recv != "" {
+			function.Name = recv + "." + function.Name
+		}
+		body := src.bodyRange(funcDecl.Body)
+		for _, block := range blocks {
+			if body.Matches(block) {
+				function.Statements = append(function.Statements, &GocovStatement{
+					Start:   offset(block.StartLine, block.StartCol),
+					End:     offset(block.EndLine, block.EndCol),
+					Reached: int64(block.Count),
+				})
+			}
+		}
+		functions = append(functions, function)
+	}
+	return functions
+}
+
+// writeGocovFile writes the corrected coverage to a file in the gocov JSON
+func writeGocovFile(path string, correction *Correction) error {
+	result, err := gocovResult(correction)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(f).Encode(result); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,200 @@
+//coverage:ignore file
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/utils/diff"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// goGit implements VCS with go-git, reading the repository without a git
+// binary
+type goGit struct {
+	repo *git.Repository
+}
+
+func (g goGit) Root() (string, error) {
+	wt, err := g.repo.Worktree()
+	if err != nil {
+		return "", err
+	}
+	//like git rev-parse --show-toplevel, the symbolic links are resolved
+	return canonicalPath(filepath.Clean(wt.Filesystem.Root())), nil
+}
+
+func (g goGit) commit(ref string) (*object.Commit, error) {
+	hash, err := g.repo.ResolveRevision(plumbing.Revision(ref))
+	if err != nil {
+		return nil, fmt.Errorf("resolving %s: %w", ref, err)
+	}
+	return g.repo.CommitObject(*hash)
+}
+
+func (g goGit) ResolveRef(ref string) (string, error) {
+	commit, err := g.commit(ref)
+	if err != nil {
+		return "", err
+	}
+	return commit.Hash.String(), nil
+}
+
+func (g goGit) ListFiles(ref string) ([]string, error) {
+	commit, err := g.commit(ref)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	files := []string{}
+	err = tree.Files().ForEach(func(f *object.File) error {
+		files = append(files, f.Name)
+		return nil
+	})
+	return files, err
+}
+
+func (g goGit) ReadFile(ref string, path string) ([]byte, error) {
+	commit, err := g.commit(ref)
+	if err != nil {
+		return nil, err
+	}
+	f, err := commit.File(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s at %s: %w", path, ref, err)
+	}
+	content, err := f.Contents()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(content), nil
+}
+
+// AddedLines diffs the files changed since the merge base, in the commits or
+// in the working tree, with their content at the merge base
+func (g goGit) AddedLines(base string) (map[string][]LineRange, error) {
+	head, err := g.commit("HEAD")
+	if err != nil {
+		return nil, err
+	}
+	baseCommit, err := g.commit(base)
+	if err != nil {
+		return nil, err
+	}
+	mergeBases, err := baseCommit.MergeBase(head)
+	if err != nil {
+		return nil, err
+	}
+	if len(mergeBases) == 0 {
+		return nil, fmt.Errorf("%s and HEAD have no merge base", base)
+	}
+	baseTree, err := mergeBases[0].Tree()
+	if err != nil {
+		return nil, err
+	}
+	headTree, err := head.Tree()
+	if err != nil {
+		return nil, err
+	}
+	changes, err := object.DiffTree(baseTree, headTree)
+	if err != nil {
+		return nil, err
+	}
+	changed := map[string]bool{}
+	for _, change := range changes {
+		if change.To.Name != "" {
+			changed[change.To.Name] = true
+		}
+	}
+	wt, err := g.repo.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	status, err := wt.Status()
+	if err != nil {
+		return nil, err
+	}
+	added := map[string][]LineRange{}
+	for file, fileStatus := range status {
+		switch {
+		case fileStatus.Worktree == git.Untracked:
+			added[file] = []LineRange{{Start: 1, End: math.MaxInt32}}
+		case fileStatus.Worktree != git.Unmodified || fileStatus.Staging != git.Unmodified:
+			changed[file] = true
+		}
+	}
+	root := wt.Filesystem.Root()
+	for file := range changed {
+		if _, untracked := added[file]; untracked {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(file)))
+		if errors.Is(err, os.ErrNotExist) {
+			//deleted in the working tree, nothing is added
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		previous := ""
+		if f, err := baseTree.File(file); err == nil {
+			if previous, err = f.Contents(); err != nil {
+				return nil, err
+			}
+		} else if !errors.Is(err, object.ErrFileNotFound) {
+			return nil, err
+		}
+		if ranges := addedRanges(previous, string(content)); len(ranges) > 0 {
+			added[file] = ranges
+		}
+	}
+	return added, nil
+}
+
+// addedRanges returns the line ranges of current added or modified since
+// previous, with a line oriented diff
+func addedRanges(previous string, current string) []LineRange {
+	ranges := []LineRange{}
+	line := 1
+	for _, d := range diff.Do(previous, current) {
+		count := strings.Count(d.Text, "\n")
+		if !strings.HasSuffix(d.Text, "\n") && d.Text != "" {
+			//the last line of a file without a final newline
+			count++
+		}
+		switch d.Type {
+		case diffmatchpatch.DiffEqual:
+			line += count
+		case diffmatchpatch.DiffInsert:
+			ranges = append(ranges, LineRange{Start: line, End: line + count - 1})
+			line += count
+		}
+	}
+	return ranges
+}
+
+func (g goGit) Blame(ref string, path string) ([]BlameLine, error) {
+	commit, err := g.commit(ref)
+	if err != nil {
+		return nil, err
+	}
+	result, err := git.Blame(commit, path)
+	if err != nil {
+		return nil, fmt.Errorf("blaming %s at %s: %w", path, ref, err)
+	}
+	lines := make([]BlameLine, len(result.Lines))
+	for i, line := range result.Lines {
+		lines[i] = BlameLine{Commit: line.Hash.String(), Author: line.Author, Date: line.Date}
+	}
+	return lines, nil
+}
@@ -0,0 +1,177 @@
+//coverage:ignore file
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/cover"
+)
+
+// htmlFile is a file of the HTML view, its source split in spans
+type htmlFile struct {
+	Path     string
+	Coverage float64
+	Spans    []htmlSpan
+}
+
+// htmlSpan is a part of the source, Class is empty out of the blocks
+type htmlSpan struct {
+	Class string
+	Title string
+	Text  string
+}
+
+// htmlClass returns the class of a block in the HTML view, the excluded
+// blocks are greyed, soft exclusions included
+func htmlClass(block cover.ProfileBlock, exclusion *Exclusion) (string, string) {
+	switch {
+	case exclusion != nil && exclusion.Soft:
+		return "soft", exclusionTitle("soft excluded", exclusion)
+	case exclusion != nil:
+		return "excluded", exclusionTitle("excluded", exclusion)
+	case block.Count > 0:
+		return "cov", fmt.Sprintf("run %d times", block.Count)
+	}
+	return "uncov", "not covered"
+}
+
+func exclusionTitle(kind string, exclusion *Exclusion) string {
+	parts := []string{kind}
+	if exclusion.Source != "" {
+		parts = append(parts, exclusion.Source)
+	}
+	if exclusion.Reason != "" {
+		parts = append(parts, exclusion.Reason)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// htmlSpans splits the content of a file by the blocks of its profile, the
+// blocks overlapping a previous one are left out
+func htmlSpans(content []byte, blocks []cover.ProfileBlock, exclusions []Exclusion) []htmlSpan {
+	excluded := map[[4]int]*Exclusion{}
+	for i, e := range exclusions {
+		excluded[[4]int{e.StartLine, e.StartCol, e.EndLine, e.EndCol}] = &exclusions[i]
+	}
+	lineStarts := []int{0}
+	for i, b := range content {
+		if b == '\n' {
+			lineStarts = append(lineStarts, i+1)
+		}
+	}
+	offset := func(line int, col int) int {
+		if line > len(lineStarts) {
+			return len(content)
+		}
+		o := lineStarts[line-1] + col - 1
+		if o > len(content) {
+			return len(content)
+		}
+		return o
+	}
+	sorted := append([]cover.ProfileBlock{}, blocks...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].StartLine != sorted[j].StartLine {
+			return sorted[i].StartLine < sorted[j].StartLine
+		}
+		return sorted[i].StartCol < sorted[j].StartCol
+	})
+	spans := []htmlSpan{}
+	pos := 0
+	for _, block := range sorted {
+		start, end := offset(block.StartLine, block.StartCol), offset(block.EndLine, block.EndCol)
+		if start < pos || end <= start {
+			continue
+		}
+		if start > pos {
+			spans = append(spans, htmlSpan{Text: string(content[pos:start])})
+		}
+		class, title := htmlClass(block, excluded[[4]int{block.StartLine, block.StartCol, block.EndLine, block.EndCol}])
+		spans = append(spans, htmlSpan{Class: class, Title: title, Text: string(content[start:end])})
+		pos = end
+	}
+	if pos < len(content) {
+		spans = append(spans, htmlSpan{Text: string(content[pos:])})
+	}
+	return spans
+}
+
+// writeHTML writes the corrected coverage as a page like the one of go tool
+// cover -html, with the excluded blocks greyed rather than left out, so the
+// soft exclusions still show in the view
+func writeHTML(w io.Writer, correction *Correction) error {
+	files := []htmlFile{}
+	for i, file := range correction.Report.Files {
+		content, err := correction.Sources.content(correction.Files[i])
+		if err != nil {
+			return err
+		}
+		files = append(files, htmlFile{
+			Path:     file.Path,
+			Coverage: file.Coverage,
+			Spans:    htmlSpans(content, correction.Blocks[i], file.Exclusions),
+		})
+	}
+	var buf bytes.Buffer
+	if err := htmlTemplate.Execute(&buf, files); err != nil {
+		return err
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// writeHTMLFile writes the HTML view to a file
+func writeHTMLFile(path string, correction *Correction) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeHTML(f, correction); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+var htmlTemplate = template.Must(template.New("html").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>go-ignore-cov</title>
+<style>
+body { background: black; color: rgb(80, 80, 80); font-family: Menlo, monospace; }
+#nav { padding: 5px; }
+pre { margin: 0; padding: 5px; }
+.cov { color: rgb(44, 212, 149); }
+.uncov { color: rgb(192, 0, 0); }
+.excluded { color: rgb(128, 128, 128); }
+.soft { color: rgb(128, 128, 128); text-decoration: underline dotted; }
+.legend span { margin-right: 10px; }
+</style>
+</head>
+<body>
+<div id="nav">
+<select id="files">
+{{range $i, $f := .}}<option value="file{{$i}}">{{$f.Path}} ({{printf "%.1f" $f.Coverage}}%)</option>
+{{end}}</select>
+<span class="legend"><span class="uncov">not covered</span><span class="cov">covered</span><span class="excluded">excluded</span><span class="soft">soft excluded</span></span>
+</div>
+{{range $i, $f := .}}<pre class="file" id="file{{$i}}"{{if $i}} style="display: none"{{end}}>{{range $f.Spans}}{{if .Class}}<span class="{{.Class}}" title="{{.Title}}">{{.Text}}</span>{{else}}{{.Text}}{{end}}{{end}}</pre>
+{{end}}<script>
+var files = document.getElementById('files');
+files.addEventListener('change', function() {
+	var pres = document.getElementsByClassName('file');
+	for (var i = 0; i < pres.length; i++) {
+		pres[i].style.display = pres[i].id === files.value ? 'block' : 'none';
+	}
+});
+</script>
+</body>
+</html>
+`))
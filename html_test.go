@@ -0,0 +1,87 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/cover"
+)
+
+func TestHTMLSpans(t *testing.T) {
+	content := []byte("package a\n\nfunc A() {\n\tx()\n}\n")
+	blocks := []cover.ProfileBlock{
+		{StartLine: 3, StartCol: 10, EndLine: 5, EndCol: 2, NumStmt: 1, Count: 0},
+		//overlapping the first block, left out
+		{StartLine: 4, StartCol: 2, EndLine: 4, EndCol: 5, NumStmt: 1, Count: 1},
+	}
+	exclusions := []Exclusion{{StartLine: 3, StartCol: 10, EndLine: 5, EndCol: 2, Soft: true, Source: "a.go:3"}}
+	expected := []htmlSpan{
+		{Text: "package a\n\nfunc A() "},
+		{Class: "soft", Title: "soft excluded, a.go:3", Text: "{\n\tx()\n}"},
+		{Text: "\n"},
+	}
+	spans := htmlSpans(content, blocks, exclusions)
+	if len(spans) != len(expected) {
+		t.Fatalf("expected the spans %v, got %v", expected, spans)
+	}
+	for i := range spans {
+		if spans[i] != expected[i] {
+			t.Errorf("expected the span %v, got %v", expected[i], spans[i])
+		}
+	}
+}
+
+func TestHTML(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"go.mod": "module example.com/app\n\ngo 1.18\n",
+		"app.go": `package app
+
+func Hard() int {
+	@coverage:ignore
+	return 1
+}
+
+func Soft() int {
+	@coverage:ignore soft
+	return 2
+}
+
+func Run() int {
+	return 3
+}
+
+func Escaped() string {
+	return "<b>"
+}
+`,
+		"coverage.out": "mode: set\n" +
+			"example.com/app/app.go:3.17,5.10 1 0\n" +
+			"example.com/app/app.go:8.17,10.10 1 0\n" +
+			"example.com/app/app.go:13.16,15.2 1 1\n" +
+			"example.com/app/app.go:17.23,19.2 1 0\n",
+	})
+	correction, err := correctCoverage(correctionContext(t, "--root", dir, "--file", filepath.Join(dir, "coverage.out")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	if err := writeHTML(&out, correction); err != nil {
+		t.Fatal(err)
+	}
+	//the soft excluded block is still in the profile, but greyed like the
+	//excluded one
+	for _, expected := range []string{
+		`<span class="excluded" title="excluded, app.go:4">`,
+		`<span class="soft" title="soft excluded, app.go:9">`,
+		`<span class="cov" title="run 1 times">`,
+		`<span class="uncov" title="not covered">{
+	return &#34;&lt;b&gt;&#34;
+}</span>`,
+		`<option value="file0">app.go (50.0%)</option>`,
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("expected the page to contain %q, got:\n%s", expected, out.String())
+		}
+	}
+}
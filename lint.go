@@ -0,0 +1,411 @@
+//coverage:ignore file
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"golang.org/x/tools/cover"
+)
+
+func lintCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "lint",
+		Usage: "report the ignore directives that are redundant or do not match any coverage block",
+		Flags: append(correctionFlags(),
+			&cli.Float64Flag{
+				Name:  "max-function-ignore",
+				Usage: "maximum percentage of the statements of a function excluded by directives, max_function_ignore of the configuration by default",
+			},
+		),
+		Action: func(c *cli.Context) error {
+			correction, err := correctCoverage(c)
+			if err != nil {
+				return err
+			}
+			defer correction.Warnings.flush()
+			maxFunctionIgnore := correction.Config.MaxFunctionIgnore
+			if c.IsSet("max-function-ignore") {
+				maxFunctionIgnore = c.Float64("max-function-ignore")
+			}
+			issues := correction.Violations
+			now := time.Now()
+			for i, ignore := range correction.Ignores {
+				if ignore == nil {
+					continue
+				}
+				path := correction.Report.Files[i].Path
+				issues = append(issues, lintInstructions(path, ignore.Instructions, correction.Blocks[i])...)
+				issues = append(issues, lintOptions(path, ignore.Instructions, correction.Config.RequiredOptions, now)...)
+				unreachableIssues, err := lintUnreachable(path, ignore, correction.Sources)
+				if err != nil {
+					return err
+				}
+				issues = append(issues, unreachableIssues...)
+				if maxFunctionIgnore > 0 {
+					densityIssues, err := lintIgnoreDensity(path, ignore, correction.Blocks[i], correction.Sources, maxFunctionIgnore)
+					if err != nil {
+						return err
+					}
+					issues = append(issues, densityIssues...)
+				}
+			}
+			printLintIssues(os.Stdout, issues)
+			if len(issues) > 0 {
+				return fmt.Errorf("%d directive issue(s) found", len(issues))
+			}
+			return nil
+		},
+	}
+}
+
+// LintIssue is a directive that has no effect on the coverage
+type LintIssue struct {
+	Path    string
+	Line    int
+	Message string
+}
+
+// lintInstructions checks the block directives of a file against the blocks
+// of its profile. A block directive is useless in a file ignored with a file
+// or package directive, outside of the functions unignored with an off
+// directive, when it matches no block, or when all its blocks are already
+// ignored by another directive. An off directive is useless in a file not
+// ignored as a whole.
+func lintInstructions(path string, instructions []Instruction, blocks []cover.ProfileBlock) []LintIssue {
+	issues := []LintIssue{}
+	fileLine := 0
+	var pkg *IgnorePackage
+	ignoreBlocks := []IgnoreBlock{}
+	unignores := []Unignore{}
+	for _, instruction := range instructions {
+		switch ig := instruction.(type) {
+		case IgnoreFile:
+			fileLine = ig.Line
+		case IgnorePackage:
+			pkg = &ig
+		case Unignore:
+			unignores = append(unignores, ig)
+		case IgnoreBlock:
+			ignoreBlocks = append(ignoreBlocks, ig)
+		}
+	}
+	sort.SliceStable(ignoreBlocks, func(i, j int) bool {
+		return ignoreBlocks[i].Line < ignoreBlocks[j].Line
+	})
+	if fileLine > 0 || pkg != nil {
+		ignored := fmt.Sprintf("the file is ignored by the directive at line %d", fileLine)
+		if fileLine == 0 {
+			ignored = fmt.Sprintf("the package is ignored by the directive at %s:%d", filepath.Base(pkg.File), pkg.Line)
+		}
+		unignored := []IgnoreBlock{}
+		for _, ig := range ignoreBlocks {
+			if isUnignored(unignores, ig.Line) {
+				unignored = append(unignored, ig)
+				continue
+			}
+			issues = append(issues, LintIssue{Path: path, Line: ig.Line,
+				Message: fmt.Sprintf("the directive before this line is useless, %s", ignored)})
+		}
+		ignoreBlocks = unignored
+	} else {
+		for _, ig := range unignores {
+			issues = append(issues, LintIssue{Path: path, Line: ig.Line,
+				Message: "the off directive of this function is useless, the file is not ignored as a whole"})
+		}
+	}
+	ignoredBy := map[int]int{}
+	for _, ig := range ignoreBlocks {
+		matched, fresh, redundantWith := false, false, 0
+		for i, block := range blocks {
+			if !ig.Matches(block) {
+				continue
+			}
+			matched = true
+			if line, ok := ignoredBy[i]; ok {
+				redundantWith = line
+			} else {
+				ignoredBy[i] = ig.Line
+				fresh = true
+			}
+		}
+		if !matched {
+			issues = append(issues, LintIssue{Path: path, Line: ig.Line, Message: "the directive before this line does not match any coverage block"})
+		} else if !fresh {
+			issues = append(issues, LintIssue{Path: path, Line: ig.Line,
+				Message: fmt.Sprintf("the block of this line is already ignored by the directive before line %d", redundantWith)})
+		}
+	}
+	return issues
+}
+
+// lintOptions checks the options of the directives, the options required by
+// the configuration must be set and the until dates must not be passed
+func lintOptions(path string, instructions []Instruction, required []string, now time.Time) []LintIssue {
+	issues := []LintIssue{}
+	seen := map[int]bool{}
+	for _, instruction := range instructions {
+		origin := originOf(instruction)
+		//a directive may give several instructions, like with funcs
+		if origin.Line == 0 || seen[origin.Line] {
+			continue
+		}
+		seen[origin.Line] = true
+		for _, key := range required {
+			if _, ok := origin.Options[key]; ok || (key == OptionReason && origin.Reason != "") {
+				continue
+			}
+			issues = append(issues, LintIssue{Path: path, Line: origin.Line,
+				Message: fmt.Sprintf("the directive has no %s option, required by the configuration", key)})
+		}
+		if until, ok := origin.Options[OptionUntil]; ok {
+			expiry, err := Exemption{Until: until}.expiry()
+			if err != nil {
+				issues = append(issues, LintIssue{Path: path, Line: origin.Line, Message: fmt.Sprintf("the directive has an %v", err)})
+			} else if !now.Before(expiry) {
+				issues = append(issues, LintIssue{Path: path, Line: origin.Line,
+					Message: fmt.Sprintf("the directive expired on %s, test the code or extend the until date", until)})
+			}
+		}
+	}
+	return issues
+}
+
+// isUnignored reports whether a line is in a function unignored with an off
+// directive
+func isUnignored(unignores []Unignore, line int) bool {
+	for _, ig := range unignores {
+		if line >= ig.Body.StartLine && line <= ig.Body.EndLine {
+			return true
+		}
+	}
+	return false
+}
+
+// lintIgnoreDensity reports the functions with more than max percent of
+// their statements excluded, as they are being hollowed out of the coverage
+// one block at a time. The functions ignored as a whole are left out, their
+// directive is explicit.
+func lintIgnoreDensity(path string, ignore *IgnoreCoverage, blocks []cover.ProfileBlock, sources *sourceCache, max float64) ([]LintIssue, error) {
+	issues := []LintIssue{}
+	for _, instruction := range ignore.Instructions {
+		switch instruction.(type) {
+		case IgnoreFile, IgnorePackage:
+			return issues, nil
+		}
+	}
+	src, err := sources.syntax(ignore.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	for _, decl := range src.File.Decls {
+		funcDecl, ok := decl.(*ast.FuncDecl)
+		if !ok || funcDecl.Body == nil {
+			continue
+		}
+		body := src.bodyRange(funcDecl.Body)
+		if ignoredAsWhole(ignore.Instructions, body) {
+			continue
+		}
+		statements, excluded := 0, 0
+		for _, block := range blocks {
+			if !body.Matches(block) {
+				continue
+			}
+			statements += block.NumStmt
+			if _, matched := matchInstruction(ignore.Instructions, block); matched {
+				excluded += block.NumStmt
+			}
+		}
+		if statements == 0 {
+			continue
+		}
+		if density := float64(excluded) * 100 / float64(statements); density > max {
+			issues = append(issues, LintIssue{Path: path, Line: src.line(funcDecl.Pos()),
+				Message: fmt.Sprintf("%.1f%% of the statements of %s are excluded, above the %.1f%% limit, ignore the whole function explicitly or test it",
+					density, funcDecl.Name.Name, max)})
+		}
+	}
+	return issues, nil
+}
+
+// lintUnreachable reports the block directives placed on statements that
+// cannot run, following a return, a panic or a branch statement in the same
+// list. Such a directive most likely drifted from the code it was written for.
+func lintUnreachable(path string, ignore *IgnoreCoverage, sources *sourceCache) ([]LintIssue, error) {
+	issues := []LintIssue{}
+	ignoreBlocks := []IgnoreBlock{}
+	for _, instruction := range ignore.Instructions {
+		if ig, ok := instruction.(IgnoreBlock); ok && ig.Origin.Line > 0 {
+			ignoreBlocks = append(ignoreBlocks, ig)
+		}
+	}
+	if len(ignoreBlocks) == 0 {
+		return issues, nil
+	}
+	src, err := sources.syntax(ignore.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	for _, dead := range src.unreachable() {
+		for _, ig := range ignoreBlocks {
+			if ig.Line >= dead.StartLine && ig.Line <= dead.EndLine {
+				issues = append(issues, LintIssue{Path: path, Line: ig.Line,
+					Message: fmt.Sprintf("the directive before this line is on unreachable code, after the %s at line %d, it most likely drifted from its code", dead.after, dead.line)})
+			}
+		}
+	}
+	return issues, nil
+}
+
+// unreachableRange is a range of lines of statements following a
+// terminating statement
+type unreachableRange struct {
+	StartLine int
+	EndLine   int
+	after     string
+	line      int
+}
+
+// unreachable returns the statements following a return, a panic or a branch
+// statement in a list of statements. A labeled statement may be reached with
+// a goto, and ends the range.
+func (s *sourceFile) unreachable() []unreachableRange {
+	ranges := []unreachableRange{}
+	check := func(stmts []ast.Stmt) {
+		for i := 0; i < len(stmts)-1; i++ {
+			after := ""
+			switch st := stmts[i].(type) {
+			case *ast.ReturnStmt:
+				after = "return"
+			case *ast.BranchStmt:
+				if st.Tok != token.FALLTHROUGH {
+					after = st.Tok.String()
+				}
+			default:
+				if isPanic(st) {
+					after = "panic"
+				}
+			}
+			if after == "" {
+				continue
+			}
+			end := i + 1
+			for end < len(stmts) {
+				if _, ok := stmts[end].(*ast.LabeledStmt); ok {
+					break
+				}
+				end++
+			}
+			if end > i+1 {
+				ranges = append(ranges, unreachableRange{
+					StartLine: s.line(stmts[i+1].Pos()),
+					EndLine:   s.Fset.Position(stmts[end-1].End()).Line,
+					after:     after,
+					line:      s.line(stmts[i].Pos()),
+				})
+			}
+			i = end - 1
+		}
+	}
+	ast.Inspect(s.File, func(node ast.Node) bool {
+		switch n := node.(type) {
+		case *ast.BlockStmt:
+			check(n.List)
+		case *ast.CaseClause:
+			check(n.Body)
+		case *ast.CommClause:
+			check(n.Body)
+		}
+		return true
+	})
+	return ranges
+}
+
+// ignoredAsWhole reports whether an instruction ignores the whole body
+func ignoredAsWhole(instructions []Instruction, body IgnoreRange) bool {
+	for _, instruction := range instructions {
+		if ig, ok := instruction.(IgnoreRange); ok && ig.StartLine == body.StartLine && ig.StartCol == body.StartCol &&
+			ig.EndLine == body.EndLine && ig.EndCol == body.EndCol {
+			return true
+		}
+	}
+	return false
+}
+
+// forbiddenFileIgnores returns the file and package directives of the files
+// where the configuration only allows block directives
+func forbiddenFileIgnores(root string, resolver *moduleResolver, ignoreCoverages []IgnoreCoverage, forbidden PathPatterns) []LintIssue {
+	issues := []LintIssue{}
+	if len(forbidden) == 0 {
+		return issues
+	}
+	files := make([]FilePath, len(ignoreCoverages))
+	for i, ignore := range ignoreCoverages {
+		files[i] = newFilePath(relativePath(root, ignore.Filepath), ignore.Filepath)
+		files[i].Import = resolver.fileName(ignore.Filepath)
+	}
+	matches := forbidden.MatchFiles(files)
+	for i, ignore := range ignoreCoverages {
+		if matches[i] == nil {
+			continue
+		}
+		rel := files[i].Rel
+		for _, instruction := range ignore.Instructions {
+			switch ig := instruction.(type) {
+			case IgnoreFile:
+				if ig.Line == 0 {
+					//the file is ignored by an option, like --exclude-generated
+					continue
+				}
+				issues = append(issues, LintIssue{Path: rel, Line: ig.Line,
+					Message: "file directives are forbidden here by the configuration, ignore blocks instead"})
+			case IgnorePackage:
+				issues = append(issues, LintIssue{Path: rel, Line: ig.Line,
+					Message: "package directives are forbidden here by the configuration, ignore blocks instead"})
+			}
+		}
+	}
+	return issues
+}
+
+// unjustifiedDirectives returns the directives without a reason, or whose
+// reason does not match the pattern when there is one
+func unjustifiedDirectives(root string, ignoreCoverages []IgnoreCoverage, pattern *regexp.Regexp) []LintIssue {
+	issues := []LintIssue{}
+	for _, ignore := range ignoreCoverages {
+		rel := relativePath(root, ignore.Filepath)
+		seen := map[int]bool{}
+		for _, instruction := range ignore.Instructions {
+			origin := originOf(instruction)
+			//a directive may give several instructions, like with funcs
+			if origin.Line == 0 || seen[origin.Line] {
+				continue
+			}
+			seen[origin.Line] = true
+			switch {
+			case origin.Reason == "":
+				issues = append(issues, LintIssue{Path: rel, Line: origin.Line,
+					Message: "the directive has no reason, add it after a dash at the end of the directive"})
+			case pattern != nil && !pattern.MatchString(origin.Reason):
+				issues = append(issues, LintIssue{Path: rel, Line: origin.Line,
+					Message: fmt.Sprintf("the reason [%s] of the directive does not match the required pattern [%s]", origin.Reason, pattern)})
+			}
+		}
+	}
+	return issues
+}
+
+func printLintIssues(w io.Writer, issues []LintIssue) {
+	for _, issue := range issues {
+		fmt.Fprintf(w, "%s:%d: %s\n", issue.Path, issue.Line, issue.Message)
+	}
+}
@@ -3,6 +3,7 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"go/build"
 	"io"
@@ -10,59 +11,330 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode"
 
+	"github.com/quantumcycle/go-ignore-cov/profile"
 	"github.com/urfave/cli/v2"
+	"golang.org/x/mod/modfile"
 	"golang.org/x/tools/cover"
 )
 
 const (
-	InstructionBlock   = "block"
-	InstructionFile    = "file"
+	InstructionBlock = "block"
+	InstructionFile  = "file"
+	// InstructionPackage ignores all the files of the package of the file
+	InstructionPackage = "package"
+	InstructionSoft    = "soft"
+	InstructionFunc    = "func"
+	InstructionBegin   = "begin"
+	InstructionEnd     = "end"
+	// InstructionType ignores the methods of the type following the directive,
+	// in all the files of its package
+	InstructionType = "type"
+	// InstructionStmt ignores the whole statement following the directive,
+	// like an if statement with all its else branches
+	InstructionStmt = "stmt"
+	// InstructionBranch ignores the branch of the if, else or case following
+	// the directive, or on the line of the directive, with its nested blocks
+	InstructionBranch = "branch"
+	// InstructionOff keeps a function of a file or package ignored as a whole
+	InstructionOff = "off"
+	// InstructionNext is followed by the number of lines to ignore
+	InstructionNext    = "next"
 	DefaultInstruction = InstructionBlock
 )
 
+const (
+	// OptionImpl lists the interfaces whose methods are ignored on the type
+	// following the directive
+	OptionImpl = "impl"
+	// OptionGeneratedBy names the code generator of a file ignored with a
+	// file directive
+	OptionGeneratedBy = "generated-by"
+	// OptionFuncs lists the functions of the file to ignore, placed once
+	// anywhere in the file
+	OptionFuncs = "funcs"
+	// OptionExcept lists the functions of the file kept measured in a file
+	// or package directive
+	OptionExcept = "except"
+	// OptionScope restricts the blocks ignored in the function following the
+	// directive
+	OptionScope = "scope"
+	// OptionGroup names the group of the directive, the groups can be
+	// disabled with --disable-groups
+	OptionGroup = "group"
+	// OptionIf makes the directive conditional, it only applies when its
+	// condition is passed with --conditions, or when it is not with a !
+	OptionIf = "if"
+	// OptionFlag ties the directive to feature flags, it only applies while
+	// one of them is not passed with --active-flags
+	OptionFlag = "flag"
+	// OptionReason is the justification of the directive, like the text
+	// following a dash
+	OptionReason = "reason"
+	// OptionUntil is the date the directive expires, checked by lint
+	OptionUntil = "until"
+	// OptionGOOS and OptionGOARCH restrict the directive to some platforms,
+	// like goos=windows or goarch=!amd64,arm64
+	OptionGOOS   = "goos"
+	OptionGOARCH = "goarch"
+)
+
+// ScopeErrorReturns only ignores the blocks returning an error
+const ScopeErrorReturns = "error-returns"
+
 type IgnoreCoverage struct {
 	Filepath     string
 	Instructions []Instruction
 }
 
 type Instruction interface {
-	UpdateProfile(profile *cover.Profile, verbose bool)
+	Matches(block cover.ProfileBlock) bool
 }
 
-type IgnoreBlock struct {
+// Directive is a parsed coverage:ignore comment
+type Directive struct {
+	Instruction string
+	Options     map[string]string
+	// Inline directives follow code on the same line
+	Inline bool
+	// Line is the line of the directive in its file
 	Line int
-	Col int
+	// Reason is the free text following the directive after a dash
+	Reason string
 }
 
-func (ig IgnoreBlock) UpdateProfile(profile *cover.Profile, verbose bool) {
-	newBlocks := []cover.ProfileBlock{}
-	igPos,_ := strconv.Atoi(fmt.Sprintf("%d%05d",ig.Line, ig.Col))
-	for _, block := range profile.Blocks {
-		blockStart, _ := strconv.Atoi(fmt.Sprintf("%d%05d",block.StartLine, block.StartCol))
-		blockEnd, _ := strconv.Atoi(fmt.Sprintf("%d%05d",block.EndLine, block.EndCol))
-		if igPos >= blockStart && igPos < blockEnd {
-			//whole block inside the ignore zone, just ignore it
-			if verbose {
-				fmt.Printf("Removing coverage block [%d.%d] => [%d.%d] for %s\n",
-					block.StartLine, block.StartCol, block.EndLine, block.EndCol, profile.FileName)
-			}
+func (d Directive) origin() Origin {
+	origin := Origin{Line: d.Line, Reason: d.Reason, Group: d.Options[OptionGroup]}
+	for key, value := range d.Options {
+		if key == OptionReason {
 			continue
 		}
-		newBlocks = append(newBlocks, block)
+		if origin.Options == nil {
+			origin.Options = map[string]string{}
+		}
+		origin.Options[key] = value
 	}
-	profile.Blocks = newBlocks
+	return origin
+}
+
+// Groups tracks the groups of the directives and the disabled groups, whose
+// directives are left out
+type Groups struct {
+	disabled map[string]bool
+	// seen counts the directives of each group
+	seen map[string]int
+}
+
+func newGroups(disabled []string) *Groups {
+	groups := &Groups{disabled: map[string]bool{}, seen: map[string]int{}}
+	for _, group := range disabled {
+		groups.disabled[group] = true
+	}
+	return groups
+}
+
+// disables reports whether the directive belongs to a disabled group
+func (g *Groups) disables(directive Directive) bool {
+	group, ok := directive.Options[OptionGroup]
+	if g == nil || !ok {
+		return false
+	}
+	g.seen[group]++
+	return g.disabled[group]
+}
+
+// unknownDisabled returns the disabled groups without any directive
+func (g *Groups) unknownDisabled() []string {
+	unknown := []string{}
+	for group := range g.disabled {
+		if g.seen[group] == 0 {
+			unknown = append(unknown, group)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// targetsLine reports whether the directive applies to a line of code, the
+// next one or, when inline, its own
+func (d Directive) targetsLine() bool {
+	if _, ok := d.Options[OptionFuncs]; ok {
+		return false
+	}
+	return d.Instruction == InstructionBlock || d.Instruction == InstructionSoft || d.Instruction == InstructionFunc ||
+		d.Instruction == InstructionOff || d.Instruction == InstructionType || d.Instruction == InstructionStmt || d.Instruction == InstructionBranch
+}
+
+// targetsDeclaration reports whether the directive applies to the declaration
+// following it rather than to a block
+func (d Directive) targetsDeclaration() bool {
+	_, impl := d.Options[OptionImpl]
+	_, scope := d.Options[OptionScope]
+	return impl || scope || d.Instruction == InstructionFunc || d.Instruction == InstructionOff || d.Instruction == InstructionType ||
+		d.Instruction == InstructionStmt || d.Instruction == InstructionBranch
+}
+
+func position(line int, col int) int {
+	pos, _ := strconv.Atoi(fmt.Sprintf("%d%05d", line, col))
+	return pos
+}
+
+// Origin is where an instruction comes from, the line of its directive in
+// the file, or a description when it does not come from a directive
+type Origin struct {
+	Line        int
+	Description string
+	// Reason is the justification given with the directive
+	Reason string
+	// Group is the group of the directive, from its group option
+	Group string
+	// Options are the options of the directive, but the reason
+	Options map[string]string
 }
 
-type IgnoreFile struct{}
+// withOrigin returns the instruction with its origin set
+func withOrigin(instruction Instruction, origin Origin) Instruction {
+	switch ig := instruction.(type) {
+	case IgnoreBlock:
+		ig.Origin = origin
+		return ig
+	case IgnoreFile:
+		ig.Origin = origin
+		return ig
+	case IgnorePackage:
+		ig.Origin = origin
+		return ig
+	case IgnoreType:
+		ig.Origin = origin
+		return ig
+	case IgnoreRange:
+		ig.Origin = origin
+		return ig
+	}
+	return instruction
+}
+
+// source describes the origin for the report, path is the file of the
+// directive
+func (o Origin) source(path string) string {
+	if o.Line > 0 {
+		return fmt.Sprintf("%s:%d", path, o.Line)
+	}
+	return o.Description
+}
+
+func originOf(instruction Instruction) Origin {
+	switch ig := instruction.(type) {
+	case IgnoreBlock:
+		return ig.Origin
+	case IgnoreFile:
+		return ig.Origin
+	case IgnorePackage:
+		return ig.Origin
+	case IgnoreType:
+		return ig.Origin
+	case IgnoreRange:
+		return ig.Origin
+	}
+	return Origin{}
+}
 
-func (ig IgnoreFile) UpdateProfile(profile *cover.Profile, verbose bool) {
-	profile.Blocks = []cover.ProfileBlock{}
-	if verbose {
-		fmt.Printf("Removing all coverage blocks for %s\n", profile.FileName)
+type IgnoreBlock struct {
+	Line int
+	Col  int
+	// Soft blocks are reported as excluded but kept in the output profile
+	Soft bool
+	// Anchor is set when the targeted statement starts its block somewhere
+	// else depending on the Go version, see stmtAnchor
+	Anchor *IgnoreRange
+	Origin Origin
+}
+
+func (ig IgnoreBlock) Matches(block cover.ProfileBlock) bool {
+	igPos := position(ig.Line, ig.Col)
+	blockStart := position(block.StartLine, block.StartCol)
+	blockEnd := position(block.EndLine, block.EndCol)
+	if igPos >= blockStart && igPos < blockEnd {
+		return true
 	}
+	return ig.Anchor != nil && ig.Anchor.Matches(block)
+}
+
+type IgnoreFile struct {
+	// Line is the line of the directive
+	Line int
+	// Generator is the tool that generated the file, from the generated-by
+	// option
+	Generator string
+	Origin    Origin
+}
+
+func (ig IgnoreFile) Matches(block cover.ProfileBlock) bool {
+	return true
+}
+
+// IgnorePackage ignores all the blocks of the files of the package of File,
+// it is added to the other files of the package by packageIgnores
+type IgnorePackage struct {
+	// File is the path of the file of the directive
+	File string
+	// Line is the line of the directive
+	Line   int
+	Origin Origin
+}
+
+func (ig IgnorePackage) Matches(block cover.ProfileBlock) bool {
+	return true
+}
+
+// IgnoreType ignores the methods of Type declared in the other files of the
+// package of File, it is resolved for each of them by withTypeIgnores. The
+// methods of the file of the directive are ignored by ranges.
+type IgnoreType struct {
+	// File is the path of the file of the directive
+	File string
+	// Line is the line of the directive
+	Line   int
+	Type   string
+	Origin Origin
+}
+
+func (ig IgnoreType) Matches(block cover.ProfileBlock) bool {
+	return false
+}
+
+// Unignore keeps the blocks of a function body in a file or a package ignored
+// as a whole, the other instructions still apply to them
+type Unignore struct {
+	// Line is the line of the function declaration
+	Line int
+	Body IgnoreRange
+}
+
+func (ig Unignore) Matches(block cover.ProfileBlock) bool {
+	return ig.Body.Matches(block)
+}
+
+// IgnoreRange ignores all the blocks starting within the range, bounds included
+type IgnoreRange struct {
+	StartLine int
+	StartCol  int
+	EndLine   int
+	EndCol    int
+	// Soft ranges are reported as excluded but kept in the output profile
+	Soft   bool
+	Origin Origin
+}
+
+func (ig IgnoreRange) Matches(block cover.ProfileBlock) bool {
+	blockStart := position(block.StartLine, block.StartCol)
+	return blockStart >= position(ig.StartLine, ig.StartCol) && blockStart <= position(ig.EndLine, ig.EndCol)
 }
 
 func find(strs []string, str string) int {
@@ -74,49 +346,335 @@ func find(strs []string, str string) int {
 	return -1
 }
 
-func getInstructionFromLine(line string) (string, bool) {
-	if strings.Contains(line, "//coverage:ignore") || strings.Contains(line, "// coverage:ignore") {
-		re := regexp.MustCompile(`//\s?coverage:ignore(\s([a-z]+))?$`)
-		matches := re.FindStringSubmatch(line)
-		if len(matches) == 3 {
-			if matches[2] != "" {
-				return matches[2], true
+// DefaultDirectivePrefix starts the directives, other prefixes can be
+// configured for the code bases already using their own markers
+const DefaultDirectivePrefix = "coverage:ignore"
+
+var directivePrefixFormat = regexp.MustCompile(`^[a-z][a-z0-9:_-]*$`)
+
+// DirectiveSyntax recognizes the directives starting with one of its
+// prefixes, //coverage:ignore or an alias like //nocov
+type DirectiveSyntax struct {
+	prefixes []string
+	re       *regexp.Regexp
+}
+
+var defaultDirectiveSyntax, _ = newDirectiveSyntax(nil)
+
+func newDirectiveSyntax(aliases []string) (*DirectiveSyntax, error) {
+	prefixes := []string{DefaultDirectivePrefix}
+	for _, alias := range aliases {
+		if !directivePrefixFormat.MatchString(alias) {
+			return nil, fmt.Errorf("invalid directive prefix [%s], use lower case letters, digits, colons, dashes and underscores", alias)
+		}
+		if find(prefixes, alias) < 0 {
+			prefixes = append(prefixes, alias)
+		}
+	}
+	quoted := make([]string, len(prefixes))
+	for i, prefix := range prefixes {
+		quoted[i] = regexp.QuoteMeta(prefix)
+	}
+	return &DirectiveSyntax{
+		prefixes: prefixes,
+		re:       regexp.MustCompile(`//\s?(?:` + strings.Join(quoted, "|") + `)((?:\s[a-z0-9][a-z0-9-]*(?:="[^"]*"|=\S+)?)*)(?:\s+(?:—|--?)\s+(.*))?$`),
+	}, nil
+}
+
+// mayContain reports whether the content may contain directives, without
+// matching every line
+func (s *DirectiveSyntax) mayContain(content []byte) bool {
+	for _, prefix := range s.prefixes {
+		if bytes.Contains(content, []byte(prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *DirectiveSyntax) inLine(line string) bool {
+	for _, prefix := range s.prefixes {
+		if strings.Contains(line, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *DirectiveSyntax) parse(line string) (Directive, bool) {
+	if s.inLine(line) {
+		loc := s.re.FindStringSubmatchIndex(line)
+		if loc != nil {
+			//code before the directive, not a comment ending with a directive
+			before := strings.TrimSpace(line[:loc[0]])
+			directive := Directive{
+				Options: map[string]string{},
+				Inline:  before != "" && !strings.HasPrefix(before, "//"),
+			}
+			if loc[4] >= 0 {
+				directive.Reason = strings.TrimSpace(line[loc[4]:loc[5]])
+			}
+			keywords := []string{}
+			for _, arg := range directiveArgs(line[loc[2]:loc[3]]) {
+				if key, value, ok := strings.Cut(arg, "="); ok {
+					directive.Options[key] = strings.TrimSuffix(strings.TrimPrefix(value, `"`), `"`)
+				} else {
+					keywords = append(keywords, arg)
+				}
 			}
-			return DefaultInstruction, true
+			if reason, ok := directive.Options[OptionReason]; ok && directive.Reason == "" {
+				directive.Reason = reason
+			}
+			directive.Instruction = strings.Join(keywords, " ")
+			if directive.Instruction == "" {
+				directive.Instruction = DefaultInstruction
+			}
+			return directive, true
 		}
 	}
-	return "", false
+	return Directive{}, false
 }
 
-func readInstructionsFromSourceFile(path string) ([]Instruction, error) {
-	instructions := []Instruction{}
-	source, err := os.Open(path)
+// directiveArgs splits the instruction and the options of a directive on
+// spaces, but the spaces of the quoted option values
+func directiveArgs(s string) []string {
+	args := []string{}
+	arg := strings.Builder{}
+	quoted := false
+	for _, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			arg.WriteRune(r)
+		case unicode.IsSpace(r) && !quoted:
+			if arg.Len() > 0 {
+				args = append(args, arg.String())
+				arg.Reset()
+			}
+		default:
+			arg.WriteRune(r)
+		}
+	}
+	if arg.Len() > 0 {
+		args = append(args, arg.String())
+	}
+	return args
+}
+
+// nextLines returns the number of lines of a next instruction and true, the
+// number being -1 when it is not valid, or false for another instruction
+func nextLines(instruction string) (int, bool) {
+	if !strings.HasPrefix(instruction, InstructionNext+" ") {
+		return 0, false
+	}
+	lines, err := strconv.Atoi(strings.TrimPrefix(instruction, InstructionNext+" "))
+	if err != nil {
+		return -1, true
+	}
+	return lines, true
+}
+
+func readInstructionsFromSourceFile(path string, opts ScanOptions) ([]Instruction, error) {
+	content, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
-	defer source.Close()
-	scanner := bufio.NewScanner(source)
+	return readInstructionsFromSource(path, content, opts)
+}
+
+// readInstructionsFromSource reads the instructions of a file from its
+// content, which is not necessarily the content of the file on disk
+func readInstructionsFromSource(path string, content []byte, opts ScanOptions) ([]Instruction, error) {
+	instructions := []Instruction{}
+
+	//the syntax tree is needed to find the declarations targeted by directives
+	var src *sourceFile
+	var parseErr error
+	if opts.mayNeedSyntax(path, content) || (!opts.SkipDirectives && opts.syntax().mayContain(content)) {
+		src, parseErr = parseSource(path, content)
+		if parseErr != nil && opts.needsSyntax() {
+			return nil, parseErr
+		}
+	}
+	opts.Sources.add(path, content, src)
+	if opts.SkipDirectives {
+		return autoInstructions(path, content, src, opts), nil
+	}
+	docDirectives := map[int]int{}
+	if src != nil {
+		docDirectives = src.docCommentLines()
+	}
+	blockComments := map[int]string{}
+	if bytes.Contains(content, []byte("/*")) && opts.syntax().mayContain(content) {
+		blockComments = blockCommentLines(content)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
 	lineNumber := 1
-	pendingBlockInstruction := ""
+	var pendingDirective *Directive
+	fileDirectiveLine := 0
+	packageDirectiveLine := 0
+	regionStart := 0
+	var regionOrigin Origin
+	disabledRegion := false
+	declDirectives := []declDirective{}
+	targetLine := func(directive Directive, lineNumber int, lineTxt string) {
+		if directive.targetsDeclaration() {
+			//the directive targets the declaration, it is resolved with the AST below
+			declDirectives = append(declDirectives, declDirective{
+				Line:      lineNumber,
+				Directive: directive,
+			})
+			return
+		}
+		colStart := len(lineTxt) - len(strings.TrimLeft(lineTxt, "\t ")) + 1
+		ignoreBlock := IgnoreBlock{
+			Line:   lineNumber,
+			Col:    colStart,
+			Soft:   directive.Instruction == InstructionSoft,
+			Origin: directive.origin(),
+		}
+		if src != nil {
+			ignoreBlock.Anchor = src.stmtAnchor(lineNumber, colStart)
+		}
+		instructions = append(instructions, ignoreBlock)
+		if src != nil {
+			//the blocks of the closures of the statement are ignored with it
+			for _, closure := range src.closuresAt(lineNumber) {
+				closure.Soft = ignoreBlock.Soft
+				closure.Origin = ignoreBlock.Origin
+				instructions = append(instructions, closure)
+			}
+		}
+	}
 	for scanner.Scan() {
 		lineTxt := scanner.Text()
-		if instruction, ok := getInstructionFromLine(lineTxt); ok {
-			if instruction == InstructionFile {
-				instructions = append(instructions, IgnoreFile{})
-			} else if instruction == InstructionBlock {
-				pendingBlockInstruction = instruction
-			} else {
-				return nil, fmt.Errorf("Unexpected ignore instruction [%s] at line %d in file [%s]", instruction, lineNumber, path)
+		directive, ok := opts.syntax().parse(lineTxt)
+		if comment, found := blockComments[lineNumber]; found && !ok {
+			directive, ok = opts.syntax().parse(comment)
+		}
+		directive.Line = lineNumber
+		if ok && (opts.Groups.disables(directive) || !opts.conditionMet(directive) || !opts.platformMet(directive) || opts.flagsActive(directive)) {
+			//the directives of the disabled groups, of the conditions not met,
+			//of other platforms and of active feature flags are left out, like
+			//comments
+			if directive.Instruction == InstructionBegin {
+				disabledRegion = true
 			}
-		} else {
-			if pendingBlockInstruction != "" {
-				colStart := len(lineTxt) - len(strings.TrimLeft(lineTxt, "\t ")) + 1
-				instructions = append(instructions, IgnoreBlock{
-					Line: lineNumber,
-					Col: colStart,
+			lineNumber++
+			continue
+		}
+		if ok && directive.Inline && directive.targetsLine() {
+			//a directive at the end of a line of code targets that line
+			if pendingDirective != nil {
+				targetLine(*pendingDirective, lineNumber, lineTxt)
+				pendingDirective = nil
+			}
+			targetLine(directive, lineNumber, lineTxt)
+		} else if ok {
+			_, funcs := directive.Options[OptionFuncs]
+			if funcs && directive.Instruction == InstructionPackage {
+				return nil, fmt.Errorf("the %s option lists functions of the file, it cannot be given with the %s instruction, keep the functions measured with %s=, line %d in file [%s]",
+					OptionFuncs, directive.Instruction, OptionExcept, lineNumber, path)
+			}
+			if _, ok := directive.Options[OptionExcept]; ok && (directive.Instruction == InstructionFile || directive.Instruction == InstructionPackage) {
+				//the listed functions are unignored, they are resolved with the AST below
+				declDirectives = append(declDirectives, declDirective{
+					Line:      lineNumber,
+					Directive: directive,
+				})
+			}
+			if funcs && directive.Instruction == InstructionFile {
+				//file funcs= ignores the listed functions of the file only, like funcs= alone
+				declDirectives = append(declDirectives, declDirective{
+					Line:      lineNumber,
+					Directive: directive,
+				})
+			} else if directive.Instruction == InstructionFile {
+				if fileDirectiveLine > 0 {
+					opts.Warnings.add(fmt.Sprintf("%s:%d", path, lineNumber), "duplicate file directive, the file is already ignored by the first one")
+				} else {
+					fileDirectiveLine = lineNumber
+					instructions = append(instructions, IgnoreFile{
+						Line:      lineNumber,
+						Generator: directive.Options[OptionGeneratedBy],
+						Origin:    directive.origin(),
+					})
+				}
+			} else if directive.Instruction == InstructionPackage {
+				if packageDirectiveLine > 0 {
+					opts.Warnings.add(fmt.Sprintf("%s:%d", path, lineNumber), "duplicate package directive, the package is already ignored by the first one")
+				} else {
+					packageDirectiveLine = lineNumber
+					instructions = append(instructions, IgnorePackage{
+						File:   path,
+						Line:   lineNumber,
+						Origin: directive.origin(),
+					})
+				}
+			} else if lines, ok := nextLines(directive.Instruction); ok {
+				if lines <= 0 {
+					return nil, fmt.Errorf("invalid number of lines [%s] at line %d in file [%s]", directive.Instruction, lineNumber, path)
+				}
+				//the blocks starting in the next lines are ignored
+				instructions = append(instructions, IgnoreRange{
+					StartLine: lineNumber + 1,
+					StartCol:  0,
+					EndLine:   lineNumber + lines,
+					EndCol:    MaxCol,
+					Origin:    directive.origin(),
+				})
+			} else if directive.Instruction == InstructionBegin {
+				if regionStart > 0 {
+					return nil, fmt.Errorf("region begins at line %d in file [%s] inside the region beginning at line %d", lineNumber, path, regionStart)
+				}
+				regionStart = lineNumber
+				regionOrigin = directive.origin()
+			} else if directive.Instruction == InstructionEnd {
+				if regionStart == 0 && disabledRegion {
+					disabledRegion = false
+					lineNumber++
+					continue
+				}
+				if regionStart == 0 {
+					return nil, fmt.Errorf("region ends at line %d in file [%s] without beginning", lineNumber, path)
+				}
+				//the blocks starting between the two directives are ignored
+				instructions = append(instructions, IgnoreRange{
+					StartLine: regionStart,
+					StartCol:  0,
+					EndLine:   lineNumber,
+					EndCol:    MaxCol,
+					Origin:    regionOrigin,
 				})
-				pendingBlockInstruction = ""
+				regionStart = 0
+			} else if directive.Instruction == InstructionBlock || directive.Instruction == InstructionSoft || directive.Instruction == InstructionFunc ||
+				directive.Instruction == InstructionOff || directive.Instruction == InstructionType || directive.Instruction == InstructionStmt ||
+				directive.Instruction == InstructionBranch {
+				if _, ok := directive.Options[OptionFuncs]; ok {
+					//the directive lists functions of the file, wherever it is
+					declDirectives = append(declDirectives, declDirective{
+						Line:      lineNumber,
+						Directive: directive,
+					})
+				} else if declLine, ok := docDirectives[lineNumber]; ok {
+					//the directive is in the doc comment of a declaration, it targets the declaration
+					declDirectives = append(declDirectives, declDirective{
+						Line:      declLine,
+						Directive: directive,
+					})
+				} else {
+					if pendingDirective != nil {
+						opts.Warnings.add(fmt.Sprintf("%s:%d", path, lineNumber), "stacked directives, only the last one applies")
+					}
+					pendingDirective = &directive
+				}
+			} else {
+				return nil, fmt.Errorf("Unexpected ignore instruction [%s] at line %d in file [%s]", directive.Instruction, lineNumber, path)
 			}
+		} else if pendingDirective != nil {
+			targetLine(*pendingDirective, lineNumber, lineTxt)
+			pendingDirective = nil
 		}
 		lineNumber++
 	}
@@ -124,160 +682,1576 @@ func readInstructionsFromSourceFile(path string) ([]Instruction, error) {
 	if err := scanner.Err(); err != nil {
 		return []Instruction{}, err
 	}
+	if regionStart > 0 {
+		return nil, fmt.Errorf("region begins at line %d in file [%s] without end", regionStart, path)
+	}
+
+	if len(declDirectives) > 0 && src == nil {
+		return nil, parseErr
+	}
+	for _, directive := range declDirectives {
+		declInstructions, err := declInstructions(src, directive, opts.types())
+		if err != nil {
+			return nil, err
+		}
+		for _, instruction := range declInstructions {
+			instructions = append(instructions, withOrigin(instruction, directive.Directive.origin()))
+		}
+	}
+	instructions = append(instructions, autoInstructions(path, content, src, opts)...)
 
 	return instructions, nil
 }
 
-func readIgnoreCoverageFromSourceDir(root string) ([]IgnoreCoverage, error) {
-	ignores := []IgnoreCoverage{}
+// WalkOptions skips the directories of the root whose go files are never
+// measured, or not part of the module
+type WalkOptions struct {
+	// SkipVendor skips the vendor directory of the root
+	SkipVendor bool
+	// SkipTestdata skips the testdata directories, ignored by the go command
+	SkipTestdata bool
+	// SkipGitIgnored skips the files and the directories ignored by the
+	// .gitignore files
+	SkipGitIgnored bool
+}
+
+// sourceDirFiles returns the go files found under the root
+func sourceDirFiles(root string, opts WalkOptions) ([]string, error) {
+	files := []string{}
+	ignores := newGitIgnores(root)
 	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
-		if !info.IsDir() && strings.HasSuffix(info.Name(), ".go") {
-			instructions, err := readInstructionsFromSourceFile(path)
+		rel := relativePath(root, path)
+		if info.IsDir() && rel != "." {
+			if info.Name() == ".git" || (opts.SkipVendor && rel == "vendor") || (opts.SkipTestdata && info.Name() == "testdata") {
+				return filepath.SkipDir
+			}
+		} else if info.IsDir() || !strings.HasSuffix(info.Name(), ".go") {
+			return nil
+		}
+		if opts.SkipGitIgnored && rel != "." {
+			ignored, err := ignores.ignored(rel, info.IsDir())
 			if err != nil {
 				return err
 			}
-			if len(instructions) > 0 {
-				ignores = append(ignores, IgnoreCoverage{
-					Filepath:     path,
-					Instructions: instructions,
-				})
+			if ignored && info.IsDir() {
+				return filepath.SkipDir
+			}
+			if ignored {
+				return nil
 			}
 		}
+		if !info.IsDir() {
+			files = append(files, path)
+		}
 		return nil
 	})
 	if err != nil {
 		return nil, err
 	}
-	return ignores, nil
+	return files, nil
 }
 
-func resolveFile(file string) (string, error) {
-	dir, file := filepath.Split(file)
-	pkg, err := build.Import(dir, ".", build.FindOnly)
-	if err != nil {
-		return "", err
+func readIgnoreCoverageFromFiles(files []string, opts ScanOptions) ([]IgnoreCoverage, error) {
+	ignores := []IgnoreCoverage{}
+	for _, path := range files {
+		instructions, err := readInstructionsFromSourceFile(path, opts)
+		if err != nil {
+			return nil, err
+		}
+		if len(instructions) > 0 {
+			ignores = append(ignores, IgnoreCoverage{
+				Filepath:     path,
+				Instructions: instructions,
+			})
+		}
 	}
-	return filepath.Join(pkg.Dir, file), nil
+	return ignores, nil
 }
 
-func findIgnoreCoveragesByFile(ignoreCoverages []IgnoreCoverage, file string) (*IgnoreCoverage, bool) {
-	for _, ignore := range ignoreCoverages {
-		if ignore.Filepath == file {
-			return &ignore, true
+// moduleResolver resolves the files of the module at the root from the
+// module path of its go.mod, without build.Import. build.Import runs the go
+// command and depends on GOPATH and GOFLAGS, it is only used for the files of
+// the other modules. The modules replaced with a local directory in the
+// go.mod are resolved to the directory, not to the module cache.
+type moduleResolver struct {
+	root       string
+	modulePath string
+	// replaces are the local replacements, the longest module path first
+	replaces []localReplace
+	// vendored is set when the module has a vendor directory, the go command
+	// then builds the other modules from it
+	vendored bool
+	// searchRoots are looked up for the files not found otherwise
+	searchRoots []string
+}
+
+// localReplace is a module replaced with a directory by the go.mod
+type localReplace struct {
+	modulePath string
+	dir        string
+}
+
+func newModuleResolver(root string) *moduleResolver {
+	resolver := &moduleResolver{root: root}
+	data, err := os.ReadFile(filepath.Join(root, "go.mod"))
+	if err != nil {
+		return resolver
+	}
+	resolver.modulePath = modfile.ModulePath(data)
+	if _, err := os.Stat(filepath.Join(root, "vendor", "modules.txt")); err == nil {
+		resolver.vendored = true
+	}
+	mod, err := modfile.Parse("go.mod", data, nil)
+	if err != nil {
+		return resolver
+	}
+	for _, replace := range mod.Replace {
+		//a replacement without version is a directory
+		if replace.New.Version != "" {
+			continue
+		}
+		dir := filepath.FromSlash(replace.New.Path)
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(root, dir)
 		}
+		resolver.replaces = append(resolver.replaces, localReplace{modulePath: replace.Old.Path, dir: canonicalPath(dir)})
 	}
-	return nil, false
+	sort.SliceStable(resolver.replaces, func(i, j int) bool {
+		return len(resolver.replaces[i].modulePath) > len(resolver.replaces[j].modulePath)
+	})
+	return resolver
 }
 
-func updateProfileFromIgnoreCoverages(profile *cover.Profile, ignore *IgnoreCoverage, verbose bool) {
-	for _, instruction := range ignore.Instructions {
-		instruction.UpdateProfile(profile, verbose)
+// fileName returns the name of a file of the module in the coverage files,
+// its import path, or "" when the file is not in the module
+func (r *moduleResolver) fileName(path string) string {
+	rel := relativePath(r.root, path)
+	if r.modulePath == "" || filepath.IsAbs(filepath.FromSlash(rel)) {
+		return ""
 	}
+	return r.modulePath + "/" + rel
 }
 
-func writeProfiles(profiles []*cover.Profile, w io.Writer) {
-	w.Write([]byte(fmt.Sprintf("mode: %s\n", profiles[0].Mode)))
-	for _, profile := range profiles {
-		for _, block := range profile.Blocks {
-			w.Write([]byte(fmt.Sprintf("%s:%d.%d,%d.%d %d %d\n",
-				profile.FileName,
-				block.StartLine, block.StartCol,
-				block.EndLine, block.EndCol,
-				block.NumStmt, block.Count)))
+func (r *moduleResolver) resolve(file string) (string, error) {
+	if r.modulePath != "" && strings.HasPrefix(file, r.modulePath+"/") {
+		path := filepath.Join(r.root, filepath.FromSlash(strings.TrimPrefix(file, r.modulePath+"/")))
+		//a nested module may live somewhere else, let build.Import find it
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	if r.vendored && !filepath.IsAbs(file) {
+		path := filepath.Join(r.root, "vendor", filepath.FromSlash(file))
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	for _, replace := range r.replaces {
+		if strings.HasPrefix(file, replace.modulePath+"/") {
+			path := filepath.Join(replace.dir, filepath.FromSlash(strings.TrimPrefix(file, replace.modulePath+"/")))
+			if _, err := os.Stat(path); err == nil {
+				return path, nil
+			}
+		}
+	}
+	path, err := resolveFile(file)
+	if len(r.searchRoots) > 0 {
+		if _, statErr := os.Stat(path); err != nil || statErr != nil {
+			found, searchErr := r.searchFile(file)
+			if searchErr != nil {
+				return "", searchErr
+			}
+			if found != "" {
+				return found, nil
+			}
 		}
 	}
+	return path, err
 }
 
-func main() {
-	cli.VersionFlag = &cli.BoolFlag{
-		Name:    "print-version",
-		Aliases: []string{"V"},
-		Usage:   "print only the version",
+// searchFile looks up a file in the search roots by the end of its path,
+// the longest first, like m/gen/api.go then gen/api.go for
+// example.com/m/gen/api.go. The end includes the directory of the file, a
+// file name alone would match the files of any package, and a file found in
+// several search roots is an error. It returns an empty path when the file
+// is not found.
+func (r *moduleResolver) searchFile(file string) (string, error) {
+	parts := strings.Split(filepath.ToSlash(file), "/")
+	for i := 0; i < len(parts)-1; i++ {
+		found := []string{}
+		for _, root := range r.searchRoots {
+			path := filepath.Join(root, filepath.FromSlash(strings.Join(parts[i:], "/")))
+			if info, err := os.Stat(path); err == nil && !info.IsDir() {
+				found = append(found, path)
+			}
+		}
+		if len(found) > 1 {
+			return "", fmt.Errorf("%s is found in several search roots, as %s", file, strings.Join(found, " and "))
+		}
+		if len(found) == 1 {
+			return found[0], nil
+		}
 	}
+	return "", nil
+}
 
-	app := &cli.App{
-		Name:    "go-ignore-cov",
-		Version: "0.3.0",
-		Usage:   "Remove ignored code from codebase from a golang coverage output file",
-		Flags: []cli.Flag{
-			&cli.StringFlag{
-				Name:     "file",
-				Aliases:  []string{"f"},
-				Usage:    "input coverage file",
-				Required: true,
-			},
-			&cli.StringFlag{
-				Name:    "output",
-				Aliases: []string{"o"},
-				Usage:   "output coverage file",
-			},
-			&cli.StringFlag{
-				Name:    "root",
-				Aliases: []string{"r"},
-				Usage:   "module root",
-			},
-			&cli.BoolFlag{
-				Name:    "verbose",
-				Aliases: []string{"v"},
-				Usage:   "verbose output",
-			},
-		},
-		Action: func(c *cli.Context) error {
+// isVendored reports whether the file is in the vendor directory of the root
+func isVendored(file FilePath) bool {
+	return strings.HasPrefix(file.Rel, "vendor/")
+}
 
-			verbose := c.Bool("verbose")
+// isCmdPath reports whether a path relative to the root is under a cmd
+// directory, the conventional place of the commands of a module
+func isCmdPath(rel string) bool {
+	return strings.HasPrefix(rel, "cmd/") || strings.Contains(rel, "/cmd/")
+}
 
-			root := c.String("root")
-			if root == "" {
-				root, _ = os.Getwd()
-				if verbose {
-					fmt.Printf("Module root not defined, using %s working directory as root\n", root)
-				}
+// replacedDirs returns the package directories of the files of local
+// replacements outside of the root, they are not scanned with the root
+func (r *moduleResolver) replacedDirs(files []string) []string {
+	dirs := []string{}
+	for _, file := range files {
+		dir := filepath.Dir(file)
+		if rel := relativePath(r.root, dir); !filepath.IsAbs(filepath.FromSlash(rel)) || find(dirs, dir) >= 0 {
+			continue
+		}
+		for _, replace := range r.replaces {
+			if dir == replace.dir || strings.HasPrefix(dir, replace.dir+string(filepath.Separator)) {
+				dirs = append(dirs, dir)
+				break
 			}
+		}
+	}
+	return dirs
+}
 
-			ignoreCoverages, err := readIgnoreCoverageFromSourceDir(root)
-			if err != nil {
-				return err
-			}
+func resolveFile(file string) (string, error) {
+	if filepath.IsAbs(file) {
+		//files outside of a module or GOPATH are named by their path
+		return filepath.Clean(file), nil
+	}
+	dir, file := filepath.Split(file)
+	pkg, err := build.Import(dir, ".", build.FindOnly)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(filepath.Join(pkg.Dir, file))
+}
 
-			//scan code, find ignored lines
-			coverageFile := c.String("file")
-			profiles, err := cover.ParseProfiles(coverageFile)
-			if err != nil {
-				return err
-			}
+// MinFilesUnderRoot is the fraction of the coverage files expected to be
+// found under the root, below it the root is most likely wrong
+const MinFilesUnderRoot = 0.5
 
-			for _, profile := range profiles {
-				pgkPath := profile.FileName
-				file, err := resolveFile(pgkPath)
-				if err != nil {
-					return err
-				}
+// checkFilesUnderRoot warns when most of the files of the coverage file are
+// not under the root, as their instructions cannot be found. The output is
+// then left unchanged, which is easily mistaken for the tool not working.
+func checkFilesUnderRoot(files []string, root string) {
+	if len(files) == 0 {
+		return
+	}
+	underRoot := 0
+	canonicalRoot := canonicalPath(root)
+	for _, file := range files {
+		if strings.HasPrefix(file, root+string(filepath.Separator)) ||
+			strings.HasPrefix(canonicalPath(file), canonicalRoot+string(filepath.Separator)) {
+			underRoot++
+		}
+	}
+	if float64(underRoot) < float64(len(files))*MinFilesUnderRoot {
+		warn("only %d of the %d files of the coverage file are under the root %s, the ignore instructions of the other files cannot be found. "+
+			"Did you run from the right directory, or forget to set --root?", underRoot, len(files), root)
+	}
+}
 
-				if ignore, found := findIgnoreCoveragesByFile(ignoreCoverages, file); found {
-					updateProfileFromIgnoreCoverages(profile, ignore, verbose)
-				}
-			}
+// relativePath returns the slash separated path of file relative to root, or
+// file itself when it is not under root
+func relativePath(root string, file string) string {
+	for _, path := range []string{file, canonicalPath(file)} {
+		if rel, err := filepath.Rel(root, path); err == nil && !strings.HasPrefix(rel, "..") {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return filepath.ToSlash(file)
+}
 
-			output := c.String("output")
-			if output == "" {
-				output = coverageFile
-			}
-			outputFile, err := os.Create(output)
-			if err != nil {
-				return err
-			}
-			defer outputFile.Close()
+// canonicalPath returns the path with its symbolic links resolved, so a file
+// reached through a link or a bind mount has a single path
+func canonicalPath(path string) string {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved
+	}
+	return filepath.Clean(path)
+}
 
+// ignoreIndex finds the instructions of a file by its canonical path. Files
+// not found this way are compared with os.SameFile, which handles hard links
+// and case-insensitive filesystems.
+type ignoreIndex struct {
+	byPath     map[string]*IgnoreCoverage
+	byBaseName map[string][]*IgnoreCoverage
+}
+
+func newIgnoreIndex(ignoreCoverages []IgnoreCoverage) *ignoreIndex {
+	index := &ignoreIndex{
+		byPath:     map[string]*IgnoreCoverage{},
+		byBaseName: map[string][]*IgnoreCoverage{},
+	}
+	for i := range ignoreCoverages {
+		ignore := &ignoreCoverages[i]
+		index.byPath[canonicalPath(ignore.Filepath)] = ignore
+		baseName := strings.ToLower(filepath.Base(ignore.Filepath))
+		index.byBaseName[baseName] = append(index.byBaseName[baseName], ignore)
+	}
+	return index
+}
+
+func (index *ignoreIndex) find(file string) (*IgnoreCoverage, bool) {
+	if ignore, ok := index.byPath[canonicalPath(file)]; ok {
+		return ignore, true
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, false
+	}
+	for _, ignore := range index.byBaseName[strings.ToLower(filepath.Base(file))] {
+		if other, err := os.Stat(ignore.Filepath); err == nil && os.SameFile(info, other) {
+			return ignore, true
+		}
+	}
+	return nil, false
+}
+
+// generator returns the code generator of the file, empty when the file is
+// not ignored as generated code
+func (ig *IgnoreCoverage) generator() string {
+	for _, instruction := range ig.Instructions {
+		if ignoreFile, ok := instruction.(IgnoreFile); ok && ignoreFile.Generator != "" {
+			return ignoreFile.Generator
+		}
+	}
+	return ""
+}
+
+// packageIgnores returns the package directives by canonical package
+// directory. Their origin points at the file of the directive, as they apply
+// to the other files of the package.
+func packageIgnores(root string, ignoreCoverages []IgnoreCoverage) map[string]IgnorePackage {
+	packages := map[string]IgnorePackage{}
+	for _, ignore := range ignoreCoverages {
+		for _, instruction := range ignore.Instructions {
+			if pkg, ok := instruction.(IgnorePackage); ok {
+				pkg.Origin.Line = 0
+				pkg.Origin.Description = fmt.Sprintf("%s:%d", relativePath(root, pkg.File), pkg.Line)
+				packages[canonicalPath(filepath.Dir(pkg.File))] = pkg
+			}
+		}
+	}
+	return packages
+}
+
+// typeIgnores returns the type directives by canonical package directory.
+// Their origin points at the file of the directive, as they apply to the
+// other files of the package.
+func typeIgnores(root string, ignoreCoverages []IgnoreCoverage) map[string][]IgnoreType {
+	types := map[string][]IgnoreType{}
+	for _, ignore := range ignoreCoverages {
+		for _, instruction := range ignore.Instructions {
+			if typ, ok := instruction.(IgnoreType); ok {
+				typ.Origin.Line = 0
+				typ.Origin.Description = fmt.Sprintf("%s:%d", relativePath(root, typ.File), typ.Line)
+				dir := canonicalPath(filepath.Dir(typ.File))
+				types[dir] = append(types[dir], typ)
+			}
+		}
+	}
+	return types
+}
+
+// withTypeIgnores returns the instructions of a file with the bodies of the
+// methods of the ignored types of its package. The file of a type directive
+// already has them. The file is read from the sources, like its directives.
+func withTypeIgnores(ignore *IgnoreCoverage, file string, types []IgnoreType, sources *sourceCache) (*IgnoreCoverage, error) {
+	var src *sourceFile
+	for _, typ := range types {
+		if canonicalPath(typ.File) == canonicalPath(file) {
+			continue
+		}
+		if src == nil {
+			var err error
+			if src, err = sources.syntax(file); err != nil {
+				return nil, err
+			}
+		}
+		for _, method := range src.methods(typ.Type) {
+			if method.Body != nil {
+				ignore = withInstruction(ignore, file, withOrigin(src.bodyRange(method.Body), typ.Origin))
+			}
+		}
+	}
+	return ignore, nil
+}
+
+// withPackageIgnore returns the instructions of a file of an ignored package,
+// the instructions of the file apply first so soft directives stay soft. The
+// file of the package directive already has it.
+func withPackageIgnore(ignore *IgnoreCoverage, file string, pkg IgnorePackage) *IgnoreCoverage {
+	if ignore != nil {
+		for _, instruction := range ignore.Instructions {
+			if _, ok := instruction.(IgnorePackage); ok {
+				return ignore
+			}
+		}
+	}
+	return withInstruction(ignore, file, pkg)
+}
+
+// withInstruction returns a copy of the instructions of a file with one more
+// instruction, applying after the others
+func withInstruction(ignore *IgnoreCoverage, file string, instruction Instruction) *IgnoreCoverage {
+	if ignore == nil {
+		return &IgnoreCoverage{Filepath: file, Instructions: []Instruction{instruction}}
+	}
+	instructions := append([]Instruction{}, ignore.Instructions...)
+	return &IgnoreCoverage{Filepath: ignore.Filepath, Instructions: append(instructions, instruction)}
+}
+
+// updateProfileFromIgnoreCoverages removes the ignored blocks from the
+// profile and returns the exclusions, path is the source file path used to
+// point at the directive responsible for each of them
+func updateProfileFromIgnoreCoverages(profile *cover.Profile, ignore *IgnoreCoverage, path string, verbose bool) []Exclusion {
+	exclusions := []Exclusion{}
+	newBlocks := []cover.ProfileBlock{}
+	for _, block := range profile.Blocks {
+		instruction, matched := matchInstruction(ignore.Instructions, block)
+		if !matched {
+			newBlocks = append(newBlocks, block)
+			continue
+		}
+		soft := false
+		switch ig := instruction.(type) {
+		case IgnoreBlock:
+			soft = ig.Soft
+		case IgnoreRange:
+			soft = ig.Soft
+		}
+		origin := originOf(instruction)
+		exclusions = append(exclusions, newExclusion(block, soft, origin, path))
+		because := ""
+		if origin.Reason != "" {
+			because = fmt.Sprintf(" (%s)", origin.Reason)
+		}
+		if soft {
+			//soft ignores only count in the report, the block stays in the profile
+			if verbose {
+				fmt.Printf("Marking coverage block [%d.%d] => [%d.%d] as excluded for %s%s\n",
+					block.StartLine, block.StartCol, block.EndLine, block.EndCol, profile.FileName, because)
+			}
+			newBlocks = append(newBlocks, block)
+			continue
+		}
+		if verbose {
+			fmt.Printf("Removing coverage block [%d.%d] => [%d.%d] for %s%s\n",
+				block.StartLine, block.StartCol, block.EndLine, block.EndCol, profile.FileName, because)
+		}
+	}
+	profile.Blocks = newBlocks
+	return exclusions
+}
+
+// matchInstruction returns the instruction ignoring the block. The file and
+// package instructions apply last, and not to the blocks of the functions
+// unignored with an off directive.
+func matchInstruction(instructions []Instruction, block cover.ProfileBlock) (Instruction, bool) {
+	var whole Instruction
+	unignored := false
+	for _, instruction := range instructions {
+		if !instruction.Matches(block) {
+			continue
+		}
+		switch instruction.(type) {
+		case IgnoreFile, IgnorePackage:
+			if whole == nil {
+				whole = instruction
+			}
+		case Unignore:
+			unignored = true
+		default:
+			return instruction, true
+		}
+	}
+	if whole != nil && !unignored {
+		return whole, true
+	}
+	return nil, false
+}
+
+// mergeDuplicateProfiles merges the profiles of the same source file. This
+// happens with merged profiles referring to a file through different paths.
+// The first profile of a file is kept, with the blocks of the others.
+func mergeDuplicateProfiles(profiles []*cover.Profile, files []string, strict bool, warnings *warningSet) ([]*cover.Profile, []string, error) {
+	mergedProfiles := []*cover.Profile{}
+	mergedFiles := []string{}
+	byFile := map[string]*cover.Profile{}
+	for i, profile := range profiles {
+		first, found := byFile[files[i]]
+		if !found {
+			byFile[files[i]] = profile
+			mergedProfiles = append(mergedProfiles, profile)
+			mergedFiles = append(mergedFiles, files[i])
+			continue
+		}
+		if strict {
+			return nil, nil, fmt.Errorf("%s and %s in the coverage file both refer to %s", first.FileName, profile.FileName, files[i])
+		}
+		warnings.add(fmt.Sprintf("%s and %s", first.FileName, profile.FileName), "two paths of the coverage file refer to the same source file, merged under the first one")
+		blocks, err := mergeBlocks(first.Mode, append(first.Blocks, profile.Blocks...))
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot merge %s and %s: %w", first.FileName, profile.FileName, err)
+		}
+		first.Blocks = blocks
+	}
+	return mergedProfiles, mergedFiles, nil
+}
+
+// mergeBlocks sorts the blocks and merges the ones with the same position,
+// the same way go tool cover does
+func mergeBlocks(mode string, blocks []cover.ProfileBlock) ([]cover.ProfileBlock, error) {
+	sort.SliceStable(blocks, func(i, j int) bool {
+		bi, bj := blocks[i], blocks[j]
+		if bi.StartLine != bj.StartLine || bi.StartCol != bj.StartCol {
+			return position(bi.StartLine, bi.StartCol) < position(bj.StartLine, bj.StartCol)
+		}
+		return position(bi.EndLine, bi.EndCol) < position(bj.EndLine, bj.EndCol)
+	})
+	merged := []cover.ProfileBlock{}
+	for _, block := range blocks {
+		if len(merged) > 0 {
+			last := &merged[len(merged)-1]
+			if last.StartLine == block.StartLine && last.StartCol == block.StartCol &&
+				last.EndLine == block.EndLine && last.EndCol == block.EndCol {
+				if last.NumStmt != block.NumStmt {
+					return nil, fmt.Errorf("inconsistent NumStmt: changed from %d to %d", last.NumStmt, block.NumStmt)
+				}
+				if mode == "set" {
+					last.Count |= block.Count
+				} else {
+					last.Count += block.Count
+				}
+				continue
+			}
+		}
+		merged = append(merged, block)
+	}
+	return merged, nil
+}
+
+func warn(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, "Warning: "+format+"\n", a...)
+}
+
+// warningSet aggregates the warnings about files, a problem shared by
+// thousands of files is printed once with a sample and a count. A correction
+// has its own, so the corrections of a server do not mix their warnings.
+type warningSet struct {
+	mu      sync.Mutex
+	order   []string
+	counts  map[string]int
+	samples map[string]string
+}
+
+func newWarningSet() *warningSet {
+	return &warningSet{counts: map[string]int{}, samples: map[string]string{}}
+}
+
+// add records a warning about a file, printed by flush. The message does not
+// name the file, the sample does, so the warnings of the files sharing a
+// problem are identical. Without a set, the warning is printed right away.
+func (s *warningSet) add(sample string, format string, a ...interface{}) {
+	message := fmt.Sprintf(format, a...)
+	if s == nil {
+		warn("%s [%s]", message, sample)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.counts[message]; !ok {
+		s.order = append(s.order, message)
+		s.samples[message] = sample
+	}
+	s.counts[message]++
+}
+
+// flush prints the warnings recorded, in the order they were first seen, and
+// forgets them
+func (s *warningSet) flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, message := range s.order {
+		if count := s.counts[message]; count > 1 {
+			warn("%s [%s] and %d more", message, s.samples[message], count-1)
+		} else {
+			warn("%s [%s]", message, s.samples[message])
+		}
+	}
+	s.order = nil
+	s.counts = map[string]int{}
+	s.samples = map[string]string{}
+}
+
+// writeProfilesToFile writes the profiles to a file, synced to the disk when
+// requested so a crash right after does not leave a truncated file
+func writeProfilesToFile(profiles []*cover.Profile, path string, sync bool) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := profile.WriteProfiles(file, profiles, profile.WriteOptions{}); err != nil {
+		file.Close()
+		return err
+	}
+	if sync {
+		if err := file.Sync(); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	return file.Close()
+}
+
+// SplitProfileName is the name of the profile of each package written with
+// --split-output-by-package
+const SplitProfileName = "coverage.out"
+
+// writeProfilesByPackage writes a profile for each package, in a directory
+// of dir named after the import path of the package, or after its path
+// relative to the root when the profile has file paths
+func writeProfilesByPackage(profiles []*cover.Profile, files []string, root string, dir string, sync bool) (int, error) {
+	packages := map[string][]*cover.Profile{}
+	order := []string{}
+	for i, p := range profiles {
+		pkg := packageOf(p.FileName)
+		if filepath.IsAbs(p.FileName) {
+			pkg = filepath.ToSlash(filepath.Dir(relativePath(root, files[i])))
+		}
+		if _, ok := packages[pkg]; !ok {
+			order = append(order, pkg)
+		}
+		packages[pkg] = append(packages[pkg], p)
+	}
+	for _, pkg := range order {
+		pkgDir := filepath.Join(dir, filepath.FromSlash(pkg))
+		if err := os.MkdirAll(pkgDir, 0755); err != nil {
+			return 0, err
+		}
+		if err := writeProfilesToFile(packages[pkg], filepath.Join(pkgDir, SplitProfileName), sync); err != nil {
+			return 0, err
+		}
+	}
+	return len(order), nil
+}
+
+// outputPath returns the file where the corrected coverage is written. The
+// input file is only overwritten when explicitly requested.
+func outputPath(input string, output string, inPlace bool) (string, error) {
+	if inPlace {
+		if output != "" {
+			return "", fmt.Errorf("--output and --in-place cannot be used together")
+		}
+		return input, nil
+	}
+	if output == "" {
+		return "", fmt.Errorf("no output file, set --output, or --in-place to overwrite the input file")
+	}
+	if sameFile(input, output) {
+		return "", fmt.Errorf("the output file is the input file %s, use --in-place to overwrite it", input)
+	}
+	return output, nil
+}
+
+func sameFile(path1 string, path2 string) bool {
+	abs1, err1 := filepath.Abs(path1)
+	abs2, err2 := filepath.Abs(path2)
+	if err1 == nil && err2 == nil && abs1 == abs2 {
+		return true
+	}
+	info1, err1 := os.Stat(path1)
+	info2, err2 := os.Stat(path2)
+	return err1 == nil && err2 == nil && os.SameFile(info1, info2)
+}
+
+func copyFile(src string, dst string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, data, 0644)
+}
+
+// regexpList is the value of the flags of regular expressions. Unlike the
+// values of the slice flags, it is not split on the commas, they are part of
+// the syntax of the expressions, the flag is repeated instead.
+type regexpList []string
+
+func (l *regexpList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+func (l *regexpList) String() string {
+	return strings.Join(*l, " ")
+}
+
+// regexpFlag returns the regular expressions of a flag
+func regexpFlag(c *cli.Context, name string) []string {
+	if list, ok := c.Generic(name).(*regexpList); ok {
+		return *list
+	}
+	return nil
+}
+
+// correctionFlags are the flags of the commands working on the corrected
+// coverage
+func correctionFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    "file",
+			Aliases: []string{"f"},
+			Usage:   "input coverage file",
+		},
+		&cli.StringFlag{
+			Name:    "root",
+			Aliases: []string{"r"},
+			Usage:   "module root",
+		},
+		&cli.StringFlag{
+			Name:  "config",
+			Usage: "configuration file, " + DefaultConfigFile + " in the root by default",
+		},
+		&cli.BoolFlag{
+			Name:  "packages",
+			Usage: "scan the go files of the packages of the module instead of all the go files found under the root",
+		},
+		&cli.StringFlag{
+			Name:  "tags",
+			Usage: "comma separated list of build tags used to load the packages with --packages",
+		},
+		&cli.StringFlag{
+			Name:  "source-ref",
+			Usage: "read the go files from this git revision instead of the working tree",
+		},
+		&cli.StringSliceFlag{
+			Name:  "search-roots",
+			Usage: "scan these directories outside of the root for directives, and look up in them the files of the coverage file not found otherwise, like the files generated during the build",
+		},
+		&cli.BoolFlag{
+			Name:  "walk-vendor",
+			Usage: "scan the vendor directory for directives, by default only its files in the coverage file are scanned",
+		},
+		&cli.BoolFlag{
+			Name:  "walk-testdata",
+			Usage: "scan the testdata directories for directives, by default only their files in the coverage file are scanned",
+		},
+		&cli.BoolFlag{
+			Name:  "walk-gitignored",
+			Usage: "scan the files and directories ignored by the .gitignore files for directives, by default only the ignored files in the coverage file are scanned",
+		},
+		&cli.StringSliceFlag{
+			Name:  "exclude-lines",
+			Usage: "ignore the blocks starting in a line range of a file, like path/to/file.go:120-180, without a directive",
+		},
+		&cli.StringSliceFlag{
+			Name:  "disable-groups",
+			Usage: "leave out the directives of these groups, named with the group option, to measure their blocks again",
+		},
+		&cli.StringSliceFlag{
+			Name:  "conditions",
+			Usage: "conditions met by this run, the directives with an if option only apply when their condition is met",
+		},
+		&cli.StringSliceFlag{
+			Name:  "active-flags",
+			Usage: "feature flags enabled in this run, the directives with a flag option stop applying once their flags are active",
+		},
+		&cli.StringFlag{
+			Name:    "goos",
+			Usage:   "operating system the profile was produced on, for the directives with a goos option, the running one by default",
+			EnvVars: []string{"GOOS"},
+		},
+		&cli.StringFlag{
+			Name:    "goarch",
+			Usage:   "architecture the profile was produced on, for the directives with a goarch option, the running one by default",
+			EnvVars: []string{"GOARCH"},
+		},
+		&cli.StringSliceFlag{
+			Name:  "directive-prefix",
+			Usage: "recognize //<prefix> comments as directives too, like nocov for //nocov, on top of //coverage:ignore",
+		},
+		&cli.BoolFlag{
+			Name:  "exclude-vendor",
+			Usage: "ignore the files of the vendor directory, measured when -coverpkg includes vendored packages, but the measure_vendored patterns of the configuration",
+			Value: true,
+		},
+		&cli.BoolFlag{
+			Name:  "exclude-generated",
+			Usage: "ignore the files with the // Code generated ... DO NOT EDIT. header of the generated go files, without a directive",
+		},
+		&cli.BoolFlag{
+			Name:  "exclude-linguist-generated",
+			Usage: "ignore the files marked linguist-generated in the .gitattributes files, which GitHub hides in the diffs",
+		},
+		&cli.StringSliceFlag{
+			Name:  "preset",
+			Usage: "ignore the generated files of common generators: " + strings.Join(presetNames(), ", "),
+		},
+		&cli.BoolFlag{
+			Name:  "skip-cgo-exports",
+			Usage: "ignore the functions exported to C with an //export comment",
+		},
+		&cli.BoolFlag{
+			Name:  "ignore-panic-paths",
+			Usage: "ignore the blocks running straight into a panic, like the defensive checks of impossible states",
+		},
+		&cli.BoolFlag{
+			Name:  "ignore-fatal",
+			Usage: "ignore the blocks running straight into log.Fatal or os.Exit, which end the test process",
+		},
+		&cli.BoolFlag{
+			Name:  "ignore-err-returns",
+			Usage: "ignore the if err != nil { return err } branches propagating an error, without a directive",
+		},
+		&cli.BoolFlag{
+			Name:  "ignore-entrypoints",
+			Usage: "ignore the bodies of the main functions of the main packages and of the init functions",
+		},
+		&cli.BoolFlag{
+			Name:  "ignore-cmd-packages",
+			Usage: "ignore the files of the main packages under a cmd directory, exercised by end-to-end tests",
+		},
+		&cli.BoolFlag{
+			Name:  "ignore-recover-handlers",
+			Usage: "ignore the bodies of the deferred function literals calling recover, like defer func() { if r := recover(); r != nil { ... } }()",
+		},
+		&cli.BoolFlag{
+			Name:  "ignore-goroutine-bodies",
+			Usage: "ignore the bodies of the function literals launched with go, like go func() { ... }()",
+		},
+		&cli.StringSliceFlag{
+			Name:  "goroutine-body-paths",
+			Usage: "restrict --ignore-goroutine-bodies to the files matching these patterns, like internal/bootstrap/**",
+		},
+		&cli.IntFlag{
+			Name:  "ignore-small-funcs",
+			Usage: "ignore the bodies of the functions counting at most this number of statements in the profile, like the getters and the setters",
+		},
+		&cli.GenericFlag{
+			Name:  "ignore-stmt-regex",
+			Value: &regexpList{},
+			Usage: "ignore the blocks whose source text matches this regular expression, like prometheus\\.MustRegister",
+		},
+		&cli.GenericFlag{
+			Name:  "exclude-funcs",
+			Value: &regexpList{},
+			Usage: "ignore the bodies of the functions and methods whose name matches this regular expression, like ^Must or String$, methods are matched as Type.Method too",
+		},
+		&cli.StringSliceFlag{
+			Name:  "exclude-receivers",
+			Usage: "ignore the bodies of the methods of these types, like mockClient or *fakeStore, exclude_receivers of the configuration",
+		},
+		&cli.BoolFlag{
+			Name:  "exclude-stdmethods",
+			Usage: "ignore the String, GoString, Error, MarshalJSON, UnmarshalJSON, MarshalText and UnmarshalText methods with the signature of the standard interfaces",
+		},
+		&cli.BoolFlag{
+			Name:  "keep-examples",
+			Usage: "keep the testable Example functions of non-test files, ignored by default",
+		},
+		&cli.BoolFlag{
+			Name:  "require-reason",
+			Usage: "reject the directives without a reason, require_reason of the configuration",
+		},
+		&cli.StringFlag{
+			Name:  "reason-pattern",
+			Usage: "reject the directives whose reason does not match this regular expression, like JIRA-\\d+, reason_pattern of the configuration",
+		},
+		&cli.StringFlag{
+			Name:  "match-tolerance",
+			Usage: "let a block directive matching no block match the closest block starting within lines:n lines or cols:n columns, like lines:1, when a formatter or a generator moved the code",
+		},
+		&cli.BoolFlag{
+			Name:  "strict-duplicates",
+			Usage: "fail instead of merging when several profile entries refer to the same source file",
+		},
+		&cli.BoolFlag{
+			Name:    "verbose",
+			Aliases: []string{"v"},
+			Usage:   "verbose output",
+		},
+	}
+}
+
+// Correction is the coverage corrected by correctCoverage
+type Correction struct {
+	Root     string
+	Config   *Config
+	Profiles []*cover.Profile
+	// Files are the paths of the source files of the profiles
+	Files  []string
+	Report *Report
+	// Blocks are the blocks of each profile before the correction, and
+	// Ignores the instructions applied to them, nil when there is none
+	Blocks  [][]cover.ProfileBlock
+	Ignores []*IgnoreCoverage
+	// Violations are the directives rejected by the configuration
+	Violations []LintIssue
+	// Timer measures the phases of the run, up to the exclusion
+	Timer *phaseTimer
+	// Warnings are the warnings about the files, printed once the output of
+	// the correction is written
+	Warnings *warningSet
+	// Sources are the files scanned, as they are at the source ref with
+	// --source-ref
+	Sources *sourceCache
+}
+
+// correctCoverage parses the coverage file and applies the ignore
+// instructions found in the source code. The warnings about the files are
+// printed when it fails, the caller flushes the ones of the correction.
+func correctCoverage(c *cli.Context) (correction *Correction, err error) {
+	verbose := c.Bool("verbose")
+	warnings := newWarningSet()
+	defer func() {
+		if err != nil {
+			warnings.flush()
+		}
+	}()
+
+	root := c.String("root")
+	if root == "" {
+		root, _ = os.Getwd()
+		if verbose {
+			fmt.Printf("Module root not defined, using %s working directory as root\n", root)
+		}
+	}
+	root, err = filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	//a root reached through a symbolic link is walked from its target
+	root = canonicalPath(root)
+
+	config, err := loadConfig(c.String("config"), root)
+	if err != nil {
+		return nil, err
+	}
+
+	syntax, err := newDirectiveSyntax(append(config.DirectivePrefixes, c.StringSlice("directive-prefix")...))
+	if err != nil {
+		return nil, err
+	}
+	tolerance, err := parseMatchTolerance(c.String("match-tolerance"))
+	if err != nil {
+		return nil, err
+	}
+	groups := newGroups(c.StringSlice("disable-groups"))
+	conditions := map[string]bool{}
+	for _, condition := range c.StringSlice("conditions") {
+		conditions[condition] = true
+	}
+	activeFlags := map[string]bool{}
+	for _, flag := range c.StringSlice("active-flags") {
+		activeFlags[flag] = true
+	}
+	excludeFuncs := []*regexp.Regexp{}
+	for _, pattern := range regexpFlag(c, "exclude-funcs") {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --exclude-funcs: %w", err)
+		}
+		excludeFuncs = append(excludeFuncs, compiled)
+	}
+	excludeReceivers := map[string]string{}
+	for _, receiver := range config.ExcludeReceivers {
+		excludeReceivers[strings.TrimPrefix(receiver, "*")] = "exclude_receivers"
+	}
+	for _, receiver := range c.StringSlice("exclude-receivers") {
+		excludeReceivers[strings.TrimPrefix(receiver, "*")] = "--exclude-receivers"
+	}
+	selectedPresets, err := selectPresets(c.StringSlice("preset"))
+	if err != nil {
+		return nil, err
+	}
+	goroutinePaths, err := compilePathPatterns(c.StringSlice("goroutine-body-paths"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid --goroutine-body-paths: %w", err)
+	}
+	resolver := newModuleResolver(root)
+	//the scanned files are kept for the options reading them again, and with
+	//--source-ref, as the working tree may differ from the ref
+	keepSources := c.IsSet("ignore-small-funcs") || len(regexpFlag(c, "ignore-stmt-regex")) > 0 || c.String("source-ref") != ""
+	scanOpts := ScanOptions{
+		Syntax:                syntax,
+		Groups:                groups,
+		Types:                 newPackageTypes(),
+		Warnings:              warnings,
+		Conditions:            conditions,
+		ActiveFlags:           activeFlags,
+		GOOS:                  c.String("goos"),
+		GOARCH:                c.String("goarch"),
+		SkipCgoExports:        c.Bool("skip-cgo-exports"),
+		KeepExamples:          c.Bool("keep-examples"),
+		IgnorePanicPaths:      c.Bool("ignore-panic-paths"),
+		IgnoreFatal:           c.Bool("ignore-fatal"),
+		IgnoreErrReturns:      c.Bool("ignore-err-returns"),
+		IgnoreRecoverHandlers: c.Bool("ignore-recover-handlers"),
+		IgnoreEntrypoints:     c.Bool("ignore-entrypoints"),
+		ExcludeFuncs:          excludeFuncs,
+		ExcludeReceivers:      excludeReceivers,
+		ExcludeStdMethods:     c.Bool("exclude-stdmethods"),
+		ExcludeGenerated:      c.Bool("exclude-generated"),
+		Presets:               selectedPresets,
+		ExcludeDataFiles:      config.excludeDataFiles,
+		IgnoreCmdPackages:     c.Bool("ignore-cmd-packages"),
+		IgnoreGoroutineBodies: c.Bool("ignore-goroutine-bodies"),
+		GoroutinePaths:        goroutinePaths,
+		Resolver:              resolver,
+		Sources:               newSourceCache(keepSources),
+	}
+	timer := newPhaseTimer()
+	var ignoreCoverages []IgnoreCoverage
+	//walked are the files found walking the root, nil with --packages and
+	//--source-ref
+	var walked map[string]bool
+	if ref := c.String("source-ref"); ref != "" {
+		if c.Bool("packages") {
+			return nil, fmt.Errorf("--source-ref and --packages cannot be used together")
+		}
+		ignoreCoverages, err = readIgnoreCoverageFromRef(root, ref, scanOpts)
+	} else if c.Bool("packages") {
+		ignoreCoverages, err = readIgnoreCoverageFromPackages(root, c.String("tags"), scanOpts)
+	} else {
+		walkOpts := WalkOptions{
+			SkipVendor:     !c.Bool("walk-vendor"),
+			SkipTestdata:   !c.Bool("walk-testdata"),
+			SkipGitIgnored: !c.Bool("walk-gitignored"),
+		}
+		var sourceFiles []string
+		if sourceFiles, err = sourceDirFiles(root, walkOpts); err != nil {
+			return nil, err
+		}
+		timer.done("walk")
+		walked = map[string]bool{}
+		for _, file := range sourceFiles {
+			walked[file] = true
+		}
+		ignoreCoverages, err = readIgnoreCoverageFromFiles(sourceFiles, scanOpts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	excludedLines, err := parseExcludeLines(root, c.StringSlice("exclude-lines"))
+	if err != nil {
+		return nil, err
+	}
+	ignoreCoverages = mergeIgnoreCoverages(ignoreCoverages, excludedLines)
+	timer.done("scan")
+
+	//scan code, find ignored lines
+	coverageFile := c.String("file")
+	if coverageFile == "" {
+		return nil, fmt.Errorf("Required flag \"file\" not set")
+	}
+	profiles, err := cover.ParseProfiles(coverageFile)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]string, len(profiles))
+	for _, dir := range append(config.SearchRoots, c.StringSlice("search-roots")...) {
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(root, dir)
+		}
+		dir = canonicalPath(dir)
+		if rel := relativePath(root, dir); !filepath.IsAbs(filepath.FromSlash(rel)) {
+			warn("the search root %s is under the root, its files are already scanned", dir)
+			continue
+		}
+		sourceFiles, err := sourceDirFiles(dir, WalkOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("scanning the search root %s: %w", dir, err)
+		}
+		searchIgnores, err := readIgnoreCoverageFromFiles(sourceFiles, scanOpts)
+		if err != nil {
+			return nil, err
+		}
+		ignoreCoverages = append(ignoreCoverages, searchIgnores...)
+		resolver.searchRoots = append(resolver.searchRoots, dir)
+	}
+	for i, profile := range profiles {
+		pgkPath := profile.FileName
+		file, err := resolver.resolve(pgkPath)
+		if err != nil {
+			return nil, err
+		}
+		files[i] = file
+	}
+	for _, dir := range resolver.replacedDirs(files) {
+		replaced, err := filepath.Glob(filepath.Join(dir, "*.go"))
+		if err != nil {
+			return nil, err
+		}
+		replacedIgnores, err := readIgnoreCoverageFromFiles(replaced, scanOpts)
+		if err != nil {
+			return nil, err
+		}
+		ignoreCoverages = append(ignoreCoverages, replacedIgnores...)
+	}
+	if walked != nil {
+		//the files of the profile skipped by the walk, like the vendored files
+		//or the files generated in an ignored directory, are scanned anyway
+		skipped := []string{}
+		for _, file := range files {
+			if rel := relativePath(root, file); !filepath.IsAbs(filepath.FromSlash(rel)) && !walked[filepath.Join(root, filepath.FromSlash(rel))] {
+				skipped = append(skipped, file)
+				walked[filepath.Join(root, filepath.FromSlash(rel))] = true
+			}
+		}
+		skippedIgnores, err := readIgnoreCoverageFromFiles(skipped, scanOpts)
+		if err != nil {
+			return nil, err
+		}
+		ignoreCoverages = append(ignoreCoverages, skippedIgnores...)
+	}
+	//the options apply to the files of the profile no scan found, like the
+	//files of the other modules, but their directives are not read
+	scanned := newIgnoreIndex(ignoreCoverages)
+	unscanned := []string{}
+	for _, file := range files {
+		if _, found := scanned.find(file); found || scanOpts.Sources.scanned(file) || find(unscanned, file) >= 0 {
+			continue
+		}
+		if _, err := os.Stat(file); err == nil {
+			unscanned = append(unscanned, file)
+		}
+	}
+	optionOpts := scanOpts
+	optionOpts.SkipDirectives = true
+	optionIgnores, err := readIgnoreCoverageFromFiles(unscanned, optionOpts)
+	if err != nil {
+		return nil, err
+	}
+	ignoreCoverages = append(ignoreCoverages, optionIgnores...)
+	for _, group := range groups.unknownDisabled() {
+		warn("no directive belongs to the group [%s] of --disable-groups", group)
+	}
+
+	checkFilesUnderRoot(files, root)
+
+	profiles, files, err = mergeDuplicateProfiles(profiles, files, c.Bool("strict-duplicates"), warnings)
+	if err != nil {
+		return nil, err
+	}
+
+	filePaths := make([]FilePath, len(profiles))
+	for i, profile := range profiles {
+		filePaths[i] = newFilePath(relativePath(root, files[i]), files[i])
+		if !filepath.IsAbs(profile.FileName) {
+			filePaths[i].Import = profile.FileName
+		}
+	}
+	config.checkPatterns(filePaths)
+	var shard *Shard
+	if spec := c.String("shard"); spec != "" {
+		parsed, err := parseShard(spec)
+		if err != nil {
+			return nil, err
+		}
+		shard = &parsed
+		profiles, files, filePaths = filterShard(parsed, profiles, files, filePaths)
+	}
+
+	correction = &Correction{
+		Root:       root,
+		Config:     config,
+		Profiles:   profiles,
+		Files:      files,
+		Report:     &Report{},
+		Blocks:     make([][]cover.ProfileBlock, len(profiles)),
+		Ignores:    make([]*IgnoreCoverage, len(profiles)),
+		Violations: forbiddenFileIgnores(root, resolver, ignoreCoverages, config.forbidFileIgnores),
+		Timer:      timer,
+		Warnings:   warnings,
+		Sources:    scanOpts.Sources,
+	}
+	reasonPattern := config.reasonPattern
+	if pattern := c.String("reason-pattern"); pattern != "" {
+		if reasonPattern, err = regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("invalid --reason-pattern: %w", err)
+		}
+	}
+	if c.Bool("require-reason") || config.RequireReason || reasonPattern != nil {
+		correction.Violations = append(correction.Violations, unjustifiedDirectives(root, ignoreCoverages, reasonPattern)...)
+	}
+	if shard != nil {
+		correction.Report.Shard = shard.String()
+	}
+	timer.done("profile")
+	index := newIgnoreIndex(ignoreCoverages)
+	packages := packageIgnores(root, ignoreCoverages)
+	types := typeIgnores(root, ignoreCoverages)
+	timer.done("index")
+	excludeVendor := c.Bool("exclude-vendor")
+	stmtPatterns := []*regexp.Regexp{}
+	for _, pattern := range regexpFlag(c, "ignore-stmt-regex") {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --ignore-stmt-regex: %w", err)
+		}
+		stmtPatterns = append(stmtPatterns, compiled)
+	}
+	var attributes *gitAttributes
+	if c.Bool("exclude-linguist-generated") {
+		attributes = newGitAttributes(root)
+	}
+	smallFuncsMax := -1
+	if c.IsSet("ignore-small-funcs") {
+		if smallFuncsMax = c.Int("ignore-small-funcs"); smallFuncsMax < 0 {
+			return nil, fmt.Errorf("invalid --ignore-small-funcs %d, expected a number of statements", smallFuncsMax)
+		}
+	}
+	//the patterns are matched for all the files at once, in parallel
+	excludeMatches := config.exclude.MatchFiles(filePaths)
+	for i, profile := range profiles {
+		blocks := profile.Blocks
+		exclusions := []Exclusion{}
+		generator := ""
+		path := relativePath(root, files[i])
+		ignore, found := index.find(files[i])
+		if typeIgnores, ok := types[canonicalPath(filepath.Dir(files[i]))]; ok {
+			if ignore, err = withTypeIgnores(ignore, files[i], typeIgnores, scanOpts.Sources); err != nil {
+				return nil, err
+			}
+			found = ignore != nil
+		}
+		if pkg, ok := packages[canonicalPath(filepath.Dir(files[i]))]; ok {
+			ignore, found = withPackageIgnore(ignore, files[i], pkg), true
+		}
+		if pattern := excludeMatches[i]; pattern != nil {
+			excluded := IgnoreFile{Origin: Origin{Description: "exclude " + pattern.Source}}
+			ignore, found = withInstruction(ignore, files[i], excluded), true
+		}
+		if excludeVendor && isVendored(filePaths[i]) && !config.measureVendored.matches(filePaths[i]) {
+			excluded := IgnoreFile{Origin: Origin{Description: "--exclude-vendor"}}
+			ignore, found = withInstruction(ignore, files[i], excluded), true
+		}
+		if attributes != nil && !filepath.IsAbs(filepath.FromSlash(filePaths[i].Rel)) {
+			rule, generated, err := attributes.generated(filePaths[i].Rel)
+			if err != nil {
+				return nil, err
+			}
+			if generated {
+				excluded := IgnoreFile{Origin: Origin{Description: "linguist-generated " + rule.source}}
+				ignore, found = withInstruction(ignore, files[i], excluded), true
+			}
+		}
+		if smallFuncsMax >= 0 {
+			src, err := scanOpts.Sources.syntax(files[i])
+			if err != nil {
+				return nil, err
+			}
+			for _, instruction := range smallFuncs(src, blocks, smallFuncsMax) {
+				ignore, found = withInstruction(ignore, files[i], instruction), true
+			}
+		}
+		if len(stmtPatterns) > 0 {
+			content, err := scanOpts.Sources.content(files[i])
+			if err != nil {
+				return nil, err
+			}
+			for _, instruction := range stmtRegexBlocks(content, blocks, stmtPatterns) {
+				ignore, found = withInstruction(ignore, files[i], instruction), true
+			}
+		}
+		if found {
+			ignore = tolerance.apply(ignore, path, blocks, verbose)
+			exclusions = updateProfileFromIgnoreCoverages(profile, ignore, path, verbose)
+			generator = ignore.generator()
+			correction.Ignores[i] = ignore
+		}
+		correction.Blocks[i] = blocks
+		correction.Report.AddFile(profile.FileName, path, generator, blocks, exclusions)
+	}
+	timer.done("exclusion")
+	return correction, nil
+}
+
+func main() {
+	cli.VersionFlag = &cli.BoolFlag{
+		Name:    "print-version",
+		Aliases: []string{"V"},
+		Usage:   "print only the version",
+	}
+
+	app := &cli.App{
+		Name:    "go-ignore-cov",
+		Version: "0.3.0",
+		Usage:   "Remove ignored code from codebase from a golang coverage output file",
+		Flags: append(correctionFlags(),
+			&cli.StringFlag{
+				Name:    "output",
+				Aliases: []string{"o"},
+				Usage:   "output coverage file",
+			},
+			&cli.BoolFlag{
+				Name:  "in-place",
+				Usage: "overwrite the input coverage file, the original is saved with a .bak extension",
+			},
+			&cli.StringFlag{
+				Name:  "split-output-by-package",
+				Usage: "also write a profile for each package in this directory, as <import path>/" + SplitProfileName + ", for the build systems caching the coverage by package",
+			},
+			&cli.BoolFlag{
+				Name:  "fail-if-noop",
+				Usage: "fail when no block is excluded, as the directives or the patterns are probably not found",
+			},
+			&cli.BoolFlag{
+				Name:  "fsync",
+				Usage: "sync the output coverage file to the disk before exiting",
+			},
+			&cli.StringFlag{
+				Name:  "report",
+				Usage: "write a JSON coverage report, including excluded statements, to this file",
+			},
+			&cli.BoolFlag{
+				Name:  "report-functions",
+				Usage: "list in the report the functions fully covered, telling apart the tested ones from the ones covered thanks to exclusions",
+			},
+			&cli.BoolFlag{
+				Name:  "hide-excluded-files",
+				Usage: "leave out of --report-functions the functions of the files whose statements are all excluded",
+			},
+			&cli.BoolFlag{
+				Name:  "report-timings",
+				Usage: "list in the report the durations of the phases of the run: walk, scan, profile, index, exclusion and write",
+			},
+			&cli.BoolFlag{
+				Name:  "timestamp",
+				Usage: "embed the generation time in the report, SOURCE_DATE_EPOCH is used when set",
+			},
+			&cli.StringFlag{
+				Name:  "report-diff",
+				Usage: "print the exclusions added and removed since a previous JSON report",
+			},
+			&cli.StringFlag{
+				Name:  "editor-output",
+				Usage: "write the corrected coverage for the editors to this file, the excluded blocks are left out",
+			},
+			&cli.StringFlag{
+				Name:  "editor-format",
+				Usage: "format of --editor-output: " + strings.Join(editorFormats(), ", "),
+				Value: EditorFormatLcov,
+			},
+			&cli.StringFlag{
+				Name:  "gocov-output",
+				Usage: "write the corrected coverage to this file in the JSON of gocov, for gocov report, gocov-html or gocov-xml",
+			},
+			&cli.StringFlag{
+				Name:  "html-output",
+				Usage: "write the corrected coverage to this file as a page like the one of go tool cover -html, the excluded blocks greyed",
+			},
+			&cli.StringFlag{
+				Name:  "annotations",
+				Usage: "render the exclusions and the coverage as annotations for a platform: " + strings.Join(annotationFormats(), ", "),
+			},
+			&cli.StringFlag{
+				Name:  "annotations-template",
+				Usage: "render the annotations with this text/template file instead of a built-in format",
+			},
+			&cli.StringFlag{
+				Name:  "annotations-output",
+				Usage: "write the annotations to this file instead of the standard output",
+			},
+			&cli.BoolFlag{
+				Name:  "porcelain",
+				Usage: "print stable, tab separated records of the progress and the result, for editors",
+			},
+			&cli.StringFlag{
+				Name:  "shard",
+				Usage: "only correct and check the packages of a shard of the coverage file, like 3/8, see merge-reports",
+			},
+			&cli.StringFlag{
+				Name:  "webhook-url",
+				Usage: "post a JSON summary of the coverage, the exclusions and the thresholds result to this URL",
+			},
+		),
+		Commands: []*cli.Command{
+			ratchetCommand(),
+			uncoveredCommand(),
+			lintCommand(),
+			doctorCommand(),
+			suggestCommand(),
+			selftestCommand(),
+			testJSONCommand(),
+			signoffCommand(),
+			mergeReportsCommand(),
+			reconcileCommand(),
+			serveAPICommand(),
+			genFixturesCommand(),
+		},
+		Action: func(c *cli.Context) (err error) {
+			verbose := c.Bool("verbose")
+
+			//the porcelain records are the only output on stdout, the rest goes to stderr
+			var out io.Writer = os.Stdout
+			var porcelain *Porcelain
+			if c.Bool("porcelain") {
+				if verbose {
+					return fmt.Errorf("--porcelain and --verbose cannot be used together")
+				}
+				out = os.Stderr
+				porcelain = newPorcelain(os.Stdout)
+				defer func() {
+					porcelain.result(err)
+				}()
+			}
+
+			output, err := outputPath(c.String("file"), c.String("output"), c.Bool("in-place"))
+			if err != nil {
+				return err
+			}
+
+			porcelain.progress("correct")
+			correction, err := correctCoverage(c)
+			if err != nil {
+				return err
+			}
+			defer correction.Warnings.flush()
+			porcelain.record("root", correction.Root)
+			if len(correction.Violations) > 0 {
+				printLintIssues(os.Stderr, correction.Violations)
+				return fmt.Errorf("%d directive(s) rejected by the configuration", len(correction.Violations))
+			}
+			profiles, report := correction.Profiles, correction.Report
+			//counted before the report is written, the excluded files may be hidden from it
+			noop := report.exclusions() == 0
+
+			if c.Bool("in-place") {
+				backup := output + ".bak"
+				if verbose {
+					fmt.Printf("Saving the original coverage to %s ... \n", backup)
+				}
+				if err := copyFile(output, backup); err != nil {
+					return err
+				}
+			}
+			porcelain.progress("write")
 			if verbose {
 				fmt.Printf("Writing updated coverage to %s ... \n", output)
 			}
+			if err := writeProfilesToFile(profiles, output, c.Bool("fsync")); err != nil {
+				return err
+			}
+			if splitDir := c.String("split-output-by-package"); splitDir != "" {
+				count, err := writeProfilesByPackage(profiles, correction.Files, correction.Root, splitDir, c.Bool("fsync"))
+				if err != nil {
+					return err
+				}
+				if verbose {
+					fmt.Printf("Wrote the coverage of %d packages to %s\n", count, splitDir)
+				}
+			}
 
-			writeProfiles(profiles, outputFile)
+			correction.Timer.done("write")
 
-			return nil
+			if reportFile := c.String("report"); reportFile != "" {
+				if c.Bool("report-timings") {
+					report.Timings = correction.Timer.phases
+				}
+				if c.Bool("report-functions") {
+					if err := addFunctionReports(report, correction.Files, correction.Blocks, correction.Sources, c.Bool("hide-excluded-files")); err != nil {
+						return err
+					}
+				}
+				if verbose {
+					fmt.Printf("Writing coverage report to %s ... \n", reportFile)
+				}
+				if c.Bool("timestamp") {
+					if report.GeneratedAt, err = reportTimestamp(); err != nil {
+						return err
+					}
+				}
+				if err := writeReport(report, reportFile); err != nil {
+					return err
+				}
+			}
+
+			if editorOutput := c.String("editor-output"); editorOutput != "" {
+				if err := writeEditorCoverageFile(editorOutput, c.String("editor-format"), correction); err != nil {
+					return err
+				}
+			}
+
+			if gocovOutput := c.String("gocov-output"); gocovOutput != "" {
+				if err := writeGocovFile(gocovOutput, correction); err != nil {
+					return err
+				}
+			}
+
+			if htmlOutput := c.String("html-output"); htmlOutput != "" {
+				if err := writeHTMLFile(htmlOutput, correction); err != nil {
+					return err
+				}
+			}
+
+			if previousFile := c.String("report-diff"); previousFile != "" {
+				previous, err := readReport(previousFile)
+				if err != nil {
+					return err
+				}
+				printReportDiff(out, previousFile, previous, report)
+			}
+
+			if c.String("annotations") != "" || c.String("annotations-template") != "" {
+				w := out
+				if annotationsFile := c.String("annotations-output"); annotationsFile != "" {
+					f, err := os.Create(annotationsFile)
+					if err != nil {
+						return err
+					}
+					defer f.Close()
+					w = f
+				}
+				if err := writeAnnotations(report, c.String("annotations"), c.String("annotations-template"), w); err != nil {
+					return err
+				}
+			}
+
+			if noop {
+				fmt.Fprintf(out, "No exclusions found, the output has the blocks of the input (%d files, %d statements)\n", len(report.Files), report.Total.Statements)
+				if c.Bool("fail-if-noop") {
+					return fmt.Errorf("no block excluded with --fail-if-noop, check the root, the directives and the patterns of the configuration")
+				}
+			}
+
+			porcelain.progress("check")
+			if err := checkExpectations(report, correction.Config); err != nil {
+				return err
+			}
+			thresholdsErr := checkThresholds(out, report, correction.Config, time.Now())
+			if url := c.String("webhook-url"); url != "" {
+				if verbose {
+					fmt.Printf("Posting the summary to %s ... \n", url)
+				}
+				if err := postWebhook(url, newWebhookEvent(report, thresholdsErr)); err != nil {
+					if thresholdsErr == nil {
+						return err
+					}
+					warn("%v", err)
+				}
+			}
+			porcelain.report(report)
+			return thresholdsErr
 		},
 	}
 
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"testing"
+
+	"github.com/urfave/cli/v2"
+	"golang.org/x/tools/cover"
+)
+
+func TestMergeDuplicateProfiles(t *testing.T) {
+	tests := []struct {
+		name   string
+		mode   string
+		second []cover.ProfileBlock
+		merged []cover.ProfileBlock
+		err    string
+	}{
+		{
+			name:   "count",
+			mode:   "count",
+			second: []cover.ProfileBlock{{StartLine: 3, StartCol: 2, EndLine: 4, EndCol: 1, NumStmt: 1, Count: 2}, {StartLine: 7, StartCol: 2, EndLine: 8, EndCol: 1, NumStmt: 1, Count: 1}},
+			merged: []cover.ProfileBlock{{StartLine: 3, StartCol: 2, EndLine: 4, EndCol: 1, NumStmt: 1, Count: 3}, {StartLine: 7, StartCol: 2, EndLine: 8, EndCol: 1, NumStmt: 1, Count: 1}},
+		},
+		{
+			name:   "set",
+			mode:   "set",
+			second: []cover.ProfileBlock{{StartLine: 3, StartCol: 2, EndLine: 4, EndCol: 1, NumStmt: 1, Count: 1}},
+			merged: []cover.ProfileBlock{{StartLine: 3, StartCol: 2, EndLine: 4, EndCol: 1, NumStmt: 1, Count: 1}},
+		},
+		{
+			name:   "inconsistent statements",
+			mode:   "count",
+			second: []cover.ProfileBlock{{StartLine: 3, StartCol: 2, EndLine: 4, EndCol: 1, NumStmt: 2, Count: 1}},
+			err:    "inconsistent NumStmt",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profiles := []*cover.Profile{
+				{FileName: "example.com/a/a.go", Mode: tt.mode, Blocks: []cover.ProfileBlock{{StartLine: 3, StartCol: 2, EndLine: 4, EndCol: 1, NumStmt: 1, Count: 1}}},
+				{FileName: "example.com/b/b.go", Mode: tt.mode, Blocks: []cover.ProfileBlock{{StartLine: 5, StartCol: 2, EndLine: 6, EndCol: 1, NumStmt: 1, Count: 0}}},
+				{FileName: "/src/a/a.go", Mode: tt.mode, Blocks: tt.second},
+			}
+			files := []string{"/src/a/a.go", "/src/b/b.go", "/src/a/a.go"}
+			merged, mergedFiles, err := mergeDuplicateProfiles(profiles, files, false, nil)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(merged) != 2 || strings.Join(mergedFiles, " ") != "/src/a/a.go /src/b/b.go" {
+				t.Fatalf("expected the profiles of a.go and b.go, got %d profiles of %v", len(merged), mergedFiles)
+			}
+			if merged[0].FileName != "example.com/a/a.go" {
+				t.Errorf("expected the first name of a.go to be kept, got %s", merged[0].FileName)
+			}
+			if len(merged[0].Blocks) != len(tt.merged) {
+				t.Fatalf("expected the blocks %v, got %v", tt.merged, merged[0].Blocks)
+			}
+			for i := range tt.merged {
+				if merged[0].Blocks[i] != tt.merged[i] {
+					t.Errorf("expected the blocks %v, got %v", tt.merged, merged[0].Blocks)
+				}
+			}
+		})
+	}
+}
+
+func TestMergeDuplicateProfilesStrict(t *testing.T) {
+	profiles := []*cover.Profile{
+		{FileName: "example.com/a/a.go", Mode: "set"},
+		{FileName: "/src/a/a.go", Mode: "set"},
+	}
+	_, _, err := mergeDuplicateProfiles(profiles, []string{"/src/a/a.go", "/src/a/a.go"}, true, nil)
+	if err == nil || !strings.Contains(err.Error(), "both refer to /src/a/a.go") {
+		t.Fatalf("expected an error about the duplicate, got %v", err)
+	}
+}
+
+func TestRegexpFlag(t *testing.T) {
+	set := flag.NewFlagSet("go-ignore-cov", flag.ContinueOnError)
+	for _, f := range correctionFlags() {
+		if err := f.Apply(set); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//the commas are part of the expressions, they do not separate them
+	args := []string{"--ignore-stmt-regex", "a{1,3}", "--ignore-stmt-regex", "b,c", "--exclude-funcs", "^Get[A-Z]{1,3}$", "--exclude-funcs", "^Must", "--exclude-receivers", "mockA,mockB"}
+	if err := set.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	c := cli.NewContext(&cli.App{}, set, nil)
+	if got := regexpFlag(c, "ignore-stmt-regex"); strings.Join(got, " ") != "a{1,3} b,c" {
+		t.Errorf("expected the patterns a{1,3} and b,c, got %q", got)
+	}
+	if got := regexpFlag(c, "exclude-funcs"); strings.Join(got, " ") != "^Get[A-Z]{1,3}$ ^Must" {
+		t.Errorf("expected the patterns ^Get[A-Z]{1,3}$ and ^Must, got %q", got)
+	}
+	if got := c.StringSlice("exclude-receivers"); len(got) != 2 {
+		t.Errorf("expected the receivers to be split on the commas, got %q", got)
+	}
+	if got := regexpFlag(cli.NewContext(&cli.App{}, flag.NewFlagSet("empty", flag.ContinueOnError), nil), "ignore-stmt-regex"); got != nil {
+		t.Errorf("expected no pattern without the flag, got %q", got)
+	}
+}
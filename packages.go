@@ -0,0 +1,42 @@
+//coverage:ignore file
+package main
+
+import (
+	"fmt"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// readIgnoreCoverageFromPackages reads the instructions of the go files
+// belonging to the packages found under root, as the go command sees them.
+// Unlike the directory walk, files excluded by build constraints, files of
+// nested modules and stray go files outside of any package are not scanned.
+func readIgnoreCoverageFromPackages(root string, tags string, opts ScanOptions) ([]IgnoreCoverage, error) {
+	cfg := &packages.Config{
+		Mode: packages.NeedName | packages.NeedFiles,
+		Dir:  root,
+	}
+	if tags != "" {
+		cfg.BuildFlags = []string{"-tags=" + tags}
+	}
+	pkgs, err := packages.Load(cfg, "./...")
+	if err != nil {
+		return nil, err
+	}
+	files := []string{}
+	seen := map[string]bool{}
+	for _, pkg := range pkgs {
+		for _, pkgErr := range pkg.Errors {
+			if pkgErr.Kind == packages.ListError {
+				return nil, fmt.Errorf("cannot load package %s: %v", pkg.PkgPath, pkgErr)
+			}
+		}
+		for _, file := range pkg.GoFiles {
+			if !seen[file] {
+				seen[file] = true
+				files = append(files, file)
+			}
+		}
+	}
+	return readIgnoreCoverageFromFiles(files, opts)
+}
@@ -0,0 +1,206 @@
+//coverage:ignore file
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// PathPattern matches file paths. It is a glob where * and ? do not match
+// the path separator and ** matches any number of directories, or a regular
+// expression when prefixed with re:
+//
+// Without prefix, a pattern matches the path relative to the root or the
+// absolute path. Prefixed with path:, it only matches the path relative to the
+// root, and prefixed with import: it matches the import path of the file, like
+// github.com/org/module/internal/*.go.
+type PathPattern struct {
+	Source string
+	kind   patternKind
+	re     *regexp.Regexp
+	// literal is set for the globs without wildcard, compared as strings
+	literal string
+}
+
+type patternKind int
+
+const (
+	anyPath patternKind = iota
+	relativeToRoot
+	importPath
+)
+
+func compilePathPattern(pattern string) (PathPattern, error) {
+	compiled := PathPattern{Source: pattern}
+	expr := pattern
+	if strings.HasPrefix(expr, "path:") {
+		compiled.kind, expr = relativeToRoot, strings.TrimPrefix(expr, "path:")
+	} else if strings.HasPrefix(expr, "import:") {
+		compiled.kind, expr = importPath, strings.TrimPrefix(expr, "import:")
+	}
+	if expr == "" {
+		return PathPattern{}, fmt.Errorf("invalid pattern [%s]: empty", pattern)
+	}
+	if strings.HasPrefix(expr, "re:") {
+		expr = strings.TrimPrefix(expr, "re:")
+	} else if !strings.ContainsAny(expr, "*?") {
+		compiled.literal = expr
+		return compiled, nil
+	} else {
+		expr = globToRegexp(expr)
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return PathPattern{}, fmt.Errorf("invalid pattern [%s]: %w", pattern, err)
+	}
+	compiled.re = re
+	return compiled, nil
+}
+
+func globToRegexp(glob string) string {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; {
+		case strings.HasPrefix(glob[i:], "**/"):
+			sb.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			sb.WriteString(".*")
+			i++
+		case c == '*':
+			sb.WriteString("[^/]*")
+		case c == '?':
+			sb.WriteString("[^/]")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+	return sb.String()
+}
+
+// matchesFile matches the paths of the file the kind of the pattern applies to
+func (p PathPattern) matchesFile(file FilePath) bool {
+	switch p.kind {
+	case relativeToRoot:
+		return p.Matches(file.Rel)
+	case importPath:
+		return file.Import != "" && p.Matches(file.Import)
+	}
+	return p.Matches(file.Rel) || (file.Abs != file.Rel && p.Matches(file.Abs))
+}
+
+func (p PathPattern) Matches(path string) bool {
+	if p.re == nil {
+		return path == p.literal
+	}
+	return p.re.MatchString(path)
+}
+
+// PathPatterns is a list of patterns, matching a path when any of them does
+type PathPatterns []PathPattern
+
+func compilePathPatterns(patterns []string) (PathPatterns, error) {
+	compiled := PathPatterns{}
+	for _, pattern := range patterns {
+		p, err := compilePathPattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, p)
+	}
+	return compiled, nil
+}
+
+// FilePath is a file path normalized once for matching, relative to the
+// root and absolute, with forward slashes. Import is the import path of the
+// file when it is known.
+type FilePath struct {
+	Rel    string
+	Abs    string
+	Import string
+}
+
+func newFilePath(rel string, abs string) FilePath {
+	return FilePath{Rel: filepath.ToSlash(rel), Abs: filepath.ToSlash(abs)}
+}
+
+// MatchesFile reports whether a pattern matches the file, either by its path
+// relative to the root or by its absolute path
+func (ps PathPatterns) MatchesFile(rel string, abs string) bool {
+	return ps.matches(newFilePath(rel, abs))
+}
+
+func (ps PathPatterns) matches(file FilePath) bool {
+	_, ok := ps.matching(file)
+	return ok
+}
+
+// matching returns the first pattern matching the file
+func (ps PathPatterns) matching(file FilePath) (PathPattern, bool) {
+	for _, p := range ps {
+		if p.matchesFile(file) {
+			return p, true
+		}
+	}
+	return PathPattern{}, false
+}
+
+// unmatched describes the patterns matching none of the files of the coverage
+// file. A pattern without prefix is often written for the import paths, the
+// import: prefix is suggested when it matches them.
+func (ps PathPatterns) unmatched(files []FilePath) []string {
+	problems := []string{}
+	for _, p := range ps {
+		matched, asImport := false, false
+		for _, file := range files {
+			if p.matchesFile(file) {
+				matched = true
+				break
+			}
+			asImport = asImport || (p.kind == anyPath && file.Import != "" && p.Matches(file.Import))
+		}
+		if matched {
+			continue
+		}
+		problem := fmt.Sprintf("pattern [%s] matches none of the files of the coverage file", p.Source)
+		if asImport {
+			problem += fmt.Sprintf(", it matches import paths, write it import:%s", p.Source)
+		}
+		problems = append(problems, problem)
+	}
+	return problems
+}
+
+// MatchFiles matches many files at once, spread over the CPUs, and returns
+// the first pattern matching each file, nil when none does. The compiled
+// patterns are safe for concurrent use.
+func (ps PathPatterns) MatchFiles(files []FilePath) []*PathPattern {
+	matches := make([]*PathPattern, len(files))
+	if len(ps) == 0 {
+		return matches
+	}
+	workers := runtime.NumCPU()
+	if workers > len(files) {
+		workers = len(files)
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < len(files); i += workers {
+				if p, ok := ps.matching(files[i]); ok {
+					matches[i] = &p
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	return matches
+}
@@ -0,0 +1,71 @@
+//coverage:ignore file
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// PorcelainVersion is the version of the porcelain format, increased on any
+// incompatible change of the records
+const PorcelainVersion = 1
+
+// Porcelain writes the progress and the result of the command as stable,
+// line oriented records for editors. A record is a line of tab separated
+// fields, starting with the kind of the record. The tabs and line breaks of
+// the text fields are replaced with spaces. A nil Porcelain writes nothing.
+type Porcelain struct {
+	w io.Writer
+}
+
+func newPorcelain(w io.Writer) *Porcelain {
+	p := &Porcelain{w: w}
+	p.record("version", PorcelainVersion)
+	return p
+}
+
+func (p *Porcelain) record(kind string, fields ...interface{}) {
+	if p == nil {
+		return
+	}
+	values := []string{kind}
+	for _, field := range fields {
+		values = append(values, porcelainField(fmt.Sprint(field)))
+	}
+	fmt.Fprintln(p.w, strings.Join(values, "\t"))
+}
+
+func porcelainField(value string) string {
+	return strings.NewReplacer("\t", " ", "\r\n", " ", "\n", " ", "\r", " ").Replace(value)
+}
+
+// progress reports the phase the command is in
+func (p *Porcelain) progress(phase string) {
+	p.record("progress", phase)
+}
+
+// report writes a record for every file, with the exclusions of the file
+// after it, and the total
+func (p *Porcelain) report(report *Report) {
+	for _, file := range report.Files {
+		p.record("file", file.Path, file.Statements, file.Covered, file.Excluded, fmt.Sprintf("%.1f", file.Coverage))
+		for _, e := range file.Exclusions {
+			kind := "hard"
+			if e.Soft {
+				kind = "soft"
+			}
+			p.record("exclusion", file.Path, e.StartLine, e.StartCol, e.EndLine, e.EndCol, e.Statements, kind, e.Source, e.Reason)
+		}
+	}
+	p.record("total", report.Total.Statements, report.Total.Covered, report.Total.Excluded, fmt.Sprintf("%.1f", report.Total.Coverage))
+}
+
+// result reports the outcome of the command, the last record
+func (p *Porcelain) result(err error) {
+	if err != nil {
+		p.record("result", "failed", err.Error())
+		return
+	}
+	p.record("result", "ok")
+}
@@ -0,0 +1,79 @@
+//coverage:ignore file
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Preset recognizes the files of a common generator, to exclude them without
+// listing patterns in every repository
+type Preset struct {
+	Name string
+	// Patterns are the globs of the base names of the files, matched when the
+	// file has the header of the generated files
+	Patterns []string
+	// Generators are the generators named by the header, matched whatever the
+	// name of the file
+	Generators []string
+}
+
+var presets = []Preset{
+	{Name: "mocks", Patterns: []string{"mock_*.go", "*_mock.go", "mocks.go", "*_mocks.go"}, Generators: []string{"MockGen", "mockery", "moq", "counterfeiter"}},
+	{Name: "protobuf", Patterns: []string{"*.pb.go", "*.pb.gw.go", "*_grpc.pb.go"}, Generators: []string{"protoc-gen-go", "protoc-gen-go-grpc", "protoc-gen-grpc-gateway"}},
+	{Name: "wire", Patterns: []string{"wire_gen.go"}, Generators: []string{"Wire"}},
+	{Name: "stringer", Patterns: []string{"*_string.go"}, Generators: []string{"stringer"}},
+}
+
+func presetNames() []string {
+	names := []string{}
+	for _, p := range presets {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+// selectPresets returns the presets with the names, given once or separated
+// with commas
+func selectPresets(names []string) ([]Preset, error) {
+	selected := []Preset{}
+	for _, name := range names {
+		found := false
+		for _, p := range presets {
+			if p.Name == strings.TrimSpace(name) {
+				selected, found = append(selected, p), true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown preset [%s], expected one of %v", name, presetNames())
+		}
+	}
+	return selected, nil
+}
+
+// matches reports whether a generated file, with the generator of its header,
+// is one of the files of the preset
+func (p Preset) matches(path string, generator string) bool {
+	if find(p.Generators, generator) >= 0 {
+		return true
+	}
+	for _, pattern := range p.Patterns {
+		if matched, _ := filepath.Match(pattern, filepath.Base(path)); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// matchingPreset returns the first of the presets recognizing a generated
+// file, with the generator of its header. The files without the header, like
+// the mocks written by hand, are measured.
+func matchingPreset(selected []Preset, path string, generator string) (Preset, bool) {
+	for _, p := range selected {
+		if p.matches(path, generator) {
+			return p, true
+		}
+	}
+	return Preset{}, false
+}
@@ -0,0 +1,92 @@
+// Package profile writes coverage profiles, like the ones corrected by
+// go-ignore-cov, so they can be embedded in other artifact streams.
+package profile
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"golang.org/x/tools/cover"
+)
+
+// WriteOptions changes how the profiles are written. The zero value writes
+// them as they are.
+type WriteOptions struct {
+	// Sort writes the profiles by file name and their blocks by position
+	Sort bool
+	// Mode replaces the mode of the profiles when set. With the set mode,
+	// the counts are written as 0 or 1.
+	Mode string
+	// Summary receives a line with the total coverage of the profiles when
+	// set, with Precision decimals
+	Summary   io.Writer
+	Precision int
+}
+
+// WriteProfiles writes the profiles in the format of go test -coverprofile.
+// The writes are buffered, the first error is returned.
+func WriteProfiles(w io.Writer, profiles []*cover.Profile, opts WriteOptions) error {
+	mode := opts.Mode
+	if mode == "" {
+		if len(profiles) == 0 {
+			return errors.New("no profile to take the mode from, set the mode option")
+		}
+		mode = profiles[0].Mode
+	}
+	if opts.Sort {
+		profiles = sortedProfiles(profiles)
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "mode: %s\n", mode)
+	statements, covered := 0, 0
+	for _, profile := range profiles {
+		for _, block := range profile.Blocks {
+			count := block.Count
+			if mode == "set" && count > 1 {
+				count = 1
+			}
+			fmt.Fprintf(bw, "%s:%d.%d,%d.%d %d %d\n",
+				profile.FileName,
+				block.StartLine, block.StartCol,
+				block.EndLine, block.EndCol,
+				block.NumStmt, count)
+			statements += block.NumStmt
+			if count > 0 {
+				covered += block.NumStmt
+			}
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	if opts.Summary != nil {
+		percent := 100.0
+		if statements > 0 {
+			percent = float64(covered) * 100 / float64(statements)
+		}
+		_, err := fmt.Fprintf(opts.Summary, "total: %.*f%% of %d statements covered\n", opts.Precision, percent, statements)
+		return err
+	}
+	return nil
+}
+
+// sortedProfiles returns a sorted copy of the profiles, they are left as is
+func sortedProfiles(profiles []*cover.Profile) []*cover.Profile {
+	sorted := make([]*cover.Profile, len(profiles))
+	for i, profile := range profiles {
+		copied := *profile
+		copied.Blocks = append([]cover.ProfileBlock(nil), profile.Blocks...)
+		sort.SliceStable(copied.Blocks, func(i, j int) bool {
+			a, b := copied.Blocks[i], copied.Blocks[j]
+			return a.StartLine < b.StartLine || (a.StartLine == b.StartLine && a.StartCol < b.StartCol)
+		})
+		sorted[i] = &copied
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].FileName < sorted[j].FileName
+	})
+	return sorted
+}
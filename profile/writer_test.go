@@ -0,0 +1,93 @@
+package profile_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/quantumcycle/go-ignore-cov/profile"
+	"golang.org/x/tools/cover"
+)
+
+func testProfiles() []*cover.Profile {
+	return []*cover.Profile{
+		{
+			FileName: "example.com/b/b.go",
+			Mode:     "count",
+			Blocks: []cover.ProfileBlock{
+				{StartLine: 7, StartCol: 2, EndLine: 8, EndCol: 3, NumStmt: 1, Count: 0},
+				{StartLine: 3, StartCol: 2, EndLine: 5, EndCol: 3, NumStmt: 2, Count: 4},
+			},
+		},
+		{
+			FileName: "example.com/a/a.go",
+			Mode:     "count",
+			Blocks: []cover.ProfileBlock{
+				{StartLine: 3, StartCol: 2, EndLine: 4, EndCol: 10, NumStmt: 1, Count: 1},
+			},
+		},
+	}
+}
+
+func TestWriteProfiles(t *testing.T) {
+	var out bytes.Buffer
+	if err := profile.WriteProfiles(&out, testProfiles(), profile.WriteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	expected := `mode: count
+example.com/b/b.go:7.2,8.3 1 0
+example.com/b/b.go:3.2,5.3 2 4
+example.com/a/a.go:3.2,4.10 1 1
+`
+	if out.String() != expected {
+		t.Errorf("unexpected output:\n%s", out.String())
+	}
+}
+
+func TestWriteProfilesWithOptions(t *testing.T) {
+	var out, summary bytes.Buffer
+	profiles := testProfiles()
+	opts := profile.WriteOptions{Sort: true, Mode: "set", Summary: &summary, Precision: 2}
+	if err := profile.WriteProfiles(&out, profiles, opts); err != nil {
+		t.Fatal(err)
+	}
+	expected := `mode: set
+example.com/a/a.go:3.2,4.10 1 1
+example.com/b/b.go:3.2,5.3 2 1
+example.com/b/b.go:7.2,8.3 1 0
+`
+	if out.String() != expected {
+		t.Errorf("unexpected output:\n%s", out.String())
+	}
+	if summary.String() != "total: 75.00% of 4 statements covered\n" {
+		t.Errorf("unexpected summary: %s", summary.String())
+	}
+	if profiles[0].FileName != "example.com/b/b.go" || profiles[0].Blocks[0].StartLine != 7 {
+		t.Errorf("the profiles were modified")
+	}
+}
+
+func TestWriteProfilesWithoutMode(t *testing.T) {
+	var out bytes.Buffer
+	if err := profile.WriteProfiles(&out, nil, profile.WriteOptions{}); err == nil {
+		t.Errorf("expected an error without profiles nor mode")
+	}
+	var summary bytes.Buffer
+	if err := profile.WriteProfiles(&out, nil, profile.WriteOptions{Mode: "set", Summary: &summary}); err != nil {
+		t.Fatal(err)
+	}
+	if summary.String() != "total: 100% of 0 statements covered\n" {
+		t.Errorf("unexpected summary: %s", summary.String())
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, bytes.ErrTooLarge
+}
+
+func TestWriteProfilesError(t *testing.T) {
+	if err := profile.WriteProfiles(failingWriter{}, testProfiles(), profile.WriteOptions{}); err != bytes.ErrTooLarge {
+		t.Errorf("expected the write error, got %v", err)
+	}
+}
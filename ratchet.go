@@ -0,0 +1,84 @@
+//coverage:ignore file
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+const DefaultFloorFile = ".coverage-floor"
+
+func ratchetCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "ratchet",
+		Usage: "fail if the coverage, once corrected, drops below the floor stored in the floor file",
+		Flags: append(correctionFlags(),
+			&cli.StringFlag{
+				Name:  "floor-file",
+				Usage: "file storing the coverage floor",
+				Value: DefaultFloorFile,
+			},
+			&cli.BoolFlag{
+				Name:  "update",
+				Usage: "raise the floor to the current coverage when it improved, creating the floor file if needed",
+			},
+		),
+		Action: func(c *cli.Context) error {
+			correction, err := correctCoverage(c)
+			if err != nil {
+				return err
+			}
+			defer correction.Warnings.flush()
+			return ratchet(correction.Report.Total.Coverage, c.String("floor-file"), c.Bool("update"))
+		},
+	}
+}
+
+// ratchet compares the coverage with the floor stored in floorFile. The floor
+// has one decimal and is always rounded down, so a floor written from a
+// coverage value is never above it.
+func ratchet(coverage float64, floorFile string, update bool) error {
+	current := math.Floor(coverage*10) / 10
+	floor, err := readFloor(floorFile)
+	if errors.Is(err, os.ErrNotExist) && update {
+		fmt.Printf("Creating %s with a coverage floor of %.1f%%\n", floorFile, current)
+		return writeFloor(floorFile, current)
+	}
+	if err != nil {
+		return err
+	}
+	if coverage < floor {
+		return fmt.Errorf("coverage %.1f%% is below the floor of %.1f%% stored in %s", coverage, floor, floorFile)
+	}
+	if current > floor && update {
+		fmt.Printf("Coverage improved, raising the floor from %.1f%% to %.1f%% in %s\n", floor, current, floorFile)
+		return writeFloor(floorFile, current)
+	}
+	fmt.Printf("Coverage %.1f%% meets the floor of %.1f%%\n", coverage, floor)
+	return nil
+}
+
+func readFloor(floorFile string) (float64, error) {
+	data, err := os.ReadFile(floorFile)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, fmt.Errorf("floor file %s not found, run with --update to create it: %w", floorFile, err)
+		}
+		return 0, err
+	}
+	floor, err := strconv.ParseFloat(strings.TrimSpace(string(data)), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid coverage floor in %s: %w", floorFile, err)
+	}
+	return floor, nil
+}
+
+func writeFloor(floorFile string, floor float64) error {
+	return os.WriteFile(floorFile, []byte(strconv.FormatFloat(floor, 'f', 1, 64)+"\n"), 0644)
+}
@@ -0,0 +1,236 @@
+//coverage:ignore file
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"golang.org/x/tools/cover"
+)
+
+func reconcileCommand() *cli.Command {
+	flags := []cli.Flag{}
+	for _, f := range correctionFlags() {
+		//the input profile is the reconciled one
+		if f.Names()[0] != "file" {
+			flags = append(flags, f)
+		}
+	}
+	return &cli.Command{
+		Name:      "reconcile",
+		Usage:     "merge the partial profiles of sharded test runs, add the files of the packages they miss as not covered, and correct the result",
+		ArgsUsage: "partial.out...",
+		Flags: append(flags,
+			&cli.StringFlag{
+				Name:     "output",
+				Aliases:  []string{"o"},
+				Usage:    "output coverage file",
+				Required: true,
+			},
+			&cli.StringSliceFlag{
+				Name:  "package",
+				Usage: "package of the full run, an import path or a pattern like ./..., can be repeated. Its files are found with go test -run '^$', which runs no test but runs the init and TestMain functions, and at least one package needs a test file",
+			},
+			&cli.StringFlag{
+				Name:  "package-list",
+				Usage: "file listing the packages of the full run, one per line, like the output of go list ./...",
+			},
+			&cli.StringFlag{
+				Name:  "report",
+				Usage: "write a JSON coverage report, including excluded statements, to this file",
+			},
+		),
+		Action: func(c *cli.Context) error {
+			if c.NArg() == 0 {
+				return fmt.Errorf("no profile to reconcile, pass the partial profiles as arguments")
+			}
+			packages, err := reconcilePackages(c.StringSlice("package"), c.String("package-list"))
+			if err != nil {
+				return err
+			}
+			if len(packages) == 0 {
+				return fmt.Errorf("no package, set the packages of the full run with --package or --package-list")
+			}
+			partials := [][]*cover.Profile{}
+			for _, file := range c.Args().Slice() {
+				profiles, err := cover.ParseProfiles(file)
+				if err != nil {
+					return fmt.Errorf("reading %s: %w", file, err)
+				}
+				partials = append(partials, profiles)
+			}
+			merged, err := mergePartialProfiles(partials)
+			if err != nil {
+				return err
+			}
+			mode := "set"
+			if len(merged) > 0 {
+				mode = merged[0].Mode
+			}
+
+			root := c.String("root")
+			if root == "" {
+				root = "."
+			}
+			root, err = filepath.Abs(root)
+			if err != nil {
+				return err
+			}
+			tmp, err := os.MkdirTemp("", "go-ignore-cov-reconcile")
+			if err != nil {
+				return err
+			}
+			defer os.RemoveAll(tmp)
+			baseline, err := baselineProfiles(root, mode, packages, filepath.Join(tmp, "baseline.out"))
+			if err != nil {
+				return err
+			}
+			reconciled, filled, err := fillMissingProfiles(merged, baseline)
+			if err != nil {
+				return err
+			}
+			reconciledFile := filepath.Join(tmp, "reconciled.out")
+			if err := writeProfilesToFile(reconciled, reconciledFile, false); err != nil {
+				return err
+			}
+
+			//the reconciled profile is corrected like the input of the default command
+			set := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+			set.String("file", reconciledFile, "")
+			correction, err := correctCoverage(cli.NewContext(c.App, set, c))
+			if err != nil {
+				return err
+			}
+			defer correction.Warnings.flush()
+			if len(correction.Violations) > 0 {
+				printLintIssues(os.Stderr, correction.Violations)
+				return fmt.Errorf("%d directive(s) rejected by the configuration", len(correction.Violations))
+			}
+			if err := writeProfilesToFile(correction.Profiles, c.String("output"), false); err != nil {
+				return err
+			}
+			report := correction.Report
+			if reportFile := c.String("report"); reportFile != "" {
+				if err := writeReport(report, reportFile); err != nil {
+					return err
+				}
+			}
+			fmt.Printf("Reconciled %d profile(s), %d files, %d added as not covered, total coverage %.1f%%\n", len(partials), len(reconciled), filled, report.Total.Coverage)
+			if err := checkExpectations(report, correction.Config); err != nil {
+				return err
+			}
+			return checkThresholds(os.Stdout, report, correction.Config, time.Now())
+		},
+	}
+}
+
+// reconcilePackages returns the packages of the flag and of the file, the
+// empty lines and the comments of the file are skipped
+func reconcilePackages(packages []string, packageList string) ([]string, error) {
+	all := append([]string{}, packages...)
+	if packageList == "" {
+		return all, nil
+	}
+	f, err := os.Open(packageList)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			all = append(all, line)
+		}
+	}
+	return all, scanner.Err()
+}
+
+// mergePartialProfiles merges the profiles of the shards, the blocks of a
+// file covered by several shards are merged like go tool cover does. The
+// shards must use the same cover mode.
+func mergePartialProfiles(partials [][]*cover.Profile) ([]*cover.Profile, error) {
+	merged := []*cover.Profile{}
+	byFile := map[string]*cover.Profile{}
+	for _, profiles := range partials {
+		for _, p := range profiles {
+			if len(merged) > 0 && p.Mode != merged[0].Mode {
+				return nil, fmt.Errorf("the partial profiles use different cover modes, %s and %s", merged[0].Mode, p.Mode)
+			}
+			first, found := byFile[p.FileName]
+			if !found {
+				byFile[p.FileName] = p
+				merged = append(merged, p)
+				continue
+			}
+			blocks, err := mergeBlocks(first.Mode, append(first.Blocks, p.Blocks...))
+			if err != nil {
+				return nil, fmt.Errorf("cannot merge the blocks of %s: %w", p.FileName, err)
+			}
+			first.Blocks = blocks
+		}
+	}
+	return merged, nil
+}
+
+// baselineProfiles runs the tests of the packages without running any test,
+// so every block of the packages is in the profile, not covered. The init
+// and TestMain functions of the test binaries still run, and a package needs
+// a test file to be in the profile before Go 1.22.
+func baselineProfiles(root string, mode string, packages []string, file string) ([]*cover.Profile, error) {
+	args := []string{"test", "-run", "^$", "-covermode", mode, "-coverpkg", strings.Join(packages, ","), "-coverprofile", file}
+	if _, err := runIn(root, "go", append(args, packages...)...); err != nil {
+		return nil, err
+	}
+	baseline, err := cover.ParseProfiles(file)
+	if err != nil {
+		return nil, err
+	}
+	if len(baseline) == 0 {
+		return nil, fmt.Errorf("the packages have no statement, or none of them has a test file to build the baseline from, add a test file to one of them")
+	}
+	return baseline, nil
+}
+
+// fillMissingProfiles adds the blocks of the baseline to the merged profiles,
+// not covered, and returns the profiles sorted by file along with the count
+// of the files missing from the shards. The blocks the shards have keep
+// their count.
+func fillMissingProfiles(merged []*cover.Profile, baseline []*cover.Profile) ([]*cover.Profile, int, error) {
+	byFile := map[string]*cover.Profile{}
+	for _, p := range merged {
+		byFile[p.FileName] = p
+	}
+	filled := 0
+	for _, b := range baseline {
+		for i := range b.Blocks {
+			b.Blocks[i].Count = 0
+		}
+		p, found := byFile[b.FileName]
+		if !found {
+			if len(merged) > 0 {
+				b.Mode = merged[0].Mode
+			}
+			byFile[b.FileName] = b
+			merged = append(merged, b)
+			filled++
+			continue
+		}
+		blocks, err := mergeBlocks(p.Mode, append(p.Blocks, b.Blocks...))
+		if err != nil {
+			return nil, 0, fmt.Errorf("the blocks of %s differ from the ones of the full run, the shards may come from another revision: %w", b.FileName, err)
+		}
+		p.Blocks = blocks
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].FileName < merged[j].FileName
+	})
+	return merged, filled, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/cover"
+)
+
+func TestMergePartialProfiles(t *testing.T) {
+	block := func(line int, count int) cover.ProfileBlock {
+		return cover.ProfileBlock{StartLine: line, StartCol: 2, EndLine: line + 1, EndCol: 1, NumStmt: 1, Count: count}
+	}
+	shard1 := []*cover.Profile{
+		{FileName: "example.com/a/a.go", Mode: "count", Blocks: []cover.ProfileBlock{block(3, 1), block(7, 0)}},
+	}
+	shard2 := []*cover.Profile{
+		{FileName: "example.com/b/b.go", Mode: "count", Blocks: []cover.ProfileBlock{block(3, 4)}},
+		{FileName: "example.com/a/a.go", Mode: "count", Blocks: []cover.ProfileBlock{block(3, 2), block(7, 1)}},
+	}
+	merged, err := mergePartialProfiles([][]*cover.Profile{shard1, shard2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(merged) != 2 || merged[0].FileName != "example.com/a/a.go" || merged[1].FileName != "example.com/b/b.go" {
+		t.Fatalf("expected the profiles of a.go and b.go, got %v", merged)
+	}
+	if blocks := merged[0].Blocks; len(blocks) != 2 || blocks[0].Count != 3 || blocks[1].Count != 1 {
+		t.Errorf("expected the counts of the shards to be added, got %v", blocks)
+	}
+
+	shard3 := []*cover.Profile{{FileName: "example.com/c/c.go", Mode: "set", Blocks: []cover.ProfileBlock{block(3, 1)}}}
+	if _, err := mergePartialProfiles([][]*cover.Profile{shard2, shard3}); err == nil || !strings.Contains(err.Error(), "different cover modes") {
+		t.Errorf("expected an error about the cover modes, got %v", err)
+	}
+	shard4 := []*cover.Profile{{FileName: "example.com/b/b.go", Mode: "count", Blocks: []cover.ProfileBlock{{StartLine: 3, StartCol: 2, EndLine: 4, EndCol: 1, NumStmt: 2}}}}
+	if _, err := mergePartialProfiles([][]*cover.Profile{shard2, shard4}); err == nil || !strings.Contains(err.Error(), "cannot merge the blocks of example.com/b/b.go") {
+		t.Errorf("expected an error about the blocks of b.go, got %v", err)
+	}
+}
+
+func TestFillMissingProfiles(t *testing.T) {
+	block := func(line int, count int) cover.ProfileBlock {
+		return cover.ProfileBlock{StartLine: line, StartCol: 2, EndLine: line + 1, EndCol: 1, NumStmt: 1, Count: count}
+	}
+	merged := []*cover.Profile{
+		{FileName: "example.com/b/b.go", Mode: "atomic", Blocks: []cover.ProfileBlock{block(3, 2)}},
+	}
+	//the init functions of the baseline run, their counts are reset
+	baseline := []*cover.Profile{
+		{FileName: "example.com/a/a.go", Mode: "set", Blocks: []cover.ProfileBlock{block(3, 1), block(7, 0)}},
+		{FileName: "example.com/b/b.go", Mode: "set", Blocks: []cover.ProfileBlock{block(3, 1), block(9, 1)}},
+	}
+	reconciled, filled, err := fillMissingProfiles(merged, baseline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filled != 1 || len(reconciled) != 2 {
+		t.Fatalf("expected a.go to be added, got %d added to %d profiles", filled, len(reconciled))
+	}
+	a, b := reconciled[0], reconciled[1]
+	if a.FileName != "example.com/a/a.go" || a.Mode != "atomic" || len(a.Blocks) != 2 || a.Blocks[0].Count != 0 {
+		t.Errorf("expected a.go to be added not covered with the mode of the shards, got %+v", a)
+	}
+	if len(b.Blocks) != 2 || b.Blocks[0].Count != 2 || b.Blocks[1].Count != 0 {
+		t.Errorf("expected the block of b.go missing from the shards to be added not covered, got %v", b.Blocks)
+	}
+
+	drifted := []*cover.Profile{
+		{FileName: "example.com/b/b.go", Mode: "set", Blocks: []cover.ProfileBlock{{StartLine: 3, StartCol: 2, EndLine: 4, EndCol: 1, NumStmt: 3}}},
+	}
+	if _, _, err := fillMissingProfiles(drifted, baseline[1:]); err == nil || !strings.Contains(err.Error(), "may come from another revision") {
+		t.Errorf("expected an error about the revision of the shards, got %v", err)
+	}
+}
+
+func TestReconcilePackages(t *testing.T) {
+	list := filepath.Join(t.TempDir(), "packages.txt")
+	if err := os.WriteFile(list, []byte("# the packages of the full run\nexample.com/a\n\n  example.com/b  \n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	packages, err := reconcilePackages([]string{"./cmd/..."}, list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(packages, " ") != "./cmd/... example.com/a example.com/b" {
+		t.Errorf("expected the packages of the flag and of the file, got %v", packages)
+	}
+}
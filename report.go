@@ -0,0 +1,418 @@
+//coverage:ignore file
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	"golang.org/x/tools/cover"
+)
+
+// Report summarizes the coverage of a profile once the ignore instructions
+// have been applied. Excluded statements are not part of the coverage
+// percentage, whether they were removed from the output profile or not.
+//
+// The report only depends on its inputs, the same coverage file and sources
+// always give the same report, byte for byte. Files and exclusions keep the
+// order of the coverage file, sorted by file name and position.
+type Report struct {
+	// GeneratedAt is only set when requested, see reportTimestamp
+	GeneratedAt string `json:"generated_at,omitempty"`
+	// Shard is the shard of the profile covered by the report, like 3/8,
+	// with --shard
+	Shard string        `json:"shard,omitempty"`
+	Total CoverageStats `json:"total"`
+	// Functions counts the fully covered functions by status, with
+	// --report-functions
+	Functions map[string]int `json:"functions,omitempty"`
+	// Groups are the statements excluded by the directives of each group
+	Groups   map[string]int  `json:"groups,omitempty"`
+	Packages []PackageReport `json:"packages"`
+	// Generators groups the files ignored as generated code by generator
+	Generators []GeneratorReport `json:"generators,omitempty"`
+	Files      []FileReport      `json:"files"`
+	// Timings are the durations of the phases of the run, with
+	// --report-timings
+	Timings []PhaseTiming `json:"timings,omitempty"`
+}
+
+type CoverageStats struct {
+	Statements int     `json:"statements"`
+	Covered    int     `json:"covered"`
+	Excluded   int     `json:"excluded"`
+	Coverage   float64 `json:"coverage"`
+}
+
+type PackageReport struct {
+	Package string `json:"package"`
+	CoverageStats
+}
+
+type GeneratorReport struct {
+	Generator string `json:"generator"`
+	Files     int    `json:"files"`
+	Excluded  int    `json:"excluded"`
+}
+
+type FileReport struct {
+	FileName string `json:"file"`
+	// Path is the path of the source file, relative to the root when the file
+	// is under it
+	Path string `json:"path"`
+	// Generator is the code generator of the file, when it is ignored as
+	// generated code
+	Generator string `json:"generator,omitempty"`
+	CoverageStats
+	Exclusions []Exclusion `json:"exclusions,omitempty"`
+	// Functions are the fully covered functions, with --report-functions
+	Functions []FunctionReport `json:"functions,omitempty"`
+}
+
+const (
+	// FunctionTested is a function whose statements were all run by the tests
+	FunctionTested = "tested"
+	// FunctionExcluded is a function whose statements are all excluded
+	FunctionExcluded = "excluded"
+	// FunctionPartlyExcluded is a function fully covered only because its
+	// statements not run by the tests are excluded
+	FunctionPartlyExcluded = "partly-excluded"
+)
+
+// FunctionReport is a fully covered function, Status tells whether the tests
+// or the exclusions cover it
+type FunctionReport struct {
+	Name   string `json:"name"`
+	Line   int    `json:"line"`
+	Status string `json:"status"`
+	CoverageStats
+}
+
+// Exclusion is a coverage block excluded by an ignore instruction
+type Exclusion struct {
+	StartLine  int  `json:"start_line"`
+	StartCol   int  `json:"start_col"`
+	EndLine    int  `json:"end_line"`
+	EndCol     int  `json:"end_col"`
+	Statements int  `json:"statements"`
+	Count      int  `json:"count"`
+	Soft       bool `json:"soft,omitempty"`
+	// Source is the justification of the exclusion, the line of its
+	// directive in the file, or a description of the option excluding it
+	Source string `json:"source,omitempty"`
+	// Reason is the text following the directive, explaining the exclusion
+	Reason string `json:"reason,omitempty"`
+	// Group is the group of the directive
+	Group string `json:"group,omitempty"`
+	// Options are the options of the directive, like a ticket or an until
+	// date
+	Options map[string]string `json:"options,omitempty"`
+}
+
+// newExclusion returns the exclusion of a block, path is the file of the
+// directive excluding it
+func newExclusion(block cover.ProfileBlock, soft bool, origin Origin, path string) Exclusion {
+	return Exclusion{
+		StartLine:  block.StartLine,
+		StartCol:   block.StartCol,
+		EndLine:    block.EndLine,
+		EndCol:     block.EndCol,
+		Statements: block.NumStmt,
+		Count:      block.Count,
+		Soft:       soft,
+		Source:     origin.source(path),
+		Reason:     origin.Reason,
+		Group:      origin.Group,
+		Options:    origin.Options,
+	}
+}
+
+func (s *CoverageStats) add(other CoverageStats) {
+	s.Statements += other.Statements
+	s.Covered += other.Covered
+	s.Excluded += other.Excluded
+	s.updateCoverage()
+}
+
+func (s *CoverageStats) updateCoverage() {
+	measured := s.Statements - s.Excluded
+	if measured <= 0 {
+		s.Coverage = 100
+		return
+	}
+	s.Coverage = float64(s.Covered) * 100 / float64(measured)
+}
+
+// AddFile adds a file to the report. blocks are the blocks of the file
+// before any instruction was applied.
+func (r *Report) AddFile(fileName string, path string, generator string, blocks []cover.ProfileBlock, exclusions []Exclusion) {
+	file := FileReport{
+		FileName:   fileName,
+		Path:       path,
+		Generator:  generator,
+		Exclusions: exclusions,
+	}
+	for _, block := range blocks {
+		file.Statements += block.NumStmt
+		if block.Count > 0 {
+			file.Covered += block.NumStmt
+		}
+	}
+	for _, exclusion := range exclusions {
+		file.Excluded += exclusion.Statements
+		if exclusion.Count > 0 {
+			file.Covered -= exclusion.Statements
+		}
+	}
+	file.updateCoverage()
+	r.addFileReport(file)
+}
+
+// addFileReport adds the report of a file to the totals of the report
+func (r *Report) addFileReport(file FileReport) {
+	r.Files = append(r.Files, file)
+	r.Total.add(file.CoverageStats)
+	r.addToPackage(packageOf(file.FileName), file.CoverageStats)
+	if file.Generator != "" {
+		r.addToGenerator(file.Generator, file.Excluded)
+	}
+	for _, exclusion := range file.Exclusions {
+		if exclusion.Group != "" {
+			if r.Groups == nil {
+				r.Groups = map[string]int{}
+			}
+			r.Groups[exclusion.Group] += exclusion.Statements
+		}
+	}
+	for _, function := range file.Functions {
+		if r.Functions == nil {
+			r.Functions = map[string]int{FunctionTested: 0, FunctionExcluded: 0, FunctionPartlyExcluded: 0}
+		}
+		r.Functions[function.Status]++
+	}
+}
+
+// addFunctionReports lists the fully covered functions of every file of the
+// report. files are the source files, read from sources, and blocks the
+// blocks before the correction, in the order of the report files. With
+// hideExcludedFiles, the files whose statements are all excluded are left out.
+func addFunctionReports(r *Report, files []string, blocks [][]cover.ProfileBlock, sources *sourceCache, hideExcludedFiles bool) error {
+	r.Functions = map[string]int{FunctionTested: 0, FunctionExcluded: 0, FunctionPartlyExcluded: 0}
+	for i := range r.Files {
+		if hideExcludedFiles && r.Files[i].Statements > 0 && r.Files[i].Excluded == r.Files[i].Statements {
+			continue
+		}
+		src, err := sources.syntax(files[i])
+		if err != nil {
+			return err
+		}
+		r.Files[i].Functions = functionReports(src, blocks[i], r.Files[i].Exclusions)
+		for _, function := range r.Files[i].Functions {
+			r.Functions[function.Status]++
+		}
+	}
+	return nil
+}
+
+func functionReports(src *sourceFile, blocks []cover.ProfileBlock, exclusions []Exclusion) []FunctionReport {
+	excluded := map[[4]int]bool{}
+	for _, e := range exclusions {
+		excluded[[4]int{e.StartLine, e.StartCol, e.EndLine, e.EndCol}] = true
+	}
+	functions := []FunctionReport{}
+	for _, decl := range src.File.Decls {
+		funcDecl, ok := decl.(*ast.FuncDecl)
+		if !ok || funcDecl.Body == nil {
+			continue
+		}
+		body := src.bodyRange(funcDecl.Body)
+		function := FunctionReport{Name: funcDecl.Name.Name, Line: src.line(funcDecl.Pos())}
+		if recv := receiverTypeName(funcDecl); recv != "" {
+			function.Name = recv + "." + function.Name
+		}
+		uncoveredExcluded := 0
+		for _, block := range blocks {
+			if !body.Matches(block) {
+				continue
+			}
+			function.Statements += block.NumStmt
+			isExcluded := excluded[[4]int{block.StartLine, block.StartCol, block.EndLine, block.EndCol}]
+			if isExcluded {
+				function.Excluded += block.NumStmt
+				if block.Count == 0 {
+					uncoveredExcluded += block.NumStmt
+				}
+			} else if block.Count > 0 {
+				function.Covered += block.NumStmt
+			}
+		}
+		if function.Statements == 0 || function.Covered < function.Statements-function.Excluded {
+			continue
+		}
+		switch {
+		case function.Excluded == function.Statements:
+			function.Status = FunctionExcluded
+		case uncoveredExcluded > 0:
+			function.Status = FunctionPartlyExcluded
+		default:
+			function.Status = FunctionTested
+		}
+		function.updateCoverage()
+		functions = append(functions, function)
+	}
+	return functions
+}
+
+func (r *Report) addToGenerator(generator string, excluded int) {
+	for i := range r.Generators {
+		if r.Generators[i].Generator == generator {
+			r.Generators[i].Files++
+			r.Generators[i].Excluded += excluded
+			return
+		}
+	}
+	r.Generators = append(r.Generators, GeneratorReport{Generator: generator, Files: 1, Excluded: excluded})
+}
+
+// exclusions returns the number of excluded blocks
+func (r *Report) exclusions() int {
+	count := 0
+	for _, file := range r.Files {
+		count += len(file.Exclusions)
+	}
+	return count
+}
+
+// packageOf returns the import path of the package of a coverage file name
+func packageOf(fileName string) string {
+	return path.Dir(fileName)
+}
+
+func (r *Report) addToPackage(pkg string, stats CoverageStats) {
+	for i := range r.Packages {
+		if r.Packages[i].Package == pkg {
+			r.Packages[i].add(stats)
+			return
+		}
+	}
+	r.Packages = append(r.Packages, PackageReport{Package: pkg})
+	r.Packages[len(r.Packages)-1].add(stats)
+}
+
+// reportTimestamp returns the time to embed in the report, taken from
+// SOURCE_DATE_EPOCH when it is set so reproducible builds get a stable value
+func reportTimestamp() (string, error) {
+	now := time.Now()
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+		seconds, err := strconv.ParseInt(epoch, 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("invalid SOURCE_DATE_EPOCH [%s]: %w", epoch, err)
+		}
+		now = time.Unix(seconds, 0)
+	}
+	return now.UTC().Format(time.RFC3339), nil
+}
+
+// PhaseTiming is the duration of a phase of the run
+type PhaseTiming struct {
+	Phase   string  `json:"phase"`
+	Seconds float64 `json:"seconds"`
+}
+
+// phaseTimer measures the phases of the run one after the other
+type phaseTimer struct {
+	start  time.Time
+	phases []PhaseTiming
+}
+
+func newPhaseTimer() *phaseTimer {
+	return &phaseTimer{start: time.Now(), phases: []PhaseTiming{}}
+}
+
+// done ends a phase, started when the previous one ended
+func (t *phaseTimer) done(phase string) {
+	now := time.Now()
+	t.phases = append(t.phases, PhaseTiming{Phase: phase, Seconds: now.Sub(t.start).Seconds()})
+	t.start = now
+}
+
+func writeReport(report *Report, path string) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+func readReport(path string) (*Report, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	report := &Report{}
+	if err := json.Unmarshal(data, report); err != nil {
+		return nil, fmt.Errorf("invalid report %s: %w", path, err)
+	}
+	return report, nil
+}
+
+// ExclusionChange is an exclusion added or removed between two reports
+type ExclusionChange struct {
+	FileName string
+	Exclusion
+}
+
+func (c ExclusionChange) String() string {
+	return fmt.Sprintf("%s:%d.%d,%d.%d (%d statements)",
+		c.FileName, c.StartLine, c.StartCol, c.EndLine, c.EndCol, c.Statements)
+}
+
+// diffReports returns the exclusions of current not in previous, and the
+// exclusions of previous not in current. Exclusions are compared by file and
+// position, so an exclusion moved by a code change shows as removed and added.
+func diffReports(previous *Report, current *Report) (added []ExclusionChange, removed []ExclusionChange) {
+	return exclusionsNotIn(current, previous), exclusionsNotIn(previous, current)
+}
+
+func exclusionsNotIn(report *Report, other *Report) []ExclusionChange {
+	type key struct {
+		FileName                             string
+		StartLine, StartCol, EndLine, EndCol int
+	}
+	otherKeys := map[key]bool{}
+	for _, file := range other.Files {
+		for _, e := range file.Exclusions {
+			otherKeys[key{file.FileName, e.StartLine, e.StartCol, e.EndLine, e.EndCol}] = true
+		}
+	}
+	changes := []ExclusionChange{}
+	for _, file := range report.Files {
+		for _, e := range file.Exclusions {
+			if !otherKeys[key{file.FileName, e.StartLine, e.StartCol, e.EndLine, e.EndCol}] {
+				changes = append(changes, ExclusionChange{FileName: file.FileName, Exclusion: e})
+			}
+		}
+	}
+	return changes
+}
+
+func printReportDiff(w io.Writer, previousFile string, previous *Report, current *Report) {
+	added, removed := diffReports(previous, current)
+	fmt.Fprintf(w, "Exclusions compared to %s:\n", previousFile)
+	addedStatements, removedStatements := 0, 0
+	for _, change := range added {
+		fmt.Fprintf(w, "+ %s\n", change)
+		addedStatements += change.Statements
+	}
+	for _, change := range removed {
+		fmt.Fprintf(w, "- %s\n", change)
+		removedStatements += change.Statements
+	}
+	fmt.Fprintf(w, "%d exclusions added (%d statements), %d exclusions removed (%d statements), net %+d excluded statements\n",
+		len(added), addedStatements, len(removed), removedStatements, addedStatements-removedStatements)
+}
@@ -0,0 +1,289 @@
+//coverage:ignore file
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+// selftestCase is a file of the self test module. Its tested code is fully
+// covered once the directives are applied, and the directives exclude some
+// statements. The directives are written @coverage:ignore in the sources, so
+// they do not apply to this file.
+type selftestCase struct {
+	Name   string
+	Source string
+}
+
+var selftestCases = []selftestCase{
+	{"block", `package selftest
+
+func Block(fail bool) string {
+	if fail {
+		@coverage:ignore
+		return "failed"
+	}
+	return "ok"
+}
+`},
+	{"file", `@coverage:ignore file
+package selftest
+
+func File() string {
+	return "ignored"
+}
+`},
+	{"soft", `package selftest
+
+func Soft(fail bool) string {
+	if fail {
+		@coverage:ignore soft
+		return "failed"
+	}
+	return "ok"
+}
+`},
+	{"doc", `package selftest
+
+// Doc is ignored by a directive in its doc comment.
+//
+@coverage:ignore
+func Doc() string {
+	return "ignored"
+}
+`},
+	{"case", `package selftest
+
+func Case(n int) string {
+	switch {
+	@coverage:ignore
+	case n < 0:
+		return "negative"
+	case n == 0:
+		return "zero"
+	}
+	return "positive"
+}
+`},
+	{"label", `package selftest
+
+func Label(n int) int {
+	steps := 0
+	goto check
+	@coverage:ignore
+decrement:
+	n--
+	steps++
+check:
+	if n > 0 {
+		@coverage:ignore
+		goto decrement
+	}
+	return steps
+}
+`},
+	{"func", `package selftest
+
+@coverage:ignore func
+func Func(n int) int {
+	if n > 0 {
+		return n
+	}
+	return -n
+}
+`},
+	{"region", `package selftest
+
+func Region(n int) string {
+	@coverage:ignore begin
+	switch n {
+	case 1:
+		return "one"
+	case 2:
+		return "two"
+	}
+	@coverage:ignore end
+	return "many"
+}
+`},
+	{"next", `package selftest
+
+func Next(skip bool) int {
+	if skip {
+		return 0
+	}
+	@coverage:ignore next 2
+	a := 1
+	b := 2
+	return a + b
+}
+`},
+	{"inline", `package selftest
+
+func Inline(fail bool) string {
+	if fail {
+		return "failed" @coverage:ignore
+	}
+	return "ok"
+}
+`},
+	{"funcs", `@coverage:ignore funcs=Close
+package selftest
+
+type Closer struct{}
+
+func (c Closer) Open() string {
+	return "open"
+}
+
+func (c Closer) Close() error {
+	return nil
+}
+`},
+	{"errors", `package selftest
+
+import "errors"
+
+@coverage:ignore scope=error-returns
+func Errors(fail bool) (string, error) {
+	err := check(fail)
+	if err != nil {
+		return "", err
+	}
+	return "ok", nil
+}
+
+func check(fail bool) error {
+	if fail {
+		@coverage:ignore
+		return errors.New("failed")
+	}
+	return nil
+}
+`},
+}
+
+const selftestTest = `package selftest
+
+import "testing"
+
+func TestSelf(t *testing.T) {
+	Block(false)
+	Soft(false)
+	Case(1)
+	Case(0)
+	Label(0)
+	Closer{}.Open()
+	Region(3)
+	Next(true)
+	Inline(false)
+	Errors(false)
+}
+`
+
+func selftestCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "selftest",
+		Usage: "check that the directives behave as documented with the installed Go version",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "keep",
+				Usage: "keep the generated module, to inspect it",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			dir, err := os.MkdirTemp("", "go-ignore-cov-selftest")
+			if err != nil {
+				return err
+			}
+			if c.Bool("keep") {
+				fmt.Printf("Self test module in %s\n", dir)
+			} else {
+				defer os.RemoveAll(dir)
+			}
+			failures, err := selftest(os.Stdout, dir)
+			if err != nil {
+				return err
+			}
+			if failures > 0 {
+				return fmt.Errorf("%d of the %d cases failed, the directives do not behave as documented with this Go version", failures, len(selftestCases))
+			}
+			fmt.Printf("All the %d cases passed\n", len(selftestCases))
+			return nil
+		},
+	}
+}
+
+// selftest writes the self test module in dir, measures its coverage with
+// the go command and corrects it with this executable, then checks every
+// case is fully covered with some statements excluded
+func selftest(w io.Writer, dir string) (int, error) {
+	files := map[string]string{
+		"go.mod":           "module selftest\n\ngo 1.18\n",
+		"selftest_test.go": selftestTest,
+	}
+	for _, tc := range selftestCases {
+		files[tc.Name+".go"] = strings.ReplaceAll(tc.Source, "@coverage:ignore", "//coverage:ignore")
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			return 0, err
+		}
+	}
+	version, err := runIn(dir, "go", "version")
+	if err != nil {
+		return 0, err
+	}
+	fmt.Fprintf(w, "Testing with %s\n", strings.TrimSpace(version))
+	if _, err := runIn(dir, "go", "test", "-coverprofile", "coverage.out", "-covermode", "count", "./..."); err != nil {
+		return 0, err
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := runIn(dir, executable, "--file", "coverage.out", "--output", "corrected.out", "--report", "report.json"); err != nil {
+		return 0, err
+	}
+	report, err := readReport(filepath.Join(dir, "report.json"))
+	if err != nil {
+		return 0, err
+	}
+	failures := 0
+	for _, tc := range selftestCases {
+		file, found := FileReport{}, false
+		for _, f := range report.Files {
+			if f.Path == tc.Name+".go" {
+				file, found = f, true
+			}
+		}
+		switch {
+		case !found:
+			failures++
+			fmt.Fprintf(w, "[fail] %s: not in the coverage file\n", tc.Name)
+		case file.Coverage < 100 || file.Excluded == 0:
+			failures++
+			fmt.Fprintf(w, "[fail] %s: %.1f%% covered with %d excluded statement(s), expected 100%% with some excluded\n",
+				tc.Name, file.Coverage, file.Excluded)
+		default:
+			fmt.Fprintf(w, "[ok]   %s: 100%% covered, %d excluded statement(s)\n", tc.Name, file.Excluded)
+		}
+	}
+	return failures, nil
+}
+
+func runIn(dir string, name string, args ...string) (string, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%s %s failed: %w\n%s", name, strings.Join(args, " "), err, out)
+	}
+	return string(out), nil
+}
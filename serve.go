@@ -0,0 +1,309 @@
+//coverage:ignore file
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/quantumcycle/go-ignore-cov/profile"
+	"github.com/urfave/cli/v2"
+)
+
+// MaxUploadSize bounds the size of an uploaded profile
+const MaxUploadSize = 64 << 20
+
+// ReadTimeout bounds the upload of a profile, and WriteTimeout the time from
+// the end of the upload to the end of the answer, the wait in the queue and
+// the correction included
+const (
+	ReadTimeout  = 5 * time.Minute
+	WriteTimeout = 15 * time.Minute
+)
+
+// CorrectResponse is the answer of the API to a profile upload
+type CorrectResponse struct {
+	Repo string `json:"repo"`
+	// Profile is the corrected coverage file
+	Profile    string           `json:"profile"`
+	Report     *Report          `json:"report"`
+	Thresholds ThresholdsResult `json:"thresholds"`
+}
+
+// RejectedResponse lists the directives rejected by the configuration of
+// the repository
+type RejectedResponse struct {
+	Error      string      `json:"error"`
+	Violations []LintIssue `json:"violations"`
+}
+
+func serveAPICommand() *cli.Command {
+	flags := []cli.Flag{}
+	for _, f := range correctionFlags() {
+		//the profile, the root and the configuration come with each request
+		if name := f.Names()[0]; name != "file" && name != "root" && name != "config" {
+			flags = append(flags, f)
+		}
+	}
+	return &cli.Command{
+		Name:  "serve-api",
+		Usage: "serve an HTTP API correcting the uploaded profiles with the directives and the configuration of checked out repositories",
+		Flags: append(flags,
+			&cli.StringFlag{
+				Name:  "listen",
+				Usage: "address to listen on",
+				Value: ":8080",
+			},
+			&cli.StringFlag{
+				Name:     "repos",
+				Usage:    "directory of the checkouts, a repository is named after its subdirectory",
+				Required: true,
+			},
+			&cli.IntFlag{
+				Name:  "max-concurrent-profiles",
+				Usage: "number of profiles corrected at once, the other uploads wait in a queue, 0 for no limit",
+				Value: runtime.NumCPU(),
+			},
+			&cli.IntFlag{
+				Name:  "memory-budget",
+				Usage: "approximate memory in MiB of the profiles corrected at once, estimated from their size, 0 for no budget",
+			},
+		),
+		Action: func(c *cli.Context) error {
+			repos, err := filepath.Abs(c.String("repos"))
+			if err != nil {
+				return err
+			}
+			if info, err := os.Stat(repos); err != nil {
+				return err
+			} else if !info.IsDir() {
+				return fmt.Errorf("the repositories directory %s is not a directory", repos)
+			}
+			if c.Int("max-concurrent-profiles") < 0 || c.Int("memory-budget") < 0 {
+				return fmt.Errorf("--max-concurrent-profiles and --memory-budget cannot be negative")
+			}
+			limiter := newProfileLimiter(c.Int("max-concurrent-profiles"), int64(c.Int("memory-budget"))<<20)
+			mux := http.NewServeMux()
+			mux.HandleFunc("/correct", correctHandler(c, repos, limiter))
+			mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+				if err := limiter.writeMetrics(w); err != nil {
+					warn("writing the metrics: %v", err)
+				}
+			})
+			mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintln(w, "ok")
+			})
+			server := &http.Server{
+				Addr:              c.String("listen"),
+				Handler:           mux,
+				ReadHeaderTimeout: 10 * time.Second,
+				ReadTimeout:       ReadTimeout,
+				WriteTimeout:      WriteTimeout,
+			}
+			fmt.Printf("Serving the repositories of %s on %s\n", repos, server.Addr)
+			return server.ListenAndServe()
+		},
+	}
+}
+
+// repoRoot returns the checkout of a repository, the name must be a
+// subdirectory of the repositories directory
+func repoRoot(repos string, name string) (string, error) {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("invalid repository [%s]", name)
+	}
+	root := filepath.Join(repos, name)
+	if info, err := os.Stat(root); err != nil || !info.IsDir() {
+		return "", fmt.Errorf("unknown repository [%s]", name)
+	}
+	return root, nil
+}
+
+// correctHandler corrects the profile posted to /correct?repo=<name> with the
+// directives and the configuration of the checkout of the repository. The
+// other options are the ones of the serve-api command. The correction waits
+// for the limiter once the profile is uploaded.
+func correctHandler(c *cli.Context, repos string, limiter *profileLimiter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "post the coverage profile", http.StatusMethodNotAllowed)
+			return
+		}
+		name := r.URL.Query().Get("repo")
+		root, err := repoRoot(repos, name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err := checkSearchRoots(root); err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		upload, err := os.CreateTemp("", "go-ignore-cov-upload-*.out")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer os.Remove(upload.Name())
+		size, err := io.Copy(upload, http.MaxBytesReader(w, r.Body, MaxUploadSize))
+		if closeErr := upload.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("reading the profile: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := checkUploadedFiles(root, upload.Name()); err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		release, err := limiter.acquire(r.Context(), size)
+		if err != nil {
+			if r.Context().Err() != nil {
+				//the client went away while queued
+				return
+			}
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		defer release()
+
+		//the flags of the request shadow the ones of the command
+		set := flag.NewFlagSet("correct", flag.ContinueOnError)
+		set.String("file", upload.Name(), "")
+		set.String("root", root, "")
+		set.String("config", "", "")
+		correction, err := correctCoverage(cli.NewContext(c.App, set, c))
+		if err != nil {
+			warn("correcting the profile of %s: %v", name, err)
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		correction.Warnings.flush()
+		if len(correction.Violations) > 0 {
+			writeJSON(w, http.StatusUnprocessableEntity, RejectedResponse{
+				Error:      fmt.Sprintf("%d directive(s) rejected by the configuration", len(correction.Violations)),
+				Violations: correction.Violations,
+			})
+			return
+		}
+		var corrected bytes.Buffer
+		if err := profile.WriteProfiles(&corrected, correction.Profiles, profile.WriteOptions{}); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		thresholdsErr := checkThresholds(io.Discard, correction.Report, correction.Config, time.Now())
+		response := CorrectResponse{
+			Repo:       name,
+			Profile:    corrected.String(),
+			Report:     correction.Report,
+			Thresholds: ThresholdsResult{Passed: thresholdsErr == nil},
+		}
+		if thresholdsErr != nil {
+			response.Thresholds.Error = thresholdsErr.Error()
+		}
+		writeJSON(w, http.StatusOK, response)
+	}
+}
+
+// checkUploadedFiles checks that the files of an uploaded profile are in the
+// checkout, so the names sent by the clients are never looked up with the go
+// command or outside of the repository
+func checkUploadedFiles(root string, upload string) error {
+	f, err := os.Open(upload)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	resolver := newModuleResolver(root)
+	checked := map[string]bool{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), MaxUploadSize)
+	for scanner.Scan() {
+		line := scanner.Text()
+		colon := strings.LastIndex(line, ":")
+		if strings.HasPrefix(line, "mode:") || colon < 0 {
+			//the profile is parsed and checked by the correction
+			continue
+		}
+		name := line[:colon]
+		if checked[name] {
+			continue
+		}
+		checked[name] = true
+		if !resolver.inRepository(name) {
+			return fmt.Errorf("the file %s of the profile is not in the repository", name)
+		}
+	}
+	return scanner.Err()
+}
+
+// checkSearchRoots checks that the search roots of the configuration of a
+// checkout are in the checkout, a committed configuration must not make the
+// server scan the directories of the host
+func checkSearchRoots(root string) error {
+	config, err := loadConfig("", root)
+	if err != nil {
+		return err
+	}
+	for _, dir := range config.SearchRoots {
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(root, dir)
+		}
+		//the symbolic links are resolved, they could point outside of the checkout
+		if rel := relativePath(canonicalPath(root), canonicalPath(dir)); filepath.IsAbs(filepath.FromSlash(rel)) {
+			return fmt.Errorf("the search root %s of the configuration is outside of the repository", dir)
+		}
+	}
+	return nil
+}
+
+// inRepository reports whether a file of a profile is an existing file of
+// the root, named by its path in the module, in the vendor directory or by
+// its absolute path
+func (r *moduleResolver) inRepository(file string) bool {
+	var path string
+	switch {
+	case filepath.IsAbs(file):
+		path = filepath.Clean(file)
+	case strings.Contains("/"+file+"/", "/../"):
+		return false
+	case r.modulePath != "" && strings.HasPrefix(file, r.modulePath+"/"):
+		path = filepath.Join(r.root, filepath.FromSlash(strings.TrimPrefix(file, r.modulePath+"/")))
+	case r.vendored:
+		path = filepath.Join(r.root, "vendor", filepath.FromSlash(file))
+	default:
+		return false
+	}
+	//the symbolic links are resolved, they could point outside of the checkout
+	rel, err := filepath.Rel(canonicalPath(r.root), canonicalPath(path))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false
+	}
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err := w.Write(append(data, '\n')); err != nil {
+		warn("writing the response: %v", err)
+	}
+}
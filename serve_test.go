@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/urfave/cli/v2"
+)
+
+// serveContext returns the context of the serve-api command with its flags
+// parsed from args
+func serveContext(t *testing.T, args ...string) *cli.Context {
+	t.Helper()
+	set := flag.NewFlagSet("serve-api", flag.ContinueOnError)
+	for _, f := range serveAPICommand().Flags {
+		if err := f.Apply(set); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := set.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return cli.NewContext(&cli.App{}, set, nil)
+}
+
+// testRepos writes a repositories directory with the checkouts of two
+// repositories: app, and roots whose configuration sets a search root outside
+// of its checkout
+func testRepos(t *testing.T) string {
+	t.Helper()
+	return writeTestFiles(t, map[string]string{
+		"roots/go.mod":               "module example.com/roots\n\ngo 1.18\n",
+		"roots/roots.go":             "package roots\n",
+		"roots/" + DefaultConfigFile: "search_roots:\n  - ../app\n",
+		"app/go.mod":                 "module example.com/app\n\ngo 1.18\n",
+		"app/app.go": `package app
+
+func Check(n int) bool {
+	if n < 0 {
+		@coverage:ignore
+		return false
+	}
+	return true
+}
+`,
+	})
+}
+
+const appProfile = `mode: set
+example.com/app/app.go:4.2,4.11 1 1
+example.com/app/app.go:6.3,6.15 1 0
+example.com/app/app.go:8.2,8.13 1 1
+`
+
+func TestCorrectHandler(t *testing.T) {
+	handler := correctHandler(serveContext(t), testRepos(t), newProfileLimiter(1, 512*ProfileMemoryFactor))
+	tests := []struct {
+		name    string
+		method  string
+		target  string
+		profile string
+		status  int
+	}{
+		{name: "get", method: http.MethodGet, target: "/correct?repo=app", status: http.StatusMethodNotAllowed},
+		{name: "unknown repository", method: http.MethodPost, target: "/correct?repo=other", profile: appProfile, status: http.StatusNotFound},
+		{name: "parent directory", method: http.MethodPost, target: "/correct?repo=..", profile: appProfile, status: http.StatusNotFound},
+		{name: "invalid profile", method: http.MethodPost, target: "/correct?repo=app", profile: "mode: set\napp.go\n", status: http.StatusUnprocessableEntity},
+		{name: "file outside of the checkout", method: http.MethodPost, target: "/correct?repo=app", profile: "mode: set\nexample.com/app/../other/other.go:3.2,4.1 1 0\n", status: http.StatusUnprocessableEntity},
+		{name: "absolute file outside of the checkout", method: http.MethodPost, target: "/correct?repo=app", profile: "mode: set\n" + os.Args[0] + ":3.2,4.1 1 0\n", status: http.StatusUnprocessableEntity},
+		{name: "search root outside of the checkout", method: http.MethodPost, target: "/correct?repo=roots", profile: "mode: set\n", status: http.StatusUnprocessableEntity},
+		{name: "over the memory budget", method: http.MethodPost, target: "/correct?repo=app", profile: appProfile + strings.Repeat(" ", 512), status: http.StatusRequestEntityTooLarge},
+		{name: "corrected", method: http.MethodPost, target: "/correct?repo=app", profile: appProfile, status: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			handler(recorder, httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.profile)))
+			if recorder.Code != tt.status {
+				t.Fatalf("expected the status %d, got %d: %s", tt.status, recorder.Code, recorder.Body.String())
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			response := CorrectResponse{}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			expected := "mode: set\nexample.com/app/app.go:4.2,4.11 1 1\nexample.com/app/app.go:8.2,8.13 1 1\n"
+			if response.Repo != "app" || response.Profile != expected {
+				t.Errorf("expected the corrected profile of app:\n%s\ngot %s:\n%s", expected, response.Repo, response.Profile)
+			}
+			if !response.Thresholds.Passed {
+				t.Errorf("expected the thresholds to pass, got %s", response.Thresholds.Error)
+			}
+		})
+	}
+}
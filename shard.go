@@ -0,0 +1,177 @@
+//coverage:ignore file
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"golang.org/x/tools/cover"
+)
+
+// Shard is a slice of the packages of a profile, Index starts at 1
+type Shard struct {
+	Index int
+	Count int
+}
+
+// parseShard parses a shard written index/count, like 3/8
+func parseShard(spec string) (Shard, error) {
+	index, count, found := strings.Cut(spec, "/")
+	if !found {
+		return Shard{}, fmt.Errorf("invalid shard [%s], expected index/count like 3/8", spec)
+	}
+	shard := Shard{}
+	var err error
+	if shard.Index, err = strconv.Atoi(index); err != nil {
+		return Shard{}, fmt.Errorf("invalid shard [%s], expected index/count like 3/8", spec)
+	}
+	if shard.Count, err = strconv.Atoi(count); err != nil {
+		return Shard{}, fmt.Errorf("invalid shard [%s], expected index/count like 3/8", spec)
+	}
+	if shard.Count < 1 || shard.Index < 1 || shard.Index > shard.Count {
+		return Shard{}, fmt.Errorf("invalid shard [%s], the index must be between 1 and the count", spec)
+	}
+	return shard, nil
+}
+
+func (s Shard) String() string {
+	return fmt.Sprintf("%d/%d", s.Index, s.Count)
+}
+
+// includes reports whether the file of the coverage file belongs to the
+// shard. The files are partitioned by package, from a hash of the import
+// path, so a package is never split across shards and its threshold is
+// checked as a whole.
+func (s Shard) includes(fileName string) bool {
+	h := fnv.New32a()
+	h.Write([]byte(packageOf(fileName)))
+	return int(h.Sum32()%uint32(s.Count)) == s.Index-1
+}
+
+// filterShard keeps the profiles of the shard, along with their files
+func filterShard(shard Shard, profiles []*cover.Profile, files []string, filePaths []FilePath) ([]*cover.Profile, []string, []FilePath) {
+	keptProfiles, keptFiles, keptPaths := []*cover.Profile{}, []string{}, []FilePath{}
+	for i, profile := range profiles {
+		if shard.includes(profile.FileName) {
+			keptProfiles = append(keptProfiles, profile)
+			keptFiles = append(keptFiles, files[i])
+			keptPaths = append(keptPaths, filePaths[i])
+		}
+	}
+	return keptProfiles, keptFiles, keptPaths
+}
+
+func mergeReportsCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "merge-reports",
+		Usage:     "combine the JSON reports of the shards into one report, and check the thresholds on it",
+		ArgsUsage: "report.json...",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "output",
+				Aliases: []string{"o"},
+				Usage:   "merged report file",
+			},
+			&cli.StringFlag{
+				Name:    "root",
+				Aliases: []string{"r"},
+				Usage:   "module root",
+			},
+			&cli.StringFlag{
+				Name:  "config",
+				Usage: "configuration file, " + DefaultConfigFile + " in the root by default",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if c.NArg() == 0 {
+				return fmt.Errorf("no report to merge, pass the report files as arguments")
+			}
+			reports := make([]*Report, c.NArg())
+			for i, file := range c.Args().Slice() {
+				report, err := readReport(file)
+				if err != nil {
+					return err
+				}
+				reports[i] = report
+			}
+			merged, err := mergeReports(reports)
+			if err != nil {
+				return err
+			}
+			if output := c.String("output"); output != "" {
+				if err := writeReport(merged, output); err != nil {
+					return err
+				}
+			}
+			root := c.String("root")
+			if root == "" {
+				root = "."
+			}
+			root, err = filepath.Abs(root)
+			if err != nil {
+				return err
+			}
+			config, err := loadConfig(c.String("config"), canonicalPath(root))
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Merged %d report(s), %d files, total coverage %.1f%%\n", len(reports), len(merged.Files), merged.Total.Coverage)
+			return checkThresholds(os.Stdout, merged, config, time.Now())
+		},
+	}
+}
+
+// mergeReports combines the reports of the shards of a profile. The totals
+// are computed again from the files, and a file found in several reports is
+// an error, as the shards overlap. A warning is printed when some shards are
+// missing.
+func mergeReports(reports []*Report) (*Report, error) {
+	merged := &Report{}
+	seen := map[string]bool{}
+	shards := map[string]bool{}
+	count := 0
+	files := []FileReport{}
+	for _, report := range reports {
+		if report.Shard != "" {
+			shard, err := parseShard(report.Shard)
+			if err != nil {
+				return nil, err
+			}
+			if count != 0 && shard.Count != count {
+				return nil, fmt.Errorf("the reports come from different shardings, %d and %d shards", count, shard.Count)
+			}
+			if shards[report.Shard] {
+				return nil, fmt.Errorf("the shard %s is given twice", report.Shard)
+			}
+			count = shard.Count
+			shards[report.Shard] = true
+		}
+		if report.GeneratedAt > merged.GeneratedAt {
+			merged.GeneratedAt = report.GeneratedAt
+		}
+		for _, file := range report.Files {
+			if seen[file.FileName] {
+				return nil, fmt.Errorf("the file %s is in several reports, the shards overlap", file.FileName)
+			}
+			seen[file.FileName] = true
+			files = append(files, file)
+		}
+	}
+	if count > 0 && len(shards) < count {
+		warn("only %d of the %d shards are merged, the coverage is partial", len(shards), count)
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].FileName < files[j].FileName
+	})
+	for _, file := range files {
+		merged.addFileReport(file)
+	}
+	return merged, nil
+}
@@ -0,0 +1,181 @@
+//coverage:ignore file
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+// DefaultApprovalToken is the text expected in the pull request description
+// to accept new directives
+const DefaultApprovalToken = "[coverage-ignore approved]"
+
+func signoffCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "signoff",
+		Usage: "fail when the changes since a base ref add ignore directives, unless the pull request description approves them",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "root",
+				Aliases: []string{"r"},
+				Usage:   "module root",
+			},
+			&cli.StringFlag{
+				Name:  "config",
+				Usage: "configuration file, " + DefaultConfigFile + " in the root by default",
+			},
+			&cli.StringSliceFlag{
+				Name:  "directive-prefix",
+				Usage: "recognize //<prefix> comments as directives too, like nocov for //nocov, on top of //coverage:ignore",
+			},
+			&cli.StringFlag{
+				Name:     "base",
+				Usage:    "ref the changes are compared with, from its merge base with HEAD, like origin/main",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:    "description",
+				Usage:   "pull request description",
+				EnvVars: []string{"PR_DESCRIPTION"},
+			},
+			&cli.StringFlag{
+				Name:  "approval-token",
+				Usage: "text of the pull request description approving the new directives",
+				Value: DefaultApprovalToken,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			root := c.String("root")
+			if root == "" {
+				root = "."
+			}
+			root, err := filepath.Abs(root)
+			if err != nil {
+				return err
+			}
+			root = canonicalPath(root)
+			config, err := loadConfig(c.String("config"), root)
+			if err != nil {
+				return err
+			}
+			syntax, err := newDirectiveSyntax(append(config.DirectivePrefixes, c.StringSlice("directive-prefix")...))
+			if err != nil {
+				return err
+			}
+			directives, err := addedDirectives(root, c.String("base"), syntax)
+			if err != nil {
+				return err
+			}
+			return signoff(os.Stdout, directives, c.String("description"), c.String("approval-token"))
+		},
+	}
+}
+
+// AddedDirective is a directive added or modified since the base ref
+type AddedDirective struct {
+	Path string
+	Line int
+	Text string
+}
+
+// addedDirectives returns the directives of the lines added or modified in
+// the go files under root since the base ref
+func addedDirectives(root string, base string, syntax *DirectiveSyntax) ([]AddedDirective, error) {
+	vcs, err := openVCS(root)
+	if err != nil {
+		return nil, err
+	}
+	repoRoot, err := vcs.Root()
+	if err != nil {
+		return nil, err
+	}
+	added, err := vcs.AddedLines(base)
+	if err != nil {
+		return nil, err
+	}
+	directives := []AddedDirective{}
+	for file, ranges := range added {
+		if !strings.HasSuffix(file, ".go") {
+			continue
+		}
+		path := filepath.Join(repoRoot, filepath.FromSlash(file))
+		rel := relativePath(root, path)
+		if filepath.IsAbs(filepath.FromSlash(rel)) {
+			continue
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		fileDirectives, err := addedFileDirectives(rel, content, ranges, syntax)
+		if err != nil {
+			return nil, err
+		}
+		directives = append(directives, fileDirectives...)
+	}
+	sort.Slice(directives, func(i, j int) bool {
+		if directives[i].Path != directives[j].Path {
+			return directives[i].Path < directives[j].Path
+		}
+		return directives[i].Line < directives[j].Line
+	})
+	return directives, nil
+}
+
+// addedFileDirectives returns the directives of the added lines of a file,
+// the directives of the block comments included, like the scanner does
+func addedFileDirectives(rel string, content []byte, ranges []LineRange, syntax *DirectiveSyntax) ([]AddedDirective, error) {
+	directives := []AddedDirective{}
+	blockComments := map[int]string{}
+	if bytes.Contains(content, []byte("/*")) {
+		blockComments = blockCommentLines(content)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		if !inRanges(ranges, lineNumber) {
+			continue
+		}
+		_, ok := syntax.parse(scanner.Text())
+		if comment, found := blockComments[lineNumber]; found && !ok {
+			_, ok = syntax.parse(comment)
+		}
+		if ok {
+			directives = append(directives, AddedDirective{Path: rel, Line: lineNumber, Text: strings.TrimSpace(scanner.Text())})
+		}
+	}
+	return directives, scanner.Err()
+}
+
+func inRanges(ranges []LineRange, line int) bool {
+	for _, r := range ranges {
+		if r.Contains(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// signoff lists the added directives and fails when there are some and the
+// description does not contain the approval token
+func signoff(w io.Writer, directives []AddedDirective, description string, token string) error {
+	if len(directives) == 0 {
+		fmt.Fprintln(w, "No directive added")
+		return nil
+	}
+	for _, directive := range directives {
+		fmt.Fprintf(w, "%s:%d: %s\n", directive.Path, directive.Line, directive.Text)
+	}
+	if !strings.Contains(description, token) {
+		return fmt.Errorf("%d directive(s) added, they need an approval: add %s to the pull request description", len(directives), token)
+	}
+	fmt.Fprintf(w, "%d directive(s) added, approved by the pull request description\n", len(directives))
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+)
+
+const signoffSource = `package app
+
+func Check(n int) int {
+	if n < 0 {
+		@coverage:ignore
+		return -1
+	}
+	if n == 0 {
+		/* @coverage:ignore */
+		return 0
+	}
+	if n > 100 { /* @coverage:ignore too large */
+		return 100
+	}
+	/*
+	 * @coverage:ignore
+	 */
+	if n > 10 {
+		return 10
+	}
+	s := "/* @coverage:ignore */"
+	return len(s)
+}
+`
+
+func TestAddedFileDirectives(t *testing.T) {
+	content := []byte(strings.ReplaceAll(signoffSource, "@coverage:ignore", "//coverage:ignore"))
+	syntax, err := newDirectiveSyntax(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		ranges []LineRange
+		lines  []int
+	}{
+		{name: "whole file", ranges: []LineRange{{Start: 1, End: math.MaxInt32}}, lines: []int{5, 9, 12, 16}},
+		//only the added lines of the multi-line comment count
+		{name: "added lines", ranges: []LineRange{{Start: 8, End: 10}, {Start: 15, End: 15}}, lines: []int{9}},
+		{name: "string", ranges: []LineRange{{Start: 21, End: 21}}, lines: []int{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			directives, err := addedFileDirectives("app.go", content, tt.ranges, syntax)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := []int{}
+			for _, directive := range directives {
+				lines = append(lines, directive.Line)
+			}
+			if !equalInts(lines, tt.lines) {
+				t.Errorf("expected the directives of the lines %v, got %v", tt.lines, lines)
+			}
+		})
+	}
+}
+
+func TestSignoff(t *testing.T) {
+	directives := []AddedDirective{{Path: "app.go", Line: 9, Text: "/* @coverage:ignore */"}}
+	var out bytes.Buffer
+	if err := signoff(&out, directives, "fix the check", DefaultApprovalToken); err == nil {
+		t.Errorf("expected an error without the approval token")
+	}
+	if !strings.Contains(out.String(), "app.go:9: /* @coverage:ignore */") {
+		t.Errorf("expected the added directive to be listed, got %q", out.String())
+	}
+	if err := signoff(&out, directives, "fix the check "+DefaultApprovalToken, DefaultApprovalToken); err != nil {
+		t.Errorf("expected the approval token to accept the directive, got %v", err)
+	}
+	if err := signoff(&out, []AddedDirective{}, "", DefaultApprovalToken); err != nil {
+		t.Errorf("expected no error without directives, got %v", err)
+	}
+}
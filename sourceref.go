@@ -0,0 +1,55 @@
+//coverage:ignore file
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// readIgnoreCoverageFromRef scans the go files under root as they are at a
+// revision of the repository, so an old profile is corrected with the
+// directives of the code it was produced from. The instructions keep the
+// working tree path of the files, which is where the profile points to.
+func readIgnoreCoverageFromRef(root string, ref string, opts ScanOptions) ([]IgnoreCoverage, error) {
+	vcs, err := openVCS(root)
+	if err != nil {
+		return nil, err
+	}
+	repoRoot, err := vcs.Root()
+	if err != nil {
+		return nil, err
+	}
+	commit, err := vcs.ResolveRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	files, err := vcs.ListFiles(commit)
+	if err != nil {
+		return nil, err
+	}
+	ignores := []IgnoreCoverage{}
+	for _, file := range files {
+		if !strings.HasSuffix(file, ".go") {
+			continue
+		}
+		path := filepath.Join(repoRoot, filepath.FromSlash(file))
+		if rel, err := filepath.Rel(root, path); err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		content, err := vcs.ReadFile(commit, file)
+		if err != nil {
+			return nil, err
+		}
+		instructions, err := readInstructionsFromSource(path, content, opts)
+		if err != nil {
+			return nil, err
+		}
+		if len(instructions) > 0 {
+			ignores = append(ignores, IgnoreCoverage{
+				Filepath:     path,
+				Instructions: instructions,
+			})
+		}
+	}
+	return ignores, nil
+}
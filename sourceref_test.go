@@ -0,0 +1,94 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/urfave/cli/v2"
+)
+
+// correctionContext returns the context of the correction with its flags
+// parsed from args
+func correctionContext(t *testing.T, args ...string) *cli.Context {
+	t.Helper()
+	set := flag.NewFlagSet("go-ignore-cov", flag.ContinueOnError)
+	for _, f := range correctionFlags() {
+		if err := f.Apply(set); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := set.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return cli.NewContext(&cli.App{}, set, nil)
+}
+
+// commitTestFiles commits all the files of dir in a new git repository
+func commitTestFiles(t *testing.T, dir string) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "-A"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "initial"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s failed: %v: %s", strings.Join(args, " "), err, out)
+		}
+	}
+}
+
+func TestSourceRefTypeIgnores(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"go.mod": "module example.com/app\n\ngo 1.18\n",
+		"fake.go": `package app
+
+@coverage:ignore type
+type Fake struct{}
+`,
+		"close.go": `package app
+
+func (Fake) Close() error {
+	return nil
+}
+`,
+		"coverage.out": "mode: set\nexample.com/app/close.go:4.2,4.12 1 0\n",
+	})
+	commitTestFiles(t, dir)
+	//the method moved in the working tree, the profile is the one of the ref
+	moved := "package app\n\n// Close does nothing\n// for the tests\nfunc (Fake) Close() error {\n\treturn nil\n}\n"
+	if err := os.WriteFile(filepath.Join(dir, "close.go"), []byte(moved), 0644); err != nil {
+		t.Fatal(err)
+	}
+	correction, err := correctCoverage(correctionContext(t, "--root", dir, "--file", filepath.Join(dir, "coverage.out"), "--source-ref", "HEAD"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blocks := correction.Profiles[0].Blocks; len(blocks) != 0 {
+		t.Errorf("expected the method of the ignored type to be excluded at the ref, got the blocks %v", blocks)
+	}
+}
+
+func TestLintReadsSources(t *testing.T) {
+	//the file is not on the disk, it is read from the sources like at a ref
+	path := filepath.Join(t.TempDir(), "app.go")
+	sources := newSourceCache(true)
+	sources.add(path, []byte("package app\n\nfunc Check() int {\n\treturn 1\n\tprintln()\n}\n"), nil)
+	//the directive of line 4 ignores the unreachable statement of line 5
+	ignore := &IgnoreCoverage{Filepath: path, Instructions: []Instruction{IgnoreBlock{Line: 5, Col: 2, Origin: Origin{Line: 4}}}}
+	issues, err := lintUnreachable("app.go", ignore, sources)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issues) != 1 || issues[0].Line != 5 {
+		t.Errorf("expected the directive on unreachable code to be reported, got %v", issues)
+	}
+}
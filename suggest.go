@@ -0,0 +1,72 @@
+//coverage:ignore file
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultSuggestionSlack is the margin left below the current coverage in
+// the suggested thresholds, so unrelated changes do not fail right away
+const DefaultSuggestionSlack = 2.0
+
+func suggestCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "suggest",
+		Usage: "suggest thresholds from the current coverage, as a configuration block",
+		Flags: append(correctionFlags(),
+			&cli.Float64Flag{
+				Name:  "slack",
+				Usage: "percentage points left below the current coverage",
+				Value: DefaultSuggestionSlack,
+			},
+		),
+		Action: func(c *cli.Context) error {
+			correction, err := correctCoverage(c)
+			if err != nil {
+				return err
+			}
+			defer correction.Warnings.flush()
+			return writeSuggestedThresholds(os.Stdout, suggestThresholds(correction.Report, c.Float64("slack")))
+		},
+	}
+}
+
+// suggestThresholds returns thresholds just below the current coverage,
+// rounded down to the percent
+func suggestThresholds(report *Report, slack float64) Thresholds {
+	suggest := func(coverage float64) float64 {
+		return math.Max(0, math.Floor(coverage-slack))
+	}
+	thresholds := Thresholds{
+		Total:    suggest(report.Total.Coverage),
+		Packages: map[string]float64{},
+	}
+	for _, pkg := range report.Packages {
+		thresholds.Packages[pkg.Package] = suggest(pkg.Coverage)
+	}
+	return thresholds
+}
+
+func writeSuggestedThresholds(w io.Writer, thresholds Thresholds) error {
+	block := struct {
+		Thresholds struct {
+			Total    float64            `yaml:"total"`
+			Packages map[string]float64 `yaml:"packages"`
+		} `yaml:"thresholds"`
+	}{}
+	block.Thresholds.Total = thresholds.Total
+	block.Thresholds.Packages = thresholds.Packages
+	fmt.Fprintf(w, "# suggested from the current coverage, to add to %s\n", DefaultConfigFile)
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(block); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
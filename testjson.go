@@ -0,0 +1,183 @@
+//coverage:ignore file
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/urfave/cli/v2"
+)
+
+func testJSONCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "test-json",
+		Usage:     "run go test -json with the arguments, or read its stream from the standard input, and summarize the raw and corrected coverage per package",
+		ArgsUsage: "[go test arguments]",
+		Flags: append(correctionFlags(),
+			&cli.StringFlag{
+				Name:    "output",
+				Aliases: []string{"o"},
+				Usage:   "write the corrected coverage file too",
+			},
+		),
+		Action: func(c *cli.Context) error {
+			var run *TestRun
+			var err error
+			ctx := c
+			if c.NArg() > 0 {
+				//the tests are run here, with the coverage profile in a temporary
+				//directory unless --file names it
+				profile := c.String("file")
+				if profile == "" {
+					tmp, err := os.MkdirTemp("", "go-ignore-cov-test-json")
+					if err != nil {
+						return err
+					}
+					defer os.RemoveAll(tmp)
+					profile = filepath.Join(tmp, "coverage.out")
+					set := flag.NewFlagSet("test-json", flag.ContinueOnError)
+					set.String("file", profile, "")
+					ctx = cli.NewContext(c.App, set, c)
+				}
+				if run, err = runTests(c.String("root"), profile, c.Args().Slice()); err != nil {
+					return err
+				}
+			} else {
+				if c.String("file") == "" {
+					return fmt.Errorf("the go test -json stream does not name the coverage profile, pass it with --file, or pass the go test arguments to run the tests, like go-ignore-cov test-json ./...")
+				}
+				if run, err = readTestEvents(os.Stdin); err != nil {
+					return err
+				}
+			}
+			correction, err := correctCoverage(ctx)
+			if err != nil {
+				return err
+			}
+			defer correction.Warnings.flush()
+			if output := c.String("output"); output != "" {
+				if sameFile(ctx.String("file"), output) {
+					return fmt.Errorf("the output file is the input file %s", output)
+				}
+				if err := writeProfilesToFile(correction.Profiles, output, false); err != nil {
+					return err
+				}
+			}
+			printTestRunSummary(os.Stdout, run, correction.Report)
+			if len(run.Failed) > 0 {
+				return fmt.Errorf("%d package(s) failed", len(run.Failed))
+			}
+			return nil
+		},
+	}
+}
+
+// runTests runs go test -json in the root with the arguments, writing the
+// coverage profile to the file, and reads its stream. The failed tests are
+// reported by the stream, go test only fails on its own when nothing is.
+func runTests(root string, profile string, args []string) (*TestRun, error) {
+	cmd := exec.Command("go", append([]string{"test", "-json", "-coverprofile", profile}, args...)...)
+	cmd.Dir = root
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	run, readErr := readTestEvents(stdout)
+	if err := cmd.Wait(); err != nil && (readErr != nil || len(run.Failed) == 0) {
+		return nil, fmt.Errorf("go test failed: %w", err)
+	}
+	return run, readErr
+}
+
+// TestEvent is an event of go test -json, see go doc test2json
+type TestEvent struct {
+	Action  string
+	Package string
+	Test    string
+	Output  string
+}
+
+// TestRun is what is kept from a go test -json stream
+type TestRun struct {
+	// Coverage is the raw coverage percentage printed for each package
+	Coverage map[string]float64
+	Failed   []string
+}
+
+var coverageOutput = regexp.MustCompile(`coverage: ([0-9.]+)% of statements`)
+
+// readTestEvents reads a go test -json stream. The output of the tests is
+// passed through, so the stream can be piped without losing the failures.
+func readTestEvents(r io.Reader) (*TestRun, error) {
+	run := &TestRun{Coverage: map[string]float64{}}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		event := TestEvent{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			//not an event, like the output of the build
+			fmt.Fprintln(os.Stderr, scanner.Text())
+			continue
+		}
+		switch event.Action {
+		case "output":
+			if event.Test != "" {
+				continue
+			}
+			if matches := coverageOutput.FindStringSubmatch(event.Output); matches != nil {
+				run.Coverage[event.Package], _ = strconv.ParseFloat(matches[1], 64)
+			}
+		case "fail":
+			if event.Test == "" {
+				run.Failed = append(run.Failed, event.Package)
+			} else {
+				fmt.Fprintf(os.Stderr, "--- FAIL: %s %s\n", event.Package, event.Test)
+			}
+		}
+	}
+	return run, scanner.Err()
+}
+
+func printTestRunSummary(w io.Writer, run *TestRun, report *Report) {
+	corrected := map[string]float64{}
+	for _, pkg := range report.Packages {
+		corrected[pkg.Package] = pkg.Coverage
+	}
+	packages := []string{}
+	for pkg := range run.Coverage {
+		packages = append(packages, pkg)
+	}
+	for pkg := range corrected {
+		if _, ok := run.Coverage[pkg]; !ok {
+			packages = append(packages, pkg)
+		}
+	}
+	sort.Strings(packages)
+	for _, pkg := range packages {
+		raw, correctedCoverage := "-", "-"
+		if coverage, ok := run.Coverage[pkg]; ok {
+			raw = fmt.Sprintf("%.1f%%", coverage)
+		}
+		if coverage, ok := corrected[pkg]; ok {
+			correctedCoverage = fmt.Sprintf("%.1f%%", coverage)
+		}
+		fmt.Fprintf(w, "%s\traw %s\tcorrected %s\n", pkg, raw, correctedCoverage)
+	}
+	for _, pkg := range run.Failed {
+		fmt.Fprintf(w, "FAIL\t%s\n", pkg)
+	}
+	fmt.Fprintf(w, "total\tcorrected %.1f%%\n", report.Total.Coverage)
+}
@@ -0,0 +1,116 @@
+//coverage:ignore file
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+const exemptionDateLayout = "2006-01-02"
+
+// expiry returns the time the exemption stops applying, the day after its
+// until date
+func (e Exemption) expiry() (time.Time, error) {
+	until, err := time.Parse(exemptionDateLayout, e.Until)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid until date [%s], expected YYYY-MM-DD", e.Until)
+	}
+	return until.AddDate(0, 0, 1), nil
+}
+
+// matchesPackage reports whether pattern designates the package import path,
+// either fully or by its trailing path elements
+func matchesPackage(pattern string, pkg string) bool {
+	return pkg == pattern || strings.HasSuffix(pkg, "/"+pattern)
+}
+
+// threshold returns the threshold of a package
+func (t Thresholds) threshold(pkg string) float64 {
+	for pattern, threshold := range t.Packages {
+		if pattern == pkg {
+			return threshold
+		}
+	}
+	best, threshold := "", t.Package
+	for pattern, value := range t.Packages {
+		if matchesPackage(pattern, pkg) && len(pattern) > len(best) {
+			best, threshold = pattern, value
+		}
+	}
+	return threshold
+}
+
+// checkThresholds fails when the corrected coverage is below the thresholds
+// of the configuration. The failures of the exempted packages are only
+// reported, until their exemption expires.
+func checkThresholds(w io.Writer, report *Report, config *Config, now time.Time) error {
+	failures := []string{}
+	//the total of a shard is partial, it is checked by merge-reports
+	if config.Thresholds.Total > 0 && report.Shard == "" && report.Total.Coverage < config.Thresholds.Total {
+		failures = append(failures, fmt.Sprintf("total coverage %.1f%% is below the threshold of %.1f%%",
+			report.Total.Coverage, config.Thresholds.Total))
+	}
+	for _, pkg := range report.Packages {
+		threshold := config.Thresholds.threshold(pkg.Package)
+		if threshold <= 0 || pkg.Coverage >= threshold {
+			continue
+		}
+		failure := fmt.Sprintf("package %s coverage %.1f%% is below the threshold of %.1f%%", pkg.Package, pkg.Coverage, threshold)
+		exemption, found := findExemption(config.Exempt, pkg.Package)
+		if !found {
+			failures = append(failures, failure)
+			continue
+		}
+		expiry, _ := exemption.expiry()
+		if !now.Before(expiry) {
+			failures = append(failures, fmt.Sprintf("%s, its exemption expired on %s", failure, exemption.Until))
+			continue
+		}
+		fmt.Fprintf(w, "Exempted until %s: %s (%s)\n", exemption.Until, failure, exemption.Reason)
+	}
+	for _, name := range sortedKeys(config.Thresholds.Groups) {
+		threshold := config.Thresholds.Groups[name]
+		stats, files := groupStats(report, config.groups[name])
+		if files > 0 && stats.Coverage < threshold {
+			failures = append(failures, fmt.Sprintf("group %s coverage %.1f%% is below the threshold of %.1f%%", name, stats.Coverage, threshold))
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("coverage thresholds not met:\n  %s", strings.Join(failures, "\n  "))
+	}
+	return nil
+}
+
+// groupStats returns the coverage of the files of the report matching the
+// patterns of a group, along with the number of files
+func groupStats(report *Report, patterns PathPatterns) (CoverageStats, int) {
+	stats, files := CoverageStats{}, 0
+	for _, file := range report.Files {
+		if patterns.matches(FilePath{Rel: file.Path, Import: file.FileName}) {
+			stats.add(file.CoverageStats)
+			files++
+		}
+	}
+	return stats, files
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func findExemption(exemptions []Exemption, pkg string) (Exemption, bool) {
+	for _, exemption := range exemptions {
+		if matchesPackage(exemption.Package, pkg) {
+			return exemption, true
+		}
+	}
+	return Exemption{}, false
+}
@@ -0,0 +1,158 @@
+//coverage:ignore file
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// ProfileMemoryFactor estimates the memory needed to correct a profile from
+// its size, the parsed blocks, the report and the corrected profile taking
+// several times the size of the text
+const ProfileMemoryFactor = 8
+
+// profileLimiter queues the profiles corrected by the server, so that at most
+// maxProfiles are corrected at once and their estimated memory stays within
+// the budget. A zero limit or budget is unlimited. The profiles are admitted
+// in the order they arrive, a large profile is not overtaken by the smaller
+// ones fitting beside the running profiles.
+type profileLimiter struct {
+	maxProfiles int
+	budget      int64
+
+	mu       sync.Mutex
+	waiters  []*profileWaiter
+	running  int
+	reserved int64
+	// the metrics, the wait times of the admitted profiles
+	admitted  int64
+	rejected  int64
+	waitTotal time.Duration
+	waitMax   time.Duration
+}
+
+// profileWaiter is a profile queued by the limiter, ready is closed and done
+// set once it is admitted
+type profileWaiter struct {
+	memory int64
+	ready  chan struct{}
+	done   bool
+}
+
+func newProfileLimiter(maxProfiles int, budget int64) *profileLimiter {
+	return &profileLimiter{maxProfiles: maxProfiles, budget: budget}
+}
+
+// estimate returns the memory estimated to correct a profile of this size
+func (l *profileLimiter) estimate(size int64) int64 {
+	return size * ProfileMemoryFactor
+}
+
+func (l *profileLimiter) fits(memory int64) bool {
+	if l.maxProfiles > 0 && l.running >= l.maxProfiles {
+		return false
+	}
+	//a profile is always admitted alone, it is checked against the budget beforehand
+	return l.budget == 0 || l.running == 0 || l.reserved+memory <= l.budget
+}
+
+// admitWaiters admits the queued profiles in order, as long as the first one
+// fits
+func (l *profileLimiter) admitWaiters() {
+	for len(l.waiters) > 0 && l.fits(l.waiters[0].memory) {
+		waiter := l.waiters[0]
+		l.waiters = l.waiters[1:]
+		l.running++
+		l.reserved += waiter.memory
+		waiter.done = true
+		close(waiter.ready)
+	}
+}
+
+// releaseMemory ends the correction of a profile and admits the next ones
+func (l *profileLimiter) releaseMemory(memory int64) {
+	l.running--
+	l.reserved -= memory
+	l.admitWaiters()
+}
+
+// acquire waits for a slot and enough of the budget to correct a profile of
+// this size, and returns the function releasing them. The wait ends with the
+// context, when the client goes away.
+func (l *profileLimiter) acquire(ctx context.Context, size int64) (func(), error) {
+	memory := l.estimate(size)
+	start := time.Now()
+	l.mu.Lock()
+	if l.budget > 0 && memory > l.budget {
+		l.rejected++
+		l.mu.Unlock()
+		return nil, fmt.Errorf("the profile needs about %d MiB, more than the memory budget of %d MiB", memory>>20, l.budget>>20)
+	}
+	waiter := &profileWaiter{memory: memory, ready: make(chan struct{})}
+	l.waiters = append(l.waiters, waiter)
+	l.admitWaiters()
+	l.mu.Unlock()
+	select {
+	case <-waiter.ready:
+	case <-ctx.Done():
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if waiter.done {
+			//admitted meanwhile, its slot goes to the next profiles
+			l.releaseMemory(memory)
+			return nil, ctx.Err()
+		}
+		for i, queued := range l.waiters {
+			if queued == waiter {
+				l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+				break
+			}
+		}
+		//the profiles queued behind it may fit now
+		l.admitWaiters()
+		return nil, ctx.Err()
+	}
+	l.mu.Lock()
+	l.admitted++
+	wait := time.Since(start)
+	l.waitTotal += wait
+	if wait > l.waitMax {
+		l.waitMax = wait
+	}
+	l.mu.Unlock()
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.releaseMemory(memory)
+	}, nil
+}
+
+// writeMetrics writes the metrics of the queue in the Prometheus text format
+func (l *profileLimiter) writeMetrics(w io.Writer) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	metrics := []struct {
+		name  string
+		kind  string
+		help  string
+		value interface{}
+	}{
+		{"go_ignore_cov_queued_profiles", "gauge", "Profiles waiting to be corrected.", len(l.waiters)},
+		{"go_ignore_cov_running_profiles", "gauge", "Profiles being corrected.", l.running},
+		{"go_ignore_cov_reserved_memory_bytes", "gauge", "Memory estimated for the profiles being corrected.", l.reserved},
+		{"go_ignore_cov_memory_budget_bytes", "gauge", "Memory budget of the profiles corrected at once, 0 when unlimited.", l.budget},
+		{"go_ignore_cov_admitted_profiles_total", "counter", "Profiles admitted for correction.", l.admitted},
+		{"go_ignore_cov_rejected_profiles_total", "counter", "Profiles rejected as larger than the memory budget.", l.rejected},
+		{"go_ignore_cov_queue_wait_seconds_total", "counter", "Time the admitted profiles waited in the queue.", l.waitTotal.Seconds()},
+		{"go_ignore_cov_queue_wait_seconds_max", "gauge", "Longest time a profile waited in the queue.", l.waitMax.Seconds()},
+	}
+	for _, m := range metrics {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", m.name, m.help, m.name, m.kind, m.name, m.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitQueued waits until n profiles are queued by the limiter
+func waitQueued(t *testing.T, l *profileLimiter, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		l.mu.Lock()
+		queued := len(l.waiters)
+		l.mu.Unlock()
+		if queued == n {
+			return
+		}
+	}
+	t.Fatalf("expected %d queued profiles", n)
+}
+
+// acquireAsync acquires the limiter in a goroutine, the channel receives the
+// release function once admitted
+func acquireAsync(ctx context.Context, l *profileLimiter, size int64) (chan func(), chan error) {
+	admitted, failed := make(chan func(), 1), make(chan error, 1)
+	go func() {
+		release, err := l.acquire(ctx, size)
+		if err != nil {
+			failed <- err
+			return
+		}
+		admitted <- release
+	}()
+	return admitted, failed
+}
+
+func TestProfileLimiterConcurrency(t *testing.T) {
+	l := newProfileLimiter(1, 0)
+	release, err := l.acquire(context.Background(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	admitted, _ := acquireAsync(context.Background(), l, 10)
+	waitQueued(t, l, 1)
+	select {
+	case <-admitted:
+		t.Fatal("expected the second profile to wait for the first one")
+	default:
+	}
+	release()
+	select {
+	case release := <-admitted:
+		release()
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the second profile to be admitted once the first one is released")
+	}
+}
+
+func TestProfileLimiterBudget(t *testing.T) {
+	l := newProfileLimiter(0, 100*ProfileMemoryFactor)
+	if _, err := l.acquire(context.Background(), 101); err == nil || !strings.Contains(err.Error(), "more than the memory budget") {
+		t.Fatalf("expected a profile larger than the budget to be rejected, got %v", err)
+	}
+	release, err := l.acquire(context.Background(), 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//a small profile fits beside the first one, a larger one waits
+	small, err := l.acquire(context.Background(), 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	small()
+	admitted, _ := acquireAsync(context.Background(), l, 50)
+	waitQueued(t, l, 1)
+	release()
+	select {
+	case release := <-admitted:
+		release()
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the profile to be admitted once the budget is released")
+	}
+}
+
+func TestProfileLimiterOrder(t *testing.T) {
+	l := newProfileLimiter(0, 100*ProfileMemoryFactor)
+	release, err := l.acquire(context.Background(), 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	large, _ := acquireAsync(context.Background(), l, 80)
+	waitQueued(t, l, 1)
+	//the small profile fits beside the first one, but waits behind the large one
+	small, _ := acquireAsync(context.Background(), l, 30)
+	waitQueued(t, l, 2)
+	release()
+	var releaseLarge func()
+	select {
+	case releaseLarge = <-large:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the large profile to be admitted first")
+	}
+	select {
+	case <-small:
+		t.Fatal("expected the small profile to wait for the large one")
+	default:
+	}
+	releaseLarge()
+	select {
+	case release := <-small:
+		release()
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the small profile to be admitted once the large one is released")
+	}
+}
+
+func TestProfileLimiterCanceled(t *testing.T) {
+	l := newProfileLimiter(1, 0)
+	release, err := l.acquire(context.Background(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	ctx, cancel := context.WithCancel(context.Background())
+	_, failed := acquireAsync(ctx, l, 10)
+	waitQueued(t, l, 1)
+	cancel()
+	select {
+	case err := <-failed:
+		if err != context.Canceled {
+			t.Errorf("expected the wait to be canceled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the wait to end with the context")
+	}
+	waitQueued(t, l, 0)
+}
+
+func TestProfileLimiterCanceledFirst(t *testing.T) {
+	l := newProfileLimiter(0, 100*ProfileMemoryFactor)
+	release, err := l.acquire(context.Background(), 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	ctx, cancel := context.WithCancel(context.Background())
+	acquireAsync(ctx, l, 80)
+	waitQueued(t, l, 1)
+	small, _ := acquireAsync(context.Background(), l, 30)
+	waitQueued(t, l, 2)
+	//the profile behind the canceled one fits beside the running one
+	cancel()
+	select {
+	case release := <-small:
+		release()
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the small profile to be admitted once the large one left the queue")
+	}
+}
+
+func TestProfileLimiterMetrics(t *testing.T) {
+	l := newProfileLimiter(2, 1<<20)
+	release, err := l.acquire(context.Background(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	var out bytes.Buffer
+	if err := l.writeMetrics(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"# TYPE go_ignore_cov_running_profiles gauge\ngo_ignore_cov_running_profiles 1\n",
+		"go_ignore_cov_reserved_memory_bytes 80\n",
+		"go_ignore_cov_memory_budget_bytes 1048576\n",
+		"go_ignore_cov_admitted_profiles_total 1\n",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("expected the metrics to contain %q, got:\n%s", expected, out.String())
+		}
+	}
+}
@@ -0,0 +1,116 @@
+//coverage:ignore file
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/cover"
+)
+
+// MatchTolerance lets a block directive matching no block match the closest
+// block starting within a few lines or columns, when a formatter or a
+// generator moved the code after the directive was written
+type MatchTolerance struct {
+	Lines int
+	Cols  int
+}
+
+// parseMatchTolerance parses a tolerance written kind:n, like lines:1 or
+// cols:4, several kinds are separated with commas
+func parseMatchTolerance(spec string) (MatchTolerance, error) {
+	tolerance := MatchTolerance{}
+	if spec == "" {
+		return tolerance, nil
+	}
+	for _, part := range strings.Split(spec, ",") {
+		kind, value, found := strings.Cut(part, ":")
+		n, err := strconv.Atoi(value)
+		if !found || err != nil || n < 0 {
+			return MatchTolerance{}, fmt.Errorf("invalid match tolerance [%s], expected lines:n or cols:n", part)
+		}
+		switch kind {
+		case "lines":
+			tolerance.Lines = n
+		case "cols":
+			tolerance.Cols = n
+		default:
+			return MatchTolerance{}, fmt.Errorf("invalid match tolerance [%s], expected lines:n or cols:n", part)
+		}
+	}
+	return tolerance, nil
+}
+
+// closest returns the block starting the closest to the position within the
+// tolerance. A block on the same line is closer than a block on another
+// line, and a block after the position is closer than one as far before it.
+func (t MatchTolerance) closest(line int, col int, blocks []cover.ProfileBlock) (cover.ProfileBlock, bool) {
+	var best cover.ProfileBlock
+	bestDistance := -1
+	for _, block := range blocks {
+		lines, cols := block.StartLine-line, block.StartCol-col
+		if abs(lines) > t.Lines || (lines == 0 && abs(cols) > t.Cols) {
+			continue
+		}
+		//the lines weigh more than the columns, and the blocks before the
+		//position lose the ties
+		distance := 2 * abs(lines) * (2*MaxCol + 2)
+		if lines < 0 {
+			distance += 2*MaxCol + 2
+		}
+		if lines == 0 {
+			distance += 2 * abs(cols)
+			if cols < 0 {
+				distance++
+			}
+		}
+		if bestDistance < 0 || distance < bestDistance {
+			best, bestDistance = block, distance
+		}
+	}
+	return best, bestDistance >= 0
+}
+
+// apply anchors the block directives matching no block to the closest block
+// within the tolerance. The instructions of the file are copied.
+func (t MatchTolerance) apply(ignore *IgnoreCoverage, path string, blocks []cover.ProfileBlock, verbose bool) *IgnoreCoverage {
+	if t.Lines == 0 && t.Cols == 0 {
+		return ignore
+	}
+	instructions := make([]Instruction, len(ignore.Instructions))
+	for i, instruction := range ignore.Instructions {
+		instructions[i] = instruction
+		ig, ok := instruction.(IgnoreBlock)
+		if !ok || matchesAny(ig, blocks) {
+			continue
+		}
+		block, found := t.closest(ig.Line, ig.Col, blocks)
+		if !found {
+			continue
+		}
+		if verbose {
+			fmt.Printf("Matching the directive of line %d with the block [%d.%d] => [%d.%d] of %s within the tolerance\n",
+				ig.Line, block.StartLine, block.StartCol, block.EndLine, block.EndCol, path)
+		}
+		ig.Anchor = &IgnoreRange{StartLine: block.StartLine, StartCol: block.StartCol, EndLine: block.StartLine, EndCol: block.StartCol}
+		instructions[i] = ig
+	}
+	return &IgnoreCoverage{Filepath: ignore.Filepath, Instructions: instructions}
+}
+
+func matchesAny(ig IgnoreBlock, blocks []cover.ProfileBlock) bool {
+	for _, block := range blocks {
+		if ig.Matches(block) {
+			return true
+		}
+	}
+	return false
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
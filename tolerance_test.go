@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+
+	"golang.org/x/tools/cover"
+)
+
+func TestParseMatchTolerance(t *testing.T) {
+	tests := []struct {
+		spec      string
+		tolerance MatchTolerance
+		valid     bool
+	}{
+		{spec: "", valid: true},
+		{spec: "lines:1", tolerance: MatchTolerance{Lines: 1}, valid: true},
+		{spec: "lines:2,cols:4", tolerance: MatchTolerance{Lines: 2, Cols: 4}, valid: true},
+		{spec: "lines"},
+		{spec: "lines:-1"},
+		{spec: "rows:1"},
+		{spec: "lines:1,"},
+	}
+	for _, tt := range tests {
+		tolerance, err := parseMatchTolerance(tt.spec)
+		if (err == nil) != tt.valid || tolerance != tt.tolerance {
+			t.Errorf("%q: expected %+v valid %v, got %+v, %v", tt.spec, tt.tolerance, tt.valid, tolerance, err)
+		}
+	}
+}
+
+func TestMatchToleranceClosest(t *testing.T) {
+	block := func(line int, col int) cover.ProfileBlock {
+		return cover.ProfileBlock{StartLine: line, StartCol: col, EndLine: line + 1, EndCol: 2, NumStmt: 1}
+	}
+	tests := []struct {
+		name      string
+		tolerance MatchTolerance
+		blocks    []cover.ProfileBlock
+		found     bool
+		line      int
+		col       int
+	}{
+		{name: "next line", tolerance: MatchTolerance{Lines: 1}, blocks: []cover.ProfileBlock{block(11, 2)}, found: true, line: 11, col: 2},
+		{name: "too far", tolerance: MatchTolerance{Lines: 1}, blocks: []cover.ProfileBlock{block(12, 2), block(8, 2)}},
+		{name: "after wins the tie", tolerance: MatchTolerance{Lines: 1}, blocks: []cover.ProfileBlock{block(9, 2), block(11, 2)}, found: true, line: 11, col: 2},
+		{name: "same line first", tolerance: MatchTolerance{Lines: 1, Cols: 8}, blocks: []cover.ProfileBlock{block(11, 2), block(10, 9)}, found: true, line: 10, col: 9},
+		{name: "columns only on the same line", tolerance: MatchTolerance{Cols: 8}, blocks: []cover.ProfileBlock{block(11, 5)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			closest, found := tt.tolerance.closest(10, 5, tt.blocks)
+			if found != tt.found || (found && (closest.StartLine != tt.line || closest.StartCol != tt.col)) {
+				t.Errorf("expected %v %d.%d, got %v %d.%d", tt.found, tt.line, tt.col, found, closest.StartLine, closest.StartCol)
+			}
+		})
+	}
+}
+
+func TestMatchToleranceApply(t *testing.T) {
+	blocks := []cover.ProfileBlock{
+		{StartLine: 5, StartCol: 2, EndLine: 5, EndCol: 20, NumStmt: 1},
+		{StartLine: 8, StartCol: 3, EndLine: 9, EndCol: 3, NumStmt: 1},
+	}
+	ignore := &IgnoreCoverage{Filepath: "drift.go", Instructions: []Instruction{
+		//matches the first block without the tolerance
+		IgnoreBlock{Line: 5, Col: 2},
+		//the block moved one line down
+		IgnoreBlock{Line: 7, Col: 3},
+		IgnoreBlock{Line: 2, Col: 2},
+	}}
+	applied := MatchTolerance{Lines: 1}.apply(ignore, "drift.go", blocks, false)
+	if ignore.Instructions[1].Matches(blocks[1]) {
+		t.Errorf("expected the instructions of the file to be copied")
+	}
+	for i, expected := range []bool{true, true, false} {
+		matched := false
+		for _, block := range blocks {
+			matched = matched || applied.Instructions[i].Matches(block)
+		}
+		if matched != expected {
+			t.Errorf("instruction %d: expected a match %v, got %v", i, expected, matched)
+		}
+	}
+}
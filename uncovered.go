@@ -0,0 +1,155 @@
+//coverage:ignore file
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"golang.org/x/tools/cover"
+)
+
+func uncoveredCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "uncovered",
+		Usage: "list the line ranges left uncovered once the ignore instructions are applied, largest first",
+		Flags: append(correctionFlags(),
+			&cli.IntFlag{
+				Name:  "top",
+				Usage: "only list the N largest ranges",
+			},
+			&cli.BoolFlag{
+				Name:  "blame",
+				Usage: "show the last commit changing each range, with its author and date, at HEAD or at --source-ref",
+			},
+		),
+		Action: func(c *cli.Context) error {
+			correction, err := correctCoverage(c)
+			if err != nil {
+				return err
+			}
+			defer correction.Warnings.flush()
+			ranges := uncoveredRanges(correction.Profiles, correction.Report)
+			if top := c.Int("top"); top > 0 && top < len(ranges) {
+				ranges = ranges[:top]
+			}
+			if c.Bool("blame") {
+				ref := c.String("source-ref")
+				if ref == "" {
+					ref = "HEAD"
+				}
+				if err := blameRanges(ranges, correction.Root, ref); err != nil {
+					return err
+				}
+			}
+			printUncoveredRanges(os.Stdout, ranges)
+			return nil
+		},
+	}
+}
+
+// UncoveredRange is a range of consecutive uncovered blocks
+type UncoveredRange struct {
+	Path       string
+	StartLine  int
+	EndLine    int
+	Statements int
+	// Blame is the most recent change of the lines of the range, with
+	// --blame, nil when the file is not committed
+	Blame *BlameLine
+}
+
+// uncoveredRanges returns the uncovered ranges of the corrected profiles,
+// sorted by number of statements. Soft ignored blocks are still in the
+// profiles, the report is used to leave them out.
+func uncoveredRanges(profiles []*cover.Profile, report *Report) []UncoveredRange {
+	ranges := []UncoveredRange{}
+	for i, profile := range profiles {
+		file := report.Files[i]
+		excluded := map[[4]int]bool{}
+		for _, e := range file.Exclusions {
+			excluded[[4]int{e.StartLine, e.StartCol, e.EndLine, e.EndCol}] = true
+		}
+		var current *UncoveredRange
+		for _, block := range profile.Blocks {
+			if block.NumStmt == 0 {
+				continue
+			}
+			if block.Count > 0 || excluded[[4]int{block.StartLine, block.StartCol, block.EndLine, block.EndCol}] {
+				current = nil
+				continue
+			}
+			if current != nil && block.StartLine <= current.EndLine+1 {
+				current.EndLine = block.EndLine
+				current.Statements += block.NumStmt
+				continue
+			}
+			ranges = append(ranges, UncoveredRange{
+				Path:       file.Path,
+				StartLine:  block.StartLine,
+				EndLine:    block.EndLine,
+				Statements: block.NumStmt,
+			})
+			current = &ranges[len(ranges)-1]
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].Statements > ranges[j].Statements
+	})
+	return ranges
+}
+
+// blameRanges sets the most recent change of the lines of each range at the
+// ref, the ranges are relative to root
+func blameRanges(ranges []UncoveredRange, root string, ref string) error {
+	vcs, err := openVCS(root)
+	if err != nil {
+		return err
+	}
+	repoRoot, err := vcs.Root()
+	if err != nil {
+		return err
+	}
+	blames := map[string][]BlameLine{}
+	for i, r := range ranges {
+		path := filepath.FromSlash(r.Path)
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(root, path)
+		}
+		rel, err := filepath.Rel(repoRoot, path)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		file := filepath.ToSlash(rel)
+		lines, ok := blames[file]
+		if !ok {
+			//the files missing at the ref have no blame
+			lines, _ = vcs.Blame(ref, file)
+			blames[file] = lines
+		}
+		for line := r.StartLine; line <= r.EndLine && line <= len(lines); line++ {
+			if blame := lines[line-1]; ranges[i].Blame == nil || blame.Date.After(ranges[i].Blame.Date) {
+				ranges[i].Blame = &blame
+			}
+		}
+	}
+	return nil
+}
+
+func printUncoveredRanges(w io.Writer, ranges []UncoveredRange) {
+	if len(ranges) == 0 {
+		fmt.Fprintln(w, "No uncovered statements")
+		return
+	}
+	for _, r := range ranges {
+		if r.Blame != nil {
+			fmt.Fprintf(w, "%s:%d-%d\t%d statements\t%.8s %s %s\n", r.Path, r.StartLine, r.EndLine, r.Statements, r.Blame.Commit, r.Blame.Author, r.Blame.Date.Format("2006-01-02"))
+			continue
+		}
+		fmt.Fprintf(w, "%s:%d-%d\t%d statements\n", r.Path, r.StartLine, r.EndLine, r.Statements)
+	}
+}
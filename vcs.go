@@ -0,0 +1,224 @@
+//coverage:ignore file
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+)
+
+// VCS is the set of version control operations needed by the git related
+// features. Paths are always relative to the repository root and use forward
+// slashes.
+type VCS interface {
+	// Root returns the absolute path of the repository top level directory
+	Root() (string, error)
+	// ResolveRef returns the commit hash for a ref (branch, tag, sha, ...)
+	ResolveRef(ref string) (string, error)
+	// ListFiles returns all the files tracked at the given ref
+	ListFiles(ref string) ([]string, error)
+	// ReadFile returns the content of a file at the given ref
+	ReadFile(ref string, path string) ([]byte, error)
+	// AddedLines returns, per file, the line ranges added or modified in the
+	// working tree since the merge base of the base ref and HEAD, so the
+	// changes of the base branch after branching are left out. The untracked
+	// files are added as a whole.
+	AddedLines(base string) (map[string][]LineRange, error)
+	// Blame returns, for each line of a file at the given ref, the commit
+	// that last changed it
+	Blame(ref string, path string) ([]BlameLine, error)
+}
+
+// BlameLine is the last change of a line of a file
+type BlameLine struct {
+	Commit string
+	// Author is the email address of the author of the commit
+	Author string
+	Date   time.Time
+}
+
+type LineRange struct {
+	Start int
+	End   int
+}
+
+func (r LineRange) Contains(line int) bool {
+	return line >= r.Start && line <= r.End
+}
+
+// openVCS returns the VCS implementation for the repository containing dir:
+// go-git, which needs no git binary, like in the distroless images, or the
+// git command line when go-git cannot open the repository, for instance
+// because of a repository format it does not support.
+func openVCS(dir string) (VCS, error) {
+	repo, err := git.PlainOpenWithOptions(dir, &git.PlainOpenOptions{DetectDotGit: true, EnableDotGitCommonDir: true})
+	if err == nil {
+		return goGit{repo: repo}, nil
+	}
+	if _, lookErr := exec.LookPath("git"); lookErr != nil {
+		return nil, fmt.Errorf("opening the git repository of %s: %w", dir, err)
+	}
+	return gitCLI{dir: dir}, nil
+}
+
+// gitCLI implements VCS by executing the git binary
+type gitCLI struct {
+	dir string
+}
+
+func (g gitCLI) run(args ...string) ([]byte, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = g.dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s failed: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+func (g gitCLI) Root() (string, error) {
+	out, err := g.run("rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", err
+	}
+	return filepath.Clean(strings.TrimSpace(string(out))), nil
+}
+
+func (g gitCLI) ResolveRef(ref string) (string, error) {
+	out, err := g.run("rev-parse", "--verify", ref+"^{commit}")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (g gitCLI) ListFiles(ref string) ([]string, error) {
+	out, err := g.run("ls-tree", "-r", "--name-only", "--full-tree", ref)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			files = append(files, line)
+		}
+	}
+	return files, scanner.Err()
+}
+
+func (g gitCLI) ReadFile(ref string, path string) ([]byte, error) {
+	return g.run("show", ref+":"+path)
+}
+
+var hunkHeader = regexp.MustCompile(`^@@ -\d+(?:,\d+)? \+(\d+)(?:,(\d+))? @@`)
+
+func (g gitCLI) AddedLines(base string) (map[string][]LineRange, error) {
+	mergeBase, err := g.run("merge-base", base, "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	out, err := g.run("diff", "--unified=0", "--no-color", "--no-ext-diff", strings.TrimSpace(string(mergeBase)), "--")
+	if err != nil {
+		return nil, err
+	}
+	added, err := parseUnifiedDiff(out)
+	if err != nil {
+		return nil, err
+	}
+	untracked, err := g.run("ls-files", "--others", "--exclude-standard", "--full-name")
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(untracked))
+	for scanner.Scan() {
+		if file := scanner.Text(); file != "" {
+			added[file] = []LineRange{{Start: 1, End: math.MaxInt32}}
+		}
+	}
+	return added, scanner.Err()
+}
+
+func (g gitCLI) Blame(ref string, path string) ([]BlameLine, error) {
+	out, err := g.run("blame", "--line-porcelain", ref, "--", path)
+	if err != nil {
+		return nil, err
+	}
+	return parseBlame(out)
+}
+
+var blameHeader = regexp.MustCompile(`^([0-9a-f]{40}) \d+ \d+`)
+
+// parseBlame extracts the commit, the author and the date of each line of
+// the output of git blame --line-porcelain
+func parseBlame(out []byte) ([]BlameLine, error) {
+	lines := []BlameLine{}
+	current := BlameLine{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "\t"):
+			//the content of the line ends its entry
+			lines = append(lines, current)
+			current = BlameLine{}
+		case strings.HasPrefix(line, "author-mail "):
+			current.Author = strings.Trim(strings.TrimPrefix(line, "author-mail "), "<>")
+		case strings.HasPrefix(line, "author-time "):
+			seconds, err := strconv.ParseInt(strings.TrimPrefix(line, "author-time "), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid blame line [%s]: %w", line, err)
+			}
+			current.Date = time.Unix(seconds, 0)
+		default:
+			if matches := blameHeader.FindStringSubmatch(line); matches != nil {
+				current.Commit = matches[1]
+			}
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// parseUnifiedDiff extracts the added line ranges of a unified diff produced
+// with --unified=0
+func parseUnifiedDiff(diff []byte) (map[string][]LineRange, error) {
+	added := map[string][]LineRange{}
+	file := ""
+	scanner := bufio.NewScanner(bytes.NewReader(diff))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "+++ ") {
+			file = strings.TrimPrefix(strings.TrimPrefix(line, "+++ "), "b/")
+			if file == "/dev/null" {
+				file = ""
+			}
+			continue
+		}
+		matches := hunkHeader.FindStringSubmatch(line)
+		if matches == nil || file == "" {
+			continue
+		}
+		start, _ := strconv.Atoi(matches[1])
+		count := 1
+		if matches[2] != "" {
+			count, _ = strconv.Atoi(matches[2])
+		}
+		if count == 0 {
+			continue
+		}
+		added[file] = append(added[file], LineRange{Start: start, End: start + count - 1})
+	}
+	return added, scanner.Err()
+}
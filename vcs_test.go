@@ -0,0 +1,223 @@
+package main
+
+import (
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// testRepository is a git repository with a base branch and two commits on
+// top of it, of two authors, and changes in its working tree
+type testRepository struct {
+	dir     string
+	commits []string
+}
+
+func newTestRepository(t *testing.T) testRepository {
+	t.Helper()
+	dir := writeTestFiles(t, map[string]string{
+		"a.go":         "package a\n\nfunc A() int {\n\treturn 1\n}\n",
+		"old/gone.go":  "package old\n",
+		"unchanged.go": "package a\n",
+	})
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := testRepository{dir: dir}
+	commit := func(author string, day int) {
+		if err := wt.AddWithOptions(&git.AddOptions{All: true}); err != nil {
+			t.Fatal(err)
+		}
+		hash, err := wt.Commit("change", &git.CommitOptions{Author: &object.Signature{
+			Name:  author,
+			Email: author + "@example.com",
+			When:  time.Date(2024, 1, day, 12, 0, 0, 0, time.UTC),
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.commits = append(r.commits, hash.String())
+	}
+	commit("alice", 1)
+	if err := repo.Storer.SetReference(plumbing.NewHashReference("refs/heads/base", plumbing.NewHash(r.commits[0]))); err != nil {
+		t.Fatal(err)
+	}
+	r.write(t, "a.go", "package a\n\nfunc A() int {\n\treturn 2\n}\n\nfunc B() int {\n\treturn 3\n}\n")
+	if _, err := wt.Remove("old/gone.go"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	commit("bob", 2)
+	//the working tree changes a line and adds a file
+	r.write(t, "a.go", "package a\n\nfunc A() int {\n\treturn 2\n}\n\nfunc B() int {\n\treturn 4\n}\n")
+	r.write(t, "new.go", "package a\n\nvar N = 1\n")
+	return r
+}
+
+func (r testRepository) write(t *testing.T, name string, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(r.dir, name), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testVCS returns the implementations of VCS for the repository, the git
+// command line when git is installed
+func testVCS(t *testing.T, dir string) map[string]VCS {
+	t.Helper()
+	repo, err := git.PlainOpenWithOptions(dir, &git.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	implementations := map[string]VCS{"go-git": goGit{repo: repo}}
+	if _, err := exec.LookPath("git"); err == nil {
+		implementations["git"] = gitCLI{dir: dir}
+	}
+	return implementations
+}
+
+func TestVCS(t *testing.T) {
+	r := newTestRepository(t)
+	for name, vcs := range testVCS(t, filepath.Join(r.dir, "old")) {
+		t.Run(name, func(t *testing.T) {
+			root, err := vcs.Root()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if root != canonicalPath(r.dir) {
+				t.Errorf("expected the root %s, got %s", canonicalPath(r.dir), root)
+			}
+			for ref, expected := range map[string]string{"HEAD": r.commits[1], "HEAD~1": r.commits[0], "base": r.commits[0], r.commits[1][:10]: r.commits[1]} {
+				if commit, err := vcs.ResolveRef(ref); err != nil || commit != expected {
+					t.Errorf("expected %s to resolve to %s, got %s, %v", ref, expected, commit, err)
+				}
+			}
+			files, err := vcs.ListFiles(r.commits[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Strings(files)
+			if expected := []string{"a.go", "old/gone.go", "unchanged.go"}; !reflect.DeepEqual(files, expected) {
+				t.Errorf("expected the files %v, got %v", expected, files)
+			}
+			content, err := vcs.ReadFile("base", "a.go")
+			if err != nil || string(content) != "package a\n\nfunc A() int {\n\treturn 1\n}\n" {
+				t.Errorf("expected the content of a.go at the base, got %q, %v", content, err)
+			}
+			if _, err := vcs.ReadFile("HEAD", "old/gone.go"); err == nil {
+				t.Errorf("expected an error reading a removed file")
+			}
+		})
+	}
+}
+
+func TestVCSAddedLines(t *testing.T) {
+	r := newTestRepository(t)
+	expected := map[string][]LineRange{
+		//the lines changed in the commit and in the working tree, the closing
+		//brace of A matches the one of B
+		"a.go":   {{Start: 4, End: 8}},
+		"new.go": {{Start: 1, End: math.MaxInt32}},
+	}
+	for name, vcs := range testVCS(t, r.dir) {
+		t.Run(name, func(t *testing.T) {
+			added, err := vcs.AddedLines("base")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(added, expected) {
+				t.Errorf("expected the added lines %v, got %v", expected, added)
+			}
+		})
+	}
+}
+
+func TestVCSBlame(t *testing.T) {
+	r := newTestRepository(t)
+	for name, vcs := range testVCS(t, r.dir) {
+		t.Run(name, func(t *testing.T) {
+			lines, err := vcs.Blame("HEAD", "a.go")
+			if err != nil {
+				t.Fatal(err)
+			}
+			authors := []string{}
+			for _, line := range lines {
+				authors = append(authors, line.Author)
+			}
+			//the working tree is not blamed, the closing brace of B is the one of A
+			expected := []string{"alice@example.com", "alice@example.com", "alice@example.com", "bob@example.com", "bob@example.com",
+				"bob@example.com", "bob@example.com", "bob@example.com", "alice@example.com"}
+			if !reflect.DeepEqual(authors, expected) {
+				t.Errorf("expected the authors %v, got %v", expected, authors)
+			}
+			if len(lines) > 3 && (lines[3].Commit != r.commits[1] || !lines[3].Date.Equal(time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC))) {
+				t.Errorf("expected the line 4 to be changed by the second commit, got %+v", lines[3])
+			}
+		})
+	}
+}
+
+func TestAddedRanges(t *testing.T) {
+	tests := []struct {
+		name     string
+		previous string
+		current  string
+		expected []LineRange
+	}{
+		{name: "new file", previous: "", current: "a\nb\n", expected: []LineRange{{Start: 1, End: 2}}},
+		{name: "changed line", previous: "a\nb\nc\n", current: "a\nB\nc\n", expected: []LineRange{{Start: 2, End: 2}}},
+		{name: "removed line", previous: "a\nb\nc\n", current: "a\nc\n", expected: []LineRange{}},
+		{name: "no final newline", previous: "a\n", current: "a\nb", expected: []LineRange{{Start: 2, End: 2}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := addedRanges(tt.previous, tt.current); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("expected the ranges %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestBlameRanges(t *testing.T) {
+	r := newTestRepository(t)
+	ranges := []UncoveredRange{
+		{Path: "a.go", StartLine: 3, EndLine: 5},
+		{Path: "a.go", StartLine: 9, EndLine: 9},
+		{Path: "new.go", StartLine: 3, EndLine: 3},
+	}
+	if err := blameRanges(ranges, r.dir, "HEAD"); err != nil {
+		t.Fatal(err)
+	}
+	//the most recent change of the lines, the untracked file has no blame
+	if ranges[0].Blame == nil || ranges[0].Blame.Author != "bob@example.com" {
+		t.Errorf("expected the range to be blamed on the second commit, got %+v", ranges[0].Blame)
+	}
+	if ranges[1].Blame == nil || ranges[1].Blame.Author != "alice@example.com" {
+		t.Errorf("expected the range to be blamed on the first commit, got %+v", ranges[1].Blame)
+	}
+	if ranges[2].Blame != nil {
+		t.Errorf("expected no blame for an untracked file, got %+v", ranges[2].Blame)
+	}
+	var out strings.Builder
+	printUncoveredRanges(&out, ranges[:1])
+	if expected := "a.go:3-5\t0 statements\t" + r.commits[1][:8] + " bob@example.com 2024-01-02\n"; out.String() != expected {
+		t.Errorf("expected %q, got %q", expected, out.String())
+	}
+}
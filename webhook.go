@@ -0,0 +1,73 @@
+//coverage:ignore file
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// WebhookTimeout bounds the webhook request, a slow endpoint must not block
+// the pipeline
+const WebhookTimeout = 10 * time.Second
+
+// WebhookEvent is posted to the webhook once the coverage is corrected
+type WebhookEvent struct {
+	Total      CoverageStats      `json:"total"`
+	Packages   []PackageReport    `json:"packages"`
+	Exclusions []WebhookExclusion `json:"exclusions"`
+	Thresholds ThresholdsResult   `json:"thresholds"`
+}
+
+// WebhookExclusion is an exclusion along with the path of its file
+type WebhookExclusion struct {
+	Path string `json:"path"`
+	Exclusion
+}
+
+// ThresholdsResult is the outcome of the threshold checks
+type ThresholdsResult struct {
+	Passed bool   `json:"passed"`
+	Error  string `json:"error,omitempty"`
+}
+
+func newWebhookEvent(report *Report, thresholdsErr error) WebhookEvent {
+	event := WebhookEvent{
+		Total:      report.Total,
+		Packages:   report.Packages,
+		Exclusions: []WebhookExclusion{},
+		Thresholds: ThresholdsResult{Passed: thresholdsErr == nil},
+	}
+	if thresholdsErr != nil {
+		event.Thresholds.Error = thresholdsErr.Error()
+	}
+	for _, file := range report.Files {
+		for _, exclusion := range file.Exclusions {
+			event.Exclusions = append(event.Exclusions, WebhookExclusion{Path: file.Path, Exclusion: exclusion})
+		}
+	}
+	return event
+}
+
+// postWebhook posts the event as JSON, any response status other than 2xx
+// is an error
+func postWebhook(url string, event WebhookEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: WebhookTimeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("webhook %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook %s answered %s: %s", url, resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}